@@ -22,9 +22,7 @@ import (
 
 	"github.com/golang/glog"
 
-	"github.com/containerbuilding/cbi/pkg/plugin/backends/buildah"
-	"github.com/containerbuilding/cbi/pkg/plugin/base"
-	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/factory"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/cmd"
 )
 
@@ -35,28 +33,8 @@ func main() {
 		FlagSet: flag.CommandLine,
 		Args:    os.Args[1:],
 	}
-	var (
-		helperImage string
-		image       string
-	)
-	o.FlagSet.StringVar(&helperImage, "helper-image", "", "cbipluginhelper image")
-	o.FlagSet.StringVar(&image, "buildah-image", "", "image with /docker-build-push.sh, used for running buildah job")
-	o.CreateBackend = func() (base.Backend, error) {
-		if helperImage == "" {
-			glog.Fatal("no helper-image provided")
-		}
-		if image == "" {
-			glog.Fatal("no buildah-image provided")
-		}
-		b := &buildah.Buildah{
-			Helper: cbipluginhelper.Helper{
-				Image:   helperImage,
-				HomeDir: "/root",
-			},
-			Image: image,
-		}
-		return b, nil
-	}
+	helper := factory.HelperFlags(o.FlagSet, "")
+	o.CreateBackend = factory.Buildah(o.FlagSet, "", helper)
 	if err := cmd.Main(o); err != nil {
 		glog.Fatal(err)
 	}
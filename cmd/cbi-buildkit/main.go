@@ -22,9 +22,7 @@ import (
 
 	"github.com/golang/glog"
 
-	"github.com/containerbuilding/cbi/pkg/plugin/backends/buildkit"
-	"github.com/containerbuilding/cbi/pkg/plugin/base"
-	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/factory"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/cmd"
 )
 
@@ -35,34 +33,8 @@ func main() {
 		FlagSet: flag.CommandLine,
 		Args:    os.Args[1:],
 	}
-	var (
-		helperImage   string
-		buildctlImage string
-		buildkitdAddr string
-	)
-	o.FlagSet.StringVar(&helperImage, "helper-image", "", "cbipluginhelper image")
-	o.FlagSet.StringVar(&buildctlImage, "buildctl-image", "", "image used for running buildctl job")
-	o.FlagSet.StringVar(&buildkitdAddr, "buildkitd-addr", "", "buildkitd address (e.g. tcp://service:1234)")
-	o.CreateBackend = func() (base.Backend, error) {
-		if helperImage == "" {
-			glog.Fatal("no helper-image provided")
-		}
-		if buildctlImage == "" {
-			glog.Fatal("no buildctl-image provided")
-		}
-		if buildkitdAddr == "" {
-			glog.Fatal("no buildkitd-addr provided")
-		}
-		b := &buildkit.BuildKit{
-			Helper: cbipluginhelper.Helper{
-				Image:   helperImage,
-				HomeDir: "/root",
-			},
-			BuildctlImage: buildctlImage,
-			BuildkitdAddr: buildkitdAddr,
-		}
-		return b, nil
-	}
+	helper := factory.HelperFlags(o.FlagSet, "")
+	o.CreateBackend = factory.BuildKit(o.FlagSet, "", helper)
 	if err := cmd.Main(o); err != nil {
 		glog.Fatal(err)
 	}
@@ -0,0 +1,103 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// cbi-plugins hosts multiple backends in a single process.
+//
+// Each backend is served either on the shared port (`-cbi-plugin-port`),
+// where the requests are demultiplexed by the `cbi-plugin-name` gRPC metadata,
+// or on its own port (`-BACKEND.cbi-plugin-port`).
+//
+// e.g.
+//
+//	cbi-plugins -helper-image=... -backends=docker,buildkit \
+//	  -docker.docker-image=... \
+//	  -buildkit.buildctl-image=... -buildkit.buildkitd-addr=...
+//
+// cbid can connect to them with `-cbi-plugins=HOST:PORT/docker,HOST:PORT/buildkit`.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/golang/glog"
+
+	"github.com/containerbuilding/cbi/pkg/plugin"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/factory"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/service"
+)
+
+func main() {
+	fs := flag.CommandLine
+	var (
+		backendsStr string
+		port        int
+	)
+	fs.StringVar(&backendsStr, "backends", "", fmt.Sprintf("Comma-separated list of the backends to be enabled (%s)", strings.Join(factory.Names(), ", ")))
+	fs.IntVar(&port, "cbi-plugin-port", plugin.DefaultPort, "Port for listening CBI Plugin gRPC API, shared across the backends")
+	helper := factory.HelperFlags(fs, "")
+	creators := make(map[string]factory.CreateBackendFunc)
+	ports := make(map[string]*int)
+	for _, name := range factory.Names() {
+		prefix := name + "."
+		creators[name] = factory.Factories[name](fs, prefix, helper)
+		ports[name] = fs.Int(prefix+"cbi-plugin-port", 0, fmt.Sprintf("Dedicated port for %s (0 for sharing -cbi-plugin-port)", name))
+	}
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		glog.Fatal(err)
+	}
+	backends := strings.FieldsFunc(backendsStr, func(c rune) bool { return c == ',' || unicode.IsSpace(c) })
+	if len(backends) == 0 {
+		glog.Fatal("no backend specified")
+	}
+	muxes := make(map[int]*service.Mux)
+	for _, name := range backends {
+		create, ok := creators[name]
+		if !ok {
+			glog.Fatalf("unknown backend: %q", name)
+		}
+		b, err := create()
+		if err != nil {
+			glog.Fatalf("%s: %v", name, err)
+		}
+		p := port
+		if *ports[name] != 0 {
+			p = *ports[name]
+		}
+		mux, ok := muxes[p]
+		if !ok {
+			mux = &service.Mux{Services: make(map[string]*service.Service)}
+			muxes[p] = mux
+		}
+		if _, dup := mux.Services[name]; dup {
+			glog.Fatalf("backend %q specified multiple times", name)
+		}
+		mux.Services[name] = &service.Service{Backend: b}
+		glog.Infof("Serving backend %q on port %d", name, p)
+	}
+	errCh := make(chan error, len(muxes))
+	for p, mux := range muxes {
+		go func(p int, mux *service.Mux) {
+			errCh <- service.ServeTCP(mux, p)
+		}(p, mux)
+	}
+	if err := <-errCh; err != nil {
+		glog.Fatalf("Error serving CBI plugin API: %s", err.Error())
+	}
+}
@@ -0,0 +1,333 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+var buildCommand = &cli.Command{
+	Name:      "build",
+	Usage:     "create a buildjob, follow the logs, and wait for the completion",
+	ArgsUsage: "[flags]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "name of the buildjob (generated when empty)",
+		},
+		&cli.StringFlag{
+			Name:    "target",
+			Aliases: []string{"t"},
+			Usage:   "image reference to be pushed, e.g. example.com/foo/bar:latest",
+		},
+		&cli.BoolFlag{
+			Name:  "push",
+			Usage: "push the image",
+		},
+		&cli.StringFlag{
+			Name:  "registry-secret",
+			Usage: "name of the secret used for pushing and pulling",
+		},
+		&cli.StringFlag{
+			Name:  "language",
+			Usage: "language kind (Dockerfile, S2I, Cloudbuild)",
+			Value: string(crd.LanguageKindDockerfile),
+		},
+		&cli.StringFlag{
+			Name:  "s2i-base-image",
+			Usage: "base image for the S2I language",
+		},
+		&cli.StringFlag{
+			Name:  "context-kind",
+			Usage: "context kind (Git, HTTP, ConfigMap, Local, BuildKitSession, OCI). Inferred from the other context flags when empty",
+		},
+		&cli.StringFlag{
+			Name:  "context-url",
+			Usage: "URL of the Git repository or the HTTP(S) tar archive, or the reference of the OCI image",
+		},
+		&cli.StringFlag{
+			Name:  "context-revision",
+			Usage: "revision of the Git repository",
+		},
+		&cli.StringFlag{
+			Name:  "context-subpath",
+			Usage: "sub path within the Git repository, the HTTP(S) tar archive, or the OCI image",
+		},
+		&cli.StringFlag{
+			Name:  "context-configmap",
+			Usage: "name of the ConfigMap context",
+		},
+		&cli.StringFlag{
+			Name:  "context-dir",
+			Usage: "local directory to be uploaded to cbid (Local) or streamed to the build pod (BuildKitSession) as the context",
+		},
+		&cli.StringFlag{
+			Name:    "context-server",
+			Usage:   "URL of the context server of cbid (defaults to the API server proxy for the cbid service)",
+			EnvVars: []string{"CBICTL_CONTEXT_SERVER"},
+		},
+		&cli.StringFlag{
+			Name:  "cbid-namespace",
+			Usage: "namespace of the cbid service",
+			Value: "cbi-system",
+		},
+		&cli.StringFlag{
+			Name:    "plugin-selector",
+			Aliases: []string{"l"},
+			Usage:   "plugin selector, e.g. plugin.name=docker",
+		},
+		&cli.BoolFlag{
+			Name:    "detach",
+			Aliases: []string{"d"},
+			Usage:   "print the name of the buildjob and exit without following the logs",
+		},
+	},
+	Action: buildAction,
+}
+
+// buildOptions corresponds to the flags of the build command.
+type buildOptions struct {
+	name             string
+	target           string
+	push             bool
+	registrySecret   string
+	language         string
+	s2iBaseImage     string
+	contextKind      string
+	contextURL       string
+	contextRevision  string
+	contextSubPath   string
+	contextConfigMap string
+	contextDir       string
+	// contextDigest is the digest of the archive of contextDir
+	contextDigest string
+	// contextSharedKey is the session shared key of contextDir
+	contextSharedKey string
+	pluginSelector   string
+}
+
+func buildOptionsFromContext(clicontext *cli.Context) buildOptions {
+	return buildOptions{
+		name:             clicontext.String("name"),
+		target:           clicontext.String("target"),
+		push:             clicontext.Bool("push"),
+		registrySecret:   clicontext.String("registry-secret"),
+		language:         clicontext.String("language"),
+		s2iBaseImage:     clicontext.String("s2i-base-image"),
+		contextKind:      clicontext.String("context-kind"),
+		contextURL:       clicontext.String("context-url"),
+		contextRevision:  clicontext.String("context-revision"),
+		contextSubPath:   clicontext.String("context-subpath"),
+		contextConfigMap: clicontext.String("context-configmap"),
+		contextDir:       clicontext.String("context-dir"),
+		pluginSelector:   clicontext.String("plugin-selector"),
+	}
+}
+
+// inferContextKind infers the context kind from the context flags.
+func inferContextKind(o buildOptions) (crd.ContextKind, error) {
+	switch {
+	case o.contextConfigMap != "":
+		return crd.ContextKindConfigMap, nil
+	case o.contextDir != "":
+		return crd.ContextKindLocal, nil
+	case o.contextURL == "":
+		return "", errors.New("context-url, context-configmap, or context-dir needs to be specified")
+	case (strings.HasPrefix(o.contextURL, "http://") || strings.HasPrefix(o.contextURL, "https://")) &&
+		!strings.HasSuffix(o.contextURL, ".git") && o.contextRevision == "":
+		// the archive formats extracted by `cbipluginhelper http`
+		for _, ext := range []string{".tar", ".tar.gz", ".tgz", ".tar.bz2", ".tbz2", ".tar.zst", ".tzst", ".zip"} {
+			if strings.HasSuffix(o.contextURL, ext) {
+				return crd.ContextKindHTTP, nil
+			}
+		}
+	}
+	return crd.ContextKindGit, nil
+}
+
+// newBuildJob creates the BuildJob object from the options.
+func newBuildJob(o buildOptions) (*crd.BuildJob, error) {
+	bj := &crd.BuildJob{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: crd.SchemeGroupVersion.String(),
+			Kind:       "BuildJob",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: o.name,
+		},
+		Spec: crd.BuildJobSpec{
+			Registry: crd.Registry{
+				Target: o.target,
+				Push:   o.push,
+				SecretRef: corev1.LocalObjectReference{
+					Name: o.registrySecret,
+				},
+			},
+			Language: crd.Language{
+				Kind: crd.LanguageKind(o.language),
+			},
+			PluginSelector: o.pluginSelector,
+		},
+	}
+	if bj.ObjectMeta.Name == "" {
+		bj.ObjectMeta.GenerateName = "cbictl-"
+	}
+	if o.push && o.target == "" {
+		return nil, errors.New("target needs to be specified for pushing")
+	}
+	if o.s2iBaseImage != "" {
+		bj.Spec.Language.S2I.BaseImage = o.s2iBaseImage
+	}
+	kind := crd.ContextKind(o.contextKind)
+	if kind == "" {
+		var err error
+		kind, err = inferContextKind(o)
+		if err != nil {
+			return nil, err
+		}
+	}
+	bj.Spec.Context.Kind = kind
+	switch strings.ToLower(string(kind)) {
+	case strings.ToLower(string(crd.ContextKindGit)):
+		bj.Spec.Context.Git = crd.Git{
+			URL:      o.contextURL,
+			Revision: o.contextRevision,
+			SubPath:  o.contextSubPath,
+		}
+	case strings.ToLower(string(crd.ContextKindHTTP)):
+		bj.Spec.Context.HTTP = crd.HTTP{
+			URL:     o.contextURL,
+			SubPath: o.contextSubPath,
+		}
+	case strings.ToLower(string(crd.ContextKindConfigMap)):
+		bj.Spec.Context.ConfigMapRef.Name = o.contextConfigMap
+	case strings.ToLower(string(crd.ContextKindLocal)):
+		if o.contextDigest == "" {
+			return nil, errors.New("context-dir needs to be specified for Local context")
+		}
+		bj.Spec.Context.Local = crd.Local{
+			Digest:  o.contextDigest,
+			SubPath: o.contextSubPath,
+		}
+	case strings.ToLower(string(crd.ContextKindOCI)):
+		if o.contextURL == "" {
+			return nil, errors.New("context-url needs to be specified for OCI context")
+		}
+		bj.Spec.Context.OCI = crd.OCI{
+			Ref:     o.contextURL,
+			SubPath: o.contextSubPath,
+		}
+	case strings.ToLower(string(crd.ContextKindBuildKitSession)):
+		if o.contextDir == "" {
+			return nil, errors.New("context-dir needs to be specified for BuildKitSession context")
+		}
+		bj.Spec.Context.BuildKitSession = crd.BuildKitSession{
+			SharedKey: o.contextSharedKey,
+		}
+	default:
+		return nil, errors.Errorf("unsupported context kind: %q", kind)
+	}
+	return bj, nil
+}
+
+func buildAction(clicontext *cli.Context) error {
+	if clicontext.NArg() != 0 {
+		return errors.New("too many arguments")
+	}
+	opts := buildOptionsFromContext(clicontext)
+	session := strings.EqualFold(opts.contextKind, string(crd.ContextKindBuildKitSession))
+	if session && clicontext.Bool("detach") {
+		return errors.New("detach is not supported for BuildKitSession context, as the client needs to be attached during the build")
+	}
+	var archive *os.File
+	if session && opts.contextDir != "" {
+		var err error
+		if opts.contextSharedKey, err = sessionSharedKey(opts.contextDir); err != nil {
+			return err
+		}
+	} else if opts.contextDir != "" {
+		var err error
+		archive, opts.contextDigest, err = archiveDirToTempFile(opts.contextDir)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(archive.Name())
+		defer archive.Close()
+	}
+	bj, err := newBuildJob(opts)
+	if err != nil {
+		return err
+	}
+	c, err := newClients(clicontext)
+	if err != nil {
+		return err
+	}
+	bj, err = c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Create(bj)
+	if err != nil {
+		return err
+	}
+	uo := uploadOptions{
+		contextServer: clicontext.String("context-server"),
+		cbidNamespace: clicontext.String("cbid-namespace"),
+		cbidService:   "cbid",
+	}
+	if archive != nil {
+		// the job is not created until the archive is uploaded
+		if err := uploadContext(c, uo, bj, archive); err != nil {
+			return errors.Wrapf(err, "failed to upload the context for buildjob %q", bj.Name)
+		}
+		logrus.Debugf("uploaded the context %s", opts.contextDigest)
+	}
+	if clicontext.Bool("detach") {
+		fmt.Println(bj.Name)
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "buildjob %q created\n", bj.Name)
+	if archive != nil {
+		go func() {
+			if err := reuploadContext(c, uo, bj.Name, archive); err != nil {
+				logrus.Warnf("failed to watch the context upload: %v", err)
+			}
+		}()
+	}
+	if session {
+		// the build waits for the session to be attached
+		go func() {
+			if err := attachSession(c, bj.Name, opts.contextDir, os.Stderr); err != nil {
+				logrus.Warnf("failed to attach the session: %v", err)
+			}
+		}()
+	}
+	if err := streamLogs(c, bj.Name, true, os.Stdout); err != nil {
+		return err
+	}
+	bj, err = waitBuildJob(c, bj.Name, 0)
+	if err != nil {
+		return err
+	}
+	return buildResult(bj)
+}
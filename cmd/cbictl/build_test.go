@@ -0,0 +1,209 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"testing"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+func TestNewBuildJob(t *testing.T) {
+	testCases := []struct {
+		opts        buildOptions
+		expectedErr bool
+		check       func(t *testing.T, bj *crd.BuildJob)
+	}{
+		{
+			opts: buildOptions{
+				target:     "example.com/foo/bar:latest",
+				push:       true,
+				language:   "Dockerfile",
+				contextURL: "https://github.com/example/foo.git",
+			},
+			check: func(t *testing.T, bj *crd.BuildJob) {
+				if bj.GenerateName != "cbictl-" {
+					t.Errorf("unexpected generateName: %q", bj.GenerateName)
+				}
+				if bj.Spec.Context.Kind != crd.ContextKindGit || bj.Spec.Context.Git.URL != "https://github.com/example/foo.git" {
+					t.Errorf("unexpected context: %+v", bj.Spec.Context)
+				}
+			},
+		},
+		{
+			opts: buildOptions{
+				name:       "foo",
+				language:   "Dockerfile",
+				contextURL: "https://example.com/foo.tar.gz",
+			},
+			check: func(t *testing.T, bj *crd.BuildJob) {
+				if bj.Name != "foo" || bj.GenerateName != "" {
+					t.Errorf("unexpected name: %q, generateName: %q", bj.Name, bj.GenerateName)
+				}
+				if bj.Spec.Context.Kind != crd.ContextKindHTTP || bj.Spec.Context.HTTP.URL != "https://example.com/foo.tar.gz" {
+					t.Errorf("unexpected context: %+v", bj.Spec.Context)
+				}
+			},
+		},
+		{
+			opts: buildOptions{
+				language:         "Dockerfile",
+				contextConfigMap: "foo",
+			},
+			check: func(t *testing.T, bj *crd.BuildJob) {
+				if bj.Spec.Context.Kind != crd.ContextKindConfigMap || bj.Spec.Context.ConfigMapRef.Name != "foo" {
+					t.Errorf("unexpected context: %+v", bj.Spec.Context)
+				}
+			},
+		},
+		{
+			opts: buildOptions{
+				language:        "Dockerfile",
+				contextKind:     "git",
+				contextURL:      "git@github.com:example/foo.git",
+				contextRevision: "v1.0.0",
+				contextSubPath:  "sub",
+			},
+			check: func(t *testing.T, bj *crd.BuildJob) {
+				g := bj.Spec.Context.Git
+				if g.URL != "git@github.com:example/foo.git" || g.Revision != "v1.0.0" || g.SubPath != "sub" {
+					t.Errorf("unexpected git context: %+v", g)
+				}
+			},
+		},
+		{
+			opts: buildOptions{
+				language:       "Dockerfile",
+				contextDir:     "/tmp/foo",
+				contextDigest:  "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+				contextSubPath: "sub",
+			},
+			check: func(t *testing.T, bj *crd.BuildJob) {
+				l := bj.Spec.Context.Local
+				if bj.Spec.Context.Kind != crd.ContextKindLocal || l.Digest == "" || l.SubPath != "sub" || l.URL != "" {
+					t.Errorf("unexpected local context: %+v", bj.Spec.Context)
+				}
+			},
+		},
+		{
+			opts: buildOptions{
+				language:         "Dockerfile",
+				contextKind:      "BuildKitSession",
+				contextDir:       "/tmp/foo",
+				contextSharedKey: "deadbeef",
+			},
+			check: func(t *testing.T, bj *crd.BuildJob) {
+				if bj.Spec.Context.Kind != crd.ContextKindBuildKitSession || bj.Spec.Context.BuildKitSession.SharedKey != "deadbeef" {
+					t.Errorf("unexpected buildkit session context: %+v", bj.Spec.Context)
+				}
+			},
+		},
+		{
+			opts: buildOptions{
+				language:       "Dockerfile",
+				contextKind:    "OCI",
+				contextURL:     "example.com/foo/bundle:v1",
+				contextSubPath: "sub",
+			},
+			check: func(t *testing.T, bj *crd.BuildJob) {
+				o := bj.Spec.Context.OCI
+				if bj.Spec.Context.Kind != crd.ContextKindOCI || o.Ref != "example.com/foo/bundle:v1" || o.SubPath != "sub" {
+					t.Errorf("unexpected oci context: %+v", bj.Spec.Context)
+				}
+			},
+		},
+		{
+			// BuildKitSession without dir
+			opts: buildOptions{
+				language:    "Dockerfile",
+				contextKind: "BuildKitSession",
+			},
+			expectedErr: true,
+		},
+		{
+			// push without target
+			opts: buildOptions{
+				push:       true,
+				language:   "Dockerfile",
+				contextURL: "https://github.com/example/foo.git",
+			},
+			expectedErr: true,
+		},
+		{
+			// no context
+			opts: buildOptions{
+				language: "Dockerfile",
+			},
+			expectedErr: true,
+		},
+		{
+			opts: buildOptions{
+				language:    "Dockerfile",
+				contextKind: "Unknown",
+				contextURL:  "https://github.com/example/foo.git",
+			},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		bj, err := newBuildJob(tc.opts)
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("#%d: error is expected", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		tc.check(t, bj)
+	}
+}
+
+func TestInferContextKind(t *testing.T) {
+	testCases := []struct {
+		opts     buildOptions
+		expected crd.ContextKind
+	}{
+		{opts: buildOptions{contextURL: "https://github.com/example/foo.git"}, expected: crd.ContextKindGit},
+		{opts: buildOptions{contextURL: "https://github.com/example/foo"}, expected: crd.ContextKindGit},
+		{opts: buildOptions{contextURL: "git@github.com:example/foo.git"}, expected: crd.ContextKindGit},
+		{opts: buildOptions{contextURL: "https://example.com/foo.tar"}, expected: crd.ContextKindHTTP},
+		{opts: buildOptions{contextURL: "https://example.com/foo.tar.gz"}, expected: crd.ContextKindHTTP},
+		{opts: buildOptions{contextURL: "https://example.com/foo.tgz"}, expected: crd.ContextKindHTTP},
+		{opts: buildOptions{contextURL: "https://example.com/foo.tar.bz2"}, expected: crd.ContextKindHTTP},
+		{opts: buildOptions{contextURL: "https://example.com/foo.tbz2"}, expected: crd.ContextKindHTTP},
+		{opts: buildOptions{contextURL: "https://example.com/foo.tar.zst"}, expected: crd.ContextKindHTTP},
+		{opts: buildOptions{contextURL: "https://example.com/foo.tzst"}, expected: crd.ContextKindHTTP},
+		{opts: buildOptions{contextURL: "http://example.com/foo.zip"}, expected: crd.ContextKindHTTP},
+		// the revision is only meaningful for git
+		{opts: buildOptions{contextURL: "https://example.com/foo.zip", contextRevision: "v1.0.0"}, expected: crd.ContextKindGit},
+		{opts: buildOptions{contextConfigMap: "foo"}, expected: crd.ContextKindConfigMap},
+		{opts: buildOptions{contextDir: "/tmp/foo"}, expected: crd.ContextKindLocal},
+	}
+	for i, tc := range testCases {
+		actual, err := inferContextKind(tc.opts)
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if actual != tc.expected {
+			t.Errorf("#%d: expected %q, got %q", i, tc.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+var cancelCommand = &cli.Command{
+	Name:      "cancel",
+	Usage:     "cancel buildjobs",
+	ArgsUsage: "NAME...",
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() == 0 {
+			return errors.New("expected at least one argument (NAME)")
+		}
+		c, err := newClients(clicontext)
+		if err != nil {
+			return err
+		}
+		for _, name := range clicontext.Args().Slice() {
+			if err := cancelBuildJob(c, name); err != nil {
+				return err
+			}
+			fmt.Printf("buildjob %q canceled\n", name)
+		}
+		return nil
+	},
+}
+
+// cancelBuildJob sets spec.canceled.
+// cbid deletes the job and sets the phase to Canceled.
+func cancelBuildJob(c *clients, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		bj, err := c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if bj.Spec.Canceled {
+			return nil
+		}
+		bj.Spec.Canceled = true
+		_, err = c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Update(bj)
+		return err
+	})
+}
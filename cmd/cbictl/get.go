@@ -0,0 +1,119 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+var getCommand = &cli.Command{
+	Name:      "get",
+	Usage:     "list buildjobs",
+	ArgsUsage: "[NAME...]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:    "output",
+			Aliases: []string{"o"},
+			Usage:   "output format (table, json, yaml)",
+			Value:   "table",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		c, err := newClients(clicontext)
+		if err != nil {
+			return err
+		}
+		var items []crd.BuildJob
+		if clicontext.NArg() == 0 {
+			list, err := c.cbi.CbiV1alpha1().BuildJobs(c.namespace).List(metav1.ListOptions{})
+			if err != nil {
+				return err
+			}
+			items = list.Items
+		} else {
+			for _, name := range clicontext.Args().Slice() {
+				bj, err := c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				items = append(items, *bj)
+			}
+		}
+		return printBuildJobs(os.Stdout, clicontext.String("output"), items, time.Now())
+	},
+}
+
+func printBuildJobs(w io.Writer, format string, items []crd.BuildJob, now time.Time) error {
+	switch format {
+	case "table", "":
+		tw := tabwriter.NewWriter(w, 4, 8, 4, ' ', 0)
+		fmt.Fprintln(tw, "NAME\tPHASE\tPLUGIN\tJOB\tAGE")
+		for _, bj := range items {
+			age := shortHumanDuration(now.Sub(bj.CreationTimestamp.Time))
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", bj.Name, bj.Status.Phase, bj.Status.Plugin, bj.Status.Job, age)
+		}
+		return tw.Flush()
+	case "json", "yaml":
+		var v interface{} = items
+		if len(items) == 1 {
+			v = items[0]
+		}
+		b, err := json.MarshalIndent(v, "", "    ")
+		if err != nil {
+			return err
+		}
+		if format == "yaml" {
+			b, err = yaml.JSONToYAML(b)
+			if err != nil {
+				return err
+			}
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	default:
+		return errors.Errorf("unknown output format: %q", format)
+	}
+}
+
+// shortHumanDuration formats d like kubectl, e.g. "5s", "3m", "2h", "4d".
+func shortHumanDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		if d < 0 {
+			d = 0
+		}
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(d.Hours()/24))
+	}
+}
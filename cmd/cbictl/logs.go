@@ -0,0 +1,252 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/s3"
+)
+
+var logsCommand = &cli.Command{
+	Name:      "logs",
+	Usage:     "print the logs of a buildjob",
+	ArgsUsage: "NAME",
+	Description: `Print the logs of the pods of the buildjob.
+
+When the job has been already garbage-collected, the log persisted by cbid is printed instead.
+The S3 log sink is accessed using $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY, and $AWS_REGION.`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:    "follow",
+			Aliases: []string{"f"},
+			Usage:   "follow the logs until the buildjob finishes",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() != 1 {
+			return errors.New("expected exactly one argument (NAME)")
+		}
+		c, err := newClients(clicontext)
+		if err != nil {
+			return err
+		}
+		return streamLogs(c, clicontext.Args().First(), clicontext.Bool("follow"), os.Stdout)
+	},
+}
+
+// streamLogs writes the logs of the pods of the buildjob to w.
+// When follow is true, streamLogs returns after the buildjob finishes.
+func streamLogs(c *clients, name string, follow bool, w io.Writer) error {
+	bj, err := c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if bj.Status.Job == "" && follow {
+		if err = wait.PollImmediateInfinite(waitInterval, func() (bool, error) {
+			bj, err = c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return false, err
+			}
+			return bj.Status.Job != "" || isFinished(bj), nil
+		}); err != nil {
+			return err
+		}
+	}
+	if bj.Status.Job == "" {
+		if bj.Status.Log.Kind != "" {
+			return printPersistedLog(c, bj, w)
+		}
+		if isFinished(bj) {
+			// e.g. the job was not created due to the policy violation
+			return buildResult(bj)
+		}
+		return errors.Errorf("buildjob %q has no job (phase %q)", name, bj.Status.Phase)
+	}
+	job, err := c.kube.BatchV1().Jobs(c.namespace).Get(bj.Status.Job, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) && bj.Status.Log.Kind != "" {
+			logrus.Debugf("job %q not found, printing the persisted log", bj.Status.Job)
+			return printPersistedLog(c, bj, w)
+		}
+		return err
+	}
+	seen := make(map[string]bool)
+	for {
+		pods, err := c.kube.CoreV1().Pods(c.namespace).List(metav1.ListOptions{
+			LabelSelector: "controller-uid=" + string(job.UID),
+		})
+		if err != nil {
+			return err
+		}
+		// oldest first
+		sort.SliceStable(pods.Items, func(i, j int) bool {
+			return pods.Items[i].CreationTimestamp.Before(&pods.Items[j].CreationTimestamp)
+		})
+		streamed := false
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if seen[pod.Name] {
+				continue
+			}
+			seen[pod.Name] = true
+			streamed = true
+			if err := streamPodLogs(c, pod, follow, w); err != nil {
+				return err
+			}
+		}
+		if !follow {
+			if len(seen) == 0 && bj.Status.Log.Kind != "" {
+				return printPersistedLog(c, bj, w)
+			}
+			return nil
+		}
+		if !streamed {
+			bj, err = c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if isFinished(bj) {
+				return nil
+			}
+			time.Sleep(waitInterval)
+		}
+	}
+}
+
+// containerStarted returns true if the container is running or terminated.
+// ok is false if the container status is not available yet.
+func containerStarted(pod *corev1.Pod, container string) (started, ok bool) {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, st := range statuses {
+		if st.Name == container {
+			return st.State.Running != nil || st.State.Terminated != nil, true
+		}
+	}
+	return false, false
+}
+
+func podFinished(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// waitContainerStarted waits for the container to start.
+// started is false if the pod finished without starting the container.
+func waitContainerStarted(c *clients, podName, container string) (started bool, err error) {
+	err = wait.PollImmediateInfinite(time.Second, func() (bool, error) {
+		pod, err := c.kube.CoreV1().Pods(c.namespace).Get(podName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		started, _ = containerStarted(pod, container)
+		return started || podFinished(pod), nil
+	})
+	return started, err
+}
+
+func streamPodLogs(c *clients, pod *corev1.Pod, follow bool, w io.Writer) error {
+	var containers []string
+	for _, x := range pod.Spec.InitContainers {
+		containers = append(containers, x.Name)
+	}
+	for _, x := range pod.Spec.Containers {
+		containers = append(containers, x.Name)
+	}
+	for _, container := range containers {
+		var started bool
+		if follow {
+			var err error
+			started, err = waitContainerStarted(c, pod.Name, container)
+			if err != nil {
+				return err
+			}
+		} else {
+			started, _ = containerStarted(pod, container)
+		}
+		if !started {
+			logrus.Debugf("skipping pod/%s container/%s (not started)", pod.Name, container)
+			continue
+		}
+		fmt.Fprintf(w, "==> pod/%s container/%s <==\n", pod.Name, container)
+		rc, err := c.kube.CoreV1().Pods(c.namespace).GetLogs(pod.Name, &corev1.PodLogOptions{
+			Container: container,
+			Follow:    follow,
+		}).Stream()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printPersistedLog prints the log persisted by cbid.
+func printPersistedLog(c *clients, bj *crd.BuildJob, w io.Writer) error {
+	l := bj.Status.Log
+	if l.Truncated {
+		fmt.Fprintln(os.Stderr, "warning: the persisted log is truncated")
+	}
+	switch l.Kind {
+	case crd.BuildJobLogKindConfigMap:
+		cm, err := c.kube.CoreV1().ConfigMaps(c.namespace).Get(l.ConfigMap.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, cm.Data[l.ConfigMap.Key])
+		return err
+	case crd.BuildJobLogKindPersistentVolumeClaim:
+		// cbictl cannot mount the PVC, so just print the location.
+		return errors.Errorf("the log is persisted to %q in PersistentVolumeClaim %s/%s",
+			l.PersistentVolumeClaim.Path, l.PersistentVolumeClaim.Namespace, l.PersistentVolumeClaim.ClaimName)
+	case crd.BuildJobLogKindS3:
+		client, err := s3.New(s3.Config{
+			Endpoint:    l.S3.Endpoint,
+			Region:      os.Getenv("AWS_REGION"),
+			Credentials: s3.CredentialsFromEnv(),
+		})
+		if err != nil {
+			return err
+		}
+		rc, err := client.GetObject(context.Background(), l.S3.Bucket, l.S3.Key)
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		_, err = io.Copy(w, rc)
+		return err
+	default:
+		return errors.Errorf("unknown log kind: %q", l.Kind)
+	}
+}
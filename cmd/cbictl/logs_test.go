@@ -0,0 +1,68 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/client/clientset/versioned/fake"
+)
+
+func TestStreamLogsWithoutJob(t *testing.T) {
+	testCases := []struct {
+		status      crd.BuildJobStatus
+		expectedErr string
+	}{
+		{
+			status: crd.BuildJobStatus{
+				Phase:   crd.BuildJobPhaseFailed,
+				Message: "PolicyViolation: foo",
+			},
+			expectedErr: `buildjob "ex" finished with phase "Failed": PolicyViolation: foo`,
+		},
+		{
+			status: crd.BuildJobStatus{
+				Phase: crd.BuildJobPhaseCanceled,
+			},
+			expectedErr: `buildjob "ex" finished with phase "Canceled"`,
+		},
+	}
+	for i, tc := range testCases {
+		bj := &crd.BuildJob{
+			ObjectMeta: metav1.ObjectMeta{Name: "ex", Namespace: "default"},
+			Status:     tc.status,
+		}
+		c := &clients{cbi: fake.NewSimpleClientset(bj), namespace: "default"}
+		// returns without polling, as the buildjob has finished
+		err := streamLogs(c, "ex", true, &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), tc.expectedErr) {
+			t.Fatalf("%d: expected error %q, got %v", i, tc.expectedErr, err)
+		}
+		waited, err := waitBuildJob(c, "ex", 0)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if waited.Status.Phase != tc.status.Phase {
+			t.Fatalf("%d: expected phase %q, got %q", i, tc.status.Phase, waited.Status.Phase)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// cbictl is the reference CLI for cbid.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	// Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
+	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+
+	clientset "github.com/containerbuilding/cbi/pkg/client/clientset/versioned"
+)
+
+func main() {
+	debug := false
+	app := &cli.App{}
+	app.Name = "cbictl"
+	app.Usage = "CBI CLI"
+	app.Flags = []cli.Flag{
+		&cli.BoolFlag{
+			Name:        "debug",
+			Usage:       "debug mode",
+			Destination: &debug,
+		},
+		&cli.StringFlag{
+			Name:    "kubeconfig",
+			Usage:   "path to a kubeconfig",
+			EnvVars: []string{"KUBECONFIG"},
+		},
+		&cli.StringFlag{
+			Name:    "namespace",
+			Aliases: []string{"n"},
+			Usage:   "namespace (defaults to the namespace of the current context)",
+		},
+	}
+	app.Commands = []*cli.Command{
+		buildCommand,
+		logsCommand,
+		waitCommand,
+		getCommand,
+		cancelCommand,
+	}
+	app.Before = func(context *cli.Context) error {
+		if debug {
+			logrus.SetLevel(logrus.DebugLevel)
+		}
+		return nil
+	}
+	if err := app.Run(os.Args); err != nil {
+		if debug {
+			fmt.Fprintf(os.Stderr, "error: %+v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		os.Exit(1)
+	}
+}
+
+// clients contains the clients for the namespace.
+type clients struct {
+	config    *rest.Config
+	kube      kubernetes.Interface
+	cbi       clientset.Interface
+	namespace string
+}
+
+func newClients(clicontext *cli.Context) (*clients, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = clicontext.String("kubeconfig")
+	overrides := &clientcmd.ConfigOverrides{}
+	overrides.Context.Namespace = clicontext.String("namespace")
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	cfg, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	ns, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+	kube, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	cbi, err := clientset.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &clients{config: cfg, kube: kube, cbi: cbi, namespace: ns}, nil
+}
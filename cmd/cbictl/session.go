@@ -0,0 +1,190 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/transport/spdy"
+
+	"github.com/containerbuilding/cbi/pkg/buildkitsession"
+)
+
+// portForwardProtocol is the subprotocol of the port-forwarding API.
+const portForwardProtocol = "portforward.k8s.io"
+
+// sessionSharedKey returns the shared key for dir, so that the consecutive
+// builds of the same directory on the same host can reuse the transferred files.
+func sessionSharedKey(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256([]byte(hostname + ":" + abs))
+	return hex.EncodeToString(h[:]), nil
+}
+
+// attachSession attaches to the pods of the buildjob via port-forwarding, and
+// serves dir with FileSync until the buildjob finishes.
+// A pod is attached again if the attachment was refused, e.g. because the pod
+// was not listening yet.
+func attachSession(c *clients, name, dir string, w io.Writer) error {
+	gs := grpc.NewServer()
+	buildkitsession.RegisterFileSync(gs, map[string]string{
+		"context":    dir,
+		"dockerfile": dir,
+	})
+	defer gs.Stop()
+	attached := make(map[string]bool)
+	detached := make(chan string, 1)
+	return wait.PollImmediateInfinite(time.Second, func() (bool, error) {
+		for len(detached) > 0 {
+			delete(attached, <-detached)
+		}
+		bj, err := c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if isFinished(bj) {
+			return true, nil
+		}
+		if bj.Status.Job == "" {
+			return false, nil
+		}
+		job, err := c.kube.BatchV1().Jobs(c.namespace).Get(bj.Status.Job, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		pods, err := c.kube.CoreV1().Pods(c.namespace).List(metav1.ListOptions{
+			LabelSelector: "controller-uid=" + string(job.UID),
+		})
+		if err != nil {
+			return false, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if attached[pod.Name] || podFinished(pod) || pod.Status.Phase != corev1.PodRunning {
+				continue
+			}
+			conn, errCh, err := portForward(c, pod.Name, buildkitsession.DefaultPort)
+			if err != nil {
+				logrus.Debugf("failed to attach to pod/%s: %v", pod.Name, err)
+				continue
+			}
+			attached[pod.Name] = true
+			fmt.Fprintf(w, "attached to pod/%s\n", pod.Name)
+			go buildkitsession.ServeConn(gs, conn)
+			go func(podName string) {
+				if err, ok := <-errCh; ok {
+					logrus.Debugf("detached from pod/%s: %v", podName, err)
+					conn.Close()
+					detached <- podName
+				}
+			}(pod.Name)
+		}
+		return false, nil
+	})
+}
+
+// portForward connects to the port of the pod via the port-forwarding API.
+// errCh receives the error reported by the kubelet, e.g. when nothing is listening on the port,
+// and is closed when the connection is closed without errors.
+func portForward(c *clients, podName string, port int) (net.Conn, <-chan error, error) {
+	transport, upgrader, err := spdy.RoundTripperFor(c.config)
+	if err != nil {
+		return nil, nil, err
+	}
+	req := c.kube.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(c.namespace).Name(podName).SubResource("portforward")
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, "POST", req.URL())
+	streamConn, _, err := dialer.Dial(portForwardProtocol)
+	if err != nil {
+		return nil, nil, err
+	}
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(port))
+	headers.Set(corev1.PortForwardRequestIDHeader, "0")
+	errorStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		streamConn.Close()
+		return nil, nil, err
+	}
+	// the error stream is read-only
+	errorStream.Close()
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		streamConn.Close()
+		return nil, nil, err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		defer close(errCh)
+		msg, err := ioutil.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errCh <- err
+		case len(msg) > 0:
+			errCh <- errors.New(string(msg))
+		}
+	}()
+	return &portForwardConn{Stream: dataStream, conn: streamConn}, errCh, nil
+}
+
+// portForwardConn is net.Conn over the data stream of the port-forwarding.
+type portForwardConn struct {
+	httpstream.Stream
+	conn httpstream.Connection
+}
+
+func (c *portForwardConn) Close() error {
+	c.Stream.Close()
+	return c.conn.Close()
+}
+
+func (c *portForwardConn) LocalAddr() net.Addr                { return portForwardAddr{} }
+func (c *portForwardConn) RemoteAddr() net.Addr               { return portForwardAddr{} }
+func (c *portForwardConn) SetDeadline(t time.Time) error      { return nil }
+func (c *portForwardConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *portForwardConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type portForwardAddr struct{}
+
+func (portForwardAddr) Network() string { return "portforward" }
+func (portForwardAddr) String() string  { return "portforward" }
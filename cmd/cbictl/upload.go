@@ -0,0 +1,187 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/cbid/contextserver"
+)
+
+// archiveDir writes the tar+gzip archive of dir to w.
+// Files other than regular files, directories, and symlinks are skipped.
+func archiveDir(dir string, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		var link string
+		switch mode := fi.Mode(); {
+		case mode.IsRegular(), mode.IsDir():
+		case mode&os.ModeSymlink != 0:
+			if link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		default:
+			logrus.Warnf("skipping %s (unsupported file mode %s)", path, mode)
+			return nil
+		}
+		hdr, err := tar.FileInfoHeader(fi, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		// the owners on the client are meaningless for the build
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// archiveDirToTempFile archives dir into a temporary file and returns the file and the digest.
+// The caller needs to close and remove the file.
+func archiveDirToTempFile(dir string) (*os.File, string, error) {
+	f, err := ioutil.TempFile("", "cbictl-context")
+	if err != nil {
+		return nil, "", err
+	}
+	h := sha256.New()
+	if err := archiveDir(dir, io.MultiWriter(f, h)); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, "", err
+	}
+	return f, "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// uploadOptions specifies how to reach the context server of cbid.
+type uploadOptions struct {
+	// contextServer is the URL of the context server.
+	// When empty, the context server is accessed via the API server proxy
+	// for the service cbidNamespace/cbidService (port "context").
+	contextServer string
+	cbidNamespace string
+	cbidService   string
+}
+
+// uploadContext uploads the archive of the Local context of bj.
+func uploadContext(c *clients, o uploadOptions, bj *crd.BuildJob, archive io.Reader) error {
+	p := contextserver.URLPath(bj)
+	logrus.Debugf("uploading the context to %q", p)
+	if o.contextServer == "" {
+		return c.kube.CoreV1().RESTClient().Put().
+			Namespace(o.cbidNamespace).
+			Resource("services").
+			Name(o.cbidService + ":context").
+			SubResource("proxy").
+			Suffix(p).
+			Body(archive).
+			Do().
+			Error()
+	}
+	req, err := http.NewRequest(http.MethodPut, strings.TrimSuffix(o.contextServer, "/")+p, archive)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return errors.Errorf("failed to upload the context: %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return nil
+}
+
+// reuploadContext uploads the archive again whenever cbid clears the upload annotation
+// of the buildjob, e.g. when the archive was lost on the leader change of cbid.
+// reuploadContext returns when the job has been created or the buildjob has finished.
+func reuploadContext(c *clients, o uploadOptions, name string, archive io.ReadSeeker) error {
+	return wait.PollImmediateInfinite(waitInterval, func() (bool, error) {
+		bj, err := c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		if bj.Status.Job != "" || isFinished(bj) {
+			return true, nil
+		}
+		if contextserver.Uploaded(bj) {
+			return false, nil
+		}
+		logrus.Warnf("the context of buildjob %q is missing on cbid, uploading again", name)
+		if _, err := archive.Seek(0, io.SeekStart); err != nil {
+			return false, err
+		}
+		if err := uploadContext(c, o, bj, archive); err != nil {
+			// the new leader may not be serving yet
+			logrus.Warnf("failed to upload the context for buildjob %q: %v", name, err)
+		}
+		return false, nil
+	})
+}
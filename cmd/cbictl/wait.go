@@ -0,0 +1,105 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+var waitCommand = &cli.Command{
+	Name:      "wait",
+	Usage:     "wait for the completion of a buildjob",
+	ArgsUsage: "NAME",
+	Flags: []cli.Flag{
+		&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "timeout (0 for no timeout)",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.NArg() != 1 {
+			return errors.New("expected exactly one argument (NAME)")
+		}
+		c, err := newClients(clicontext)
+		if err != nil {
+			return err
+		}
+		bj, err := waitBuildJob(c, clicontext.Args().First(), clicontext.Duration("timeout"))
+		if err != nil {
+			return err
+		}
+		return buildResult(bj)
+	},
+}
+
+// waitInterval is the polling interval for waiting for the completion.
+const waitInterval = 2 * time.Second
+
+// isFinished returns true if the phase of bj is terminal.
+func isFinished(bj *crd.BuildJob) bool {
+	switch bj.Status.Phase {
+	case crd.BuildJobPhaseSucceeded, crd.BuildJobPhaseFailed, crd.BuildJobPhaseCanceled:
+		return true
+	}
+	return false
+}
+
+// waitBuildJob polls the buildjob until it finishes.
+// timeout 0 means no timeout.
+func waitBuildJob(c *clients, name string, timeout time.Duration) (*crd.BuildJob, error) {
+	var bj *crd.BuildJob
+	cond := func() (bool, error) {
+		var err error
+		bj, err = c.cbi.CbiV1alpha1().BuildJobs(c.namespace).Get(name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		logrus.Debugf("buildjob %q: phase=%q", name, bj.Status.Phase)
+		return isFinished(bj), nil
+	}
+	var err error
+	if timeout == 0 {
+		err = wait.PollImmediateInfinite(waitInterval, cond)
+	} else {
+		err = wait.PollImmediate(waitInterval, timeout, cond)
+	}
+	if err == wait.ErrWaitTimeout {
+		return nil, errors.Errorf("timed out waiting for buildjob %q", name)
+	}
+	return bj, err
+}
+
+// buildResult returns an error with a non-zero exit code if bj did not succeed.
+func buildResult(bj *crd.BuildJob) error {
+	if bj.Status.Phase == crd.BuildJobPhaseSucceeded {
+		return nil
+	}
+	msg := fmt.Sprintf("buildjob %q finished with phase %q", bj.Name, bj.Status.Phase)
+	if bj.Status.Message != "" {
+		msg += ": " + bj.Status.Message
+	}
+	return cli.Exit(msg, 1)
+}
@@ -20,21 +20,39 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"net/http"
+	"os"
 	"strings"
 	"time"
 	"unicode"
 
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/client-go/tools/record"
 	// Uncomment the following line to load the gcp plugin (only required to authenticate against GKE clusters).
 	// _ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 
+	"github.com/containerbuilding/cbi/pkg/cbid/config"
+	"github.com/containerbuilding/cbi/pkg/cbid/contextserver"
 	"github.com/containerbuilding/cbi/pkg/cbid/controller"
+	"github.com/containerbuilding/cbi/pkg/cbid/logsink"
+	"github.com/containerbuilding/cbi/pkg/cbid/metrics"
 	"github.com/containerbuilding/cbi/pkg/cbid/pluginselector"
 	"github.com/containerbuilding/cbi/pkg/cbid/pluginselector/generic"
 	clientset "github.com/containerbuilding/cbi/pkg/client/clientset/versioned"
@@ -44,32 +62,61 @@ import (
 )
 
 var (
-	masterURL  string
-	kubeconfig string
-	pluginsStr string
+	masterURL   string
+	kubeconfig  string
+	configPath  string
+	pluginsStr  string
+	metricsAddr string
+
+	contextServerAddr    string
+	contextServerURL     string
+	contextDir           string
+	contextMaxBytes      int64
+	contextGCGracePeriod time.Duration
+
+	leaderElect              bool
+	leaderElectNamespace     string
+	leaderElectName          string
+	leaderElectLeaseDuration time.Duration
+	leaderElectRenewDeadline time.Duration
+	leaderElectRetryPeriod   time.Duration
 )
 
 func main() {
 	flag.Parse()
 
+	cbidConfig := config.Default()
+	if configPath != "" {
+		var err error
+		cbidConfig, err = config.Load(configPath)
+		if err != nil {
+			glog.Fatal(err)
+		}
+	}
+
 	cbiPlugins, err := parsePluginsStr(pluginsStr)
 	if err != nil {
 		glog.Fatal(err)
 	}
+	if len(cbidConfig.Plugins) > 0 {
+		if len(cbiPlugins) > 0 {
+			glog.Fatalf("-cbi-plugins cannot be specified when the plugins are specified in %s", configPath)
+		}
+		cbiPlugins = configPluginAddrs(cbidConfig.Plugins)
+	}
 	if len(cbiPlugins) == 0 {
 		glog.Fatalf("no CBI plugin specified")
 	}
 
 	var cbiPluginConns []*grpc.ClientConn
-	for _, s := range cbiPlugins {
-		c, err := grpc.Dial(s, grpc.WithInsecure())
+	for _, p := range cbiPlugins {
+		c, err := grpc.Dial(p.addr, grpc.WithInsecure(), grpc.WithUnaryInterceptor(pluginInterceptor(p)))
 		if err != nil {
 			glog.Fatal(err)
 		}
 		cbiPluginConns = append(cbiPluginConns, c)
 	}
-	ps := pluginselector.NewPluginSelector(generic.SelectPlugin, cbiPluginConns...)
-	// FIXME: keep them latest
+	ps := pluginselector.NewPluginSelector(selectorFunc(cbidConfig.PluginSelectorStrategy), cbiPluginConns...)
 	if err := ps.UpdateCachedInfo(context.TODO()); err != nil {
 		glog.Fatal(err)
 	}
@@ -77,6 +124,17 @@ func main() {
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := signals.SetupSignalHandler()
 
+	// keep the plugin info latest, so that unhealthy plugins are excluded from selection
+	go wait.Until(func() {
+		if err := ps.UpdateCachedInfo(context.TODO()); err != nil {
+			glog.Warningf("failed to update plugin info: %v", err)
+		}
+	}, pluginInfoUpdateInterval, stopCh)
+
+	if metricsAddr != "" {
+		go serveMetrics(metricsAddr)
+	}
+
 	cfg, err := clientcmd.BuildConfigFromFlags(masterURL, kubeconfig)
 	if err != nil {
 		glog.Fatalf("Error building kubeconfig: %s", err.Error())
@@ -92,21 +150,87 @@ func main() {
 		glog.Fatalf("Error building CBI clientset: %s", err.Error())
 	}
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-	cbiInformerFactory := informers.NewSharedInformerFactory(cbiClient, time.Second*30)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, cbidConfig.ResyncPeriod.Duration)
+	cbiInformerFactory := informers.NewSharedInformerFactory(cbiClient, cbidConfig.ResyncPeriod.Duration)
+	podInformerFactory := kubeinformers.NewFilteredSharedInformerFactory(kubeClient, cbidConfig.ResyncPeriod.Duration, metav1.NamespaceAll,
+		func(o *metav1.ListOptions) {
+			o.LabelSelector = controller.LabelBuildJob
+		})
 
 	controller := controller.New(
 		kubeClient,
 		cbiClient,
 		kubeInformerFactory,
+		podInformerFactory,
 		cbiInformerFactory,
 		ps)
+	controller.SetConfig(cbidConfig)
+	logSink, err := logsink.New(cbidConfig.LogSink, kubeClient, podNamespace())
+	if err != nil {
+		glog.Fatalf("Error creating log sink: %s", err.Error())
+	}
+	controller.SetLogSink(logSink)
+	var ctxServer *contextserver.Server
+	if contextServerAddr != "" {
+		if contextServerURL == "" {
+			glog.Fatal("-context-server-url is required when -context-server-addr is specified")
+		}
+		buildJobs := cbiInformerFactory.Cbi().V1alpha1().BuildJobs()
+		ctxServer, err = contextserver.New(contextDir, buildJobs.Lister(), cbiClient)
+		if err != nil {
+			glog.Fatalf("Error creating context server: %s", err.Error())
+		}
+		ctxServer.MaxBytes = contextMaxBytes
+		controller.SetContextServerURL(contextServerURL)
+		controller.SetContextStore(ctxServer)
+	}
+
+	if configPath != "" {
+		go watchConfig(configPath, cbidConfig, ps, controller, stopCh)
+	}
 
-	go kubeInformerFactory.Start(stopCh)
-	go cbiInformerFactory.Start(stopCh)
+	run := func(stop <-chan struct{}) {
+		go kubeInformerFactory.Start(stop)
+		go podInformerFactory.Start(stop)
+		go cbiInformerFactory.Start(stop)
+
+		if ctxServer != nil {
+			// the context server is served only on the leader, so that the
+			// uploads and the downloads reach the same replica via the service.
+			go serveContexts(contextServerAddr, ctxServer)
+			go gcContexts(ctxServer, cbiInformerFactory.Cbi().V1alpha1().BuildJobs().Informer().HasSynced, stop)
+		}
 
-	if err = controller.Run(2, stopCh); err != nil {
-		glog.Fatalf("Error running controller: %s", err.Error())
+		if err := controller.Run(cbidConfig.Workers, stop); err != nil {
+			glog.Fatalf("Error running controller: %s", err.Error())
+		}
+	}
+
+	if leaderElect {
+		lock, err := newLeaderElectionLock(kubeClient)
+		if err != nil {
+			glog.Fatalf("Error creating leader election lock: %s", err.Error())
+		}
+		go leaderelection.RunOrDie(leaderelection.LeaderElectionConfig{
+			Lock:          lock,
+			LeaseDuration: leaderElectLeaseDuration,
+			RenewDeadline: leaderElectRenewDeadline,
+			RetryPeriod:   leaderElectRetryPeriod,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(stop <-chan struct{}) {
+					glog.Infof("Started leading as %q", lock.Identity())
+					run(stop)
+				},
+				OnStoppedLeading: func() {
+					// the informers and the workers cannot be restarted safely, so we just exit
+					// and let the replica controller restart us as a follower.
+					glog.Fatalf("Leader election lost")
+				},
+			},
+		})
+		<-stopCh
+	} else {
+		run(stopCh)
 	}
 	for _, c := range cbiPluginConns {
 		if err := c.Close(); err != nil {
@@ -115,23 +239,180 @@ func main() {
 	}
 }
 
-func parsePluginsStr(s string) ([]string, error) {
+const pluginInfoUpdateInterval = 30 * time.Second
+
+const configReloadInterval = 10 * time.Second
+
+// watchConfig reloads the non-structural settings (the plugin selector strategy,
+// the defaults, and the policy) when the configuration file changes.
+// The changes to the structural settings are ignored with warnings.
+func watchConfig(path string, initial *config.CBIDConfiguration, ps *pluginselector.PluginSelector, c *controller.Controller, stopCh <-chan struct{}) {
+	current := initial
+	config.Watch(path, configReloadInterval, stopCh, func(newConfig *config.CBIDConfiguration) {
+		if config.StructuralChanged(initial, newConfig) {
+			glog.Warningf("%s: changes to plugins, workers, and resyncPeriod are ignored until restart", path)
+		}
+		if newConfig.PluginSelectorStrategy != current.PluginSelectorStrategy {
+			ps.SetSelectorFunc(selectorFunc(newConfig.PluginSelectorStrategy))
+		}
+		c.SetConfig(newConfig)
+		current = newConfig
+	})
+}
+
+func selectorFunc(strategy config.PluginSelectorStrategy) pluginselector.PluginSelectorFunc {
+	if strategy == config.PluginSelectorStrategyRoundRobin {
+		return generic.NewRoundRobinSelectPlugin()
+	}
+	return generic.SelectPlugin
+}
+
+// newLeaderElectionLock creates the ConfigMap lock for the leader election.
+// The identity is the hostname (i.e. the pod name) with a random suffix.
+func newLeaderElectionLock(kubeClient kubernetes.Interface) (resourcelock.Interface, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	id := hostname + "_" + hex.EncodeToString(b)
+	eventBroadcaster := record.NewBroadcaster()
+	eventBroadcaster.StartLogging(glog.Infof)
+	eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeClient.CoreV1().Events(leaderElectNamespace)})
+	recorder := eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "cbid", Host: hostname})
+	return resourcelock.New(resourcelock.ConfigMapsResourceLock,
+		leaderElectNamespace,
+		leaderElectName,
+		kubeClient.CoreV1(),
+		resourcelock.ResourceLockConfig{
+			Identity:      id,
+			EventRecorder: recorder,
+		})
+}
+
+// podNamespace returns $POD_NAMESPACE if set, "default" otherwise.
+func podNamespace() string {
+	if ns := os.Getenv("POD_NAMESPACE"); ns != "" {
+		return ns
+	}
+	return "default"
+}
+
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	glog.Infof("Serving metrics on %q", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		glog.Fatalf("Error serving metrics: %s", err.Error())
+	}
+}
+
+func serveContexts(addr string, s *contextserver.Server) {
+	glog.Infof("Serving contexts on %q", addr)
+	if err := http.ListenAndServe(addr, s); err != nil {
+		glog.Fatalf("Error serving contexts: %s", err.Error())
+	}
+}
+
+const contextGCInterval = 10 * time.Minute
+
+// gcContexts removes the unused contexts periodically.
+func gcContexts(s *contextserver.Server, hasSynced cache.InformerSynced, stopCh <-chan struct{}) {
+	// the lister needs to be synced, otherwise all the contexts are considered to be unused.
+	if !cache.WaitForCacheSync(stopCh, hasSynced) {
+		return
+	}
+	wait.Until(func() {
+		if err := s.GC(contextGCGracePeriod); err != nil {
+			glog.Warningf("failed to remove unused contexts: %v", err)
+		}
+	}, contextGCInterval, stopCh)
+}
+
+type pluginAddr struct {
+	// addr is hostname:port
+	addr string
+	// name is the plugin name sent as the plugin.MetadataPluginName gRPC metadata.
+	// Empty unless the plugin is served on a multiplexed port (e.g. `cbi-plugins`).
+	name string
+}
+
+// parsePluginsStr parses comma-separated list of hostname[:port][/name]
+func parsePluginsStr(s string) ([]pluginAddr, error) {
 	fields := strings.FieldsFunc(s, func(c rune) bool { return c == ',' || unicode.IsSpace(c) })
-	var res []string
+	var res []pluginAddr
 	for _, f := range fields {
 		if strings.Contains(f, "://") {
 			return nil, fmt.Errorf("bad plugin: extra scheme: %q", f)
 		}
-		if !strings.Contains(f, ":") {
-			f = fmt.Sprintf("%s:%d", f, plugin.DefaultPort)
+		var p pluginAddr
+		if i := strings.Index(f, "/"); i >= 0 {
+			p.name = f[i+1:]
+			if p.name == "" || strings.Contains(p.name, "/") {
+				return nil, fmt.Errorf("bad plugin: invalid name: %q", f)
+			}
+			f = f[:i]
 		}
-		res = append(res, f)
+		p.addr = withDefaultPort(f)
+		res = append(res, p)
 	}
 	return res, nil
 }
 
+// configPluginAddrs converts the plugins in the configuration file.
+func configPluginAddrs(plugins []config.Plugin) []pluginAddr {
+	var res []pluginAddr
+	for _, p := range plugins {
+		res = append(res, pluginAddr{addr: withDefaultPort(p.Address), name: p.Name})
+	}
+	return res
+}
+
+func withDefaultPort(addr string) string {
+	if !strings.Contains(addr, ":") {
+		return fmt.Sprintf("%s:%d", addr, plugin.DefaultPort)
+	}
+	return addr
+}
+
+func (p pluginAddr) String() string {
+	if p.name != "" {
+		return p.addr + "/" + p.name
+	}
+	return p.addr
+}
+
+// pluginInterceptor observes the metrics of the requests, and attaches the
+// plugin.MetadataPluginName gRPC metadata to the requests when p.name is set.
+func pluginInterceptor(p pluginAddr) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if p.name != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, plugin.MetadataPluginName, p.name)
+		}
+		return metrics.ObservePluginRPC(p.String(), method, func() error {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		})
+	}
+}
+
 func init() {
 	flag.StringVar(&kubeconfig, "kubeconfig", "", "Path to a kubeconfig. Only required if out-of-cluster.")
 	flag.StringVar(&masterURL, "master", "", "The address of the Kubernetes API server. Overrides any value in kubeconfig. Only required if out-of-cluster.")
-	flag.StringVar(&pluginsStr, "cbi-plugins", "", "Comma-separated list of CBI plugin hostname[:port]")
+	flag.StringVar(&configPath, "config", "", "Path to the CBIDConfiguration file. The defaults and the policy are reloaded when the file changes.")
+	flag.StringVar(&pluginsStr, "cbi-plugins", "", "Comma-separated list of CBI plugin hostname[:port][/name]. The name is required when multiple plugins are served on a single port (e.g. cbi-plugins)")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address for serving Prometheus metrics on /metrics. Empty to disable.")
+	flag.StringVar(&contextServerAddr, "context-server-addr", "", "The address for serving the Local contexts uploaded from the clients. Empty to disable.")
+	flag.StringVar(&contextServerURL, "context-server-url", "", "The URL of the context server, reachable from the job pods. e.g. http://cbid.cbi-system.svc:8081")
+	flag.StringVar(&contextDir, "context-dir", "/var/lib/cbid/contexts", "Directory for storing the Local contexts.")
+	flag.Int64Var(&contextMaxBytes, "context-max-bytes", contextserver.DefaultMaxBytes, "Maximum size of a Local context.")
+	flag.DurationVar(&contextGCGracePeriod, "context-gc-grace-period", time.Hour, "Duration to keep the Local contexts that are no longer referred by unfinished BuildJobs.")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Enable leader election, so that only a single replica runs the controller at a time.")
+	flag.StringVar(&leaderElectNamespace, "leader-elect-namespace", podNamespace(), "Namespace of the ConfigMap used as the leader election lock. Defaults to $POD_NAMESPACE.")
+	flag.StringVar(&leaderElectName, "leader-elect-name", "cbid", "Name of the ConfigMap used as the leader election lock.")
+	flag.DurationVar(&leaderElectLeaseDuration, "leader-elect-lease-duration", 15*time.Second, "Duration that non-leader candidates will wait before attempting to acquire the leadership.")
+	flag.DurationVar(&leaderElectRenewDeadline, "leader-elect-renew-deadline", 10*time.Second, "Duration that the leader will retry refreshing the leadership before giving up.")
+	flag.DurationVar(&leaderElectRetryPeriod, "leader-elect-retry-period", 2*time.Second, "Duration that the candidates should wait between tries of the leader election actions.")
 }
@@ -0,0 +1,66 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePluginsStr(t *testing.T) {
+	testCases := []struct {
+		s           string
+		expected    []pluginAddr
+		expectedErr bool
+	}{
+		{
+			s: "cbi-docker, cbi-buildkit:4242",
+			expected: []pluginAddr{
+				{addr: "cbi-docker:12111"},
+				{addr: "cbi-buildkit:4242"},
+			},
+		},
+		{
+			s: "cbi-plugins/docker,cbi-plugins:12111/buildkit",
+			expected: []pluginAddr{
+				{addr: "cbi-plugins:12111", name: "docker"},
+				{addr: "cbi-plugins:12111", name: "buildkit"},
+			},
+		},
+		{
+			s:           "tcp://cbi-docker",
+			expectedErr: true,
+		},
+		{
+			s:           "cbi-plugins/",
+			expectedErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		actual, err := parsePluginsStr(tc.s)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%q: %v", tc.s, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%q: error is expected", tc.s)
+			} else if !reflect.DeepEqual(tc.expected, actual) {
+				t.Fatalf("%q: expected %+v, got %+v", tc.s, tc.expected, actual)
+			}
+		}
+	}
+}
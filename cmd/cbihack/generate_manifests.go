@@ -34,6 +34,7 @@ import (
 	aev1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
 
 	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
 	"github.com/containerbuilding/cbi/pkg/plugin"
@@ -196,9 +197,33 @@ func generateManifestsAction(clicontext *cli.Context) error {
 			},
 		)
 	}
+	var cbidDepl *appsv1.Deployment
 	manifestGenerators = append(manifestGenerators,
 		func() (*Manifest, error) {
-			return GenerateCBIDDeployment(namespace, registry, tag, serviceAccount.ObjectMeta.Name, pluginServices)
+			o, e := GenerateCBIDDeployment(namespace, registry, tag, serviceAccount.ObjectMeta.Name, pluginServices)
+			if e == nil {
+				cbidDepl = o.Object.(*appsv1.Deployment)
+			}
+			return o, e
+		},
+		func() (*Manifest, error) {
+			o, e := GenerateService(cbidDepl)
+			if e == nil {
+				svc := o.Object.(*corev1.Service)
+				svc.ObjectMeta.Annotations = prometheusAnnotations(cbidMetricsPort)
+				// multi-port services require the port names
+				svc.Spec.Ports = []corev1.ServicePort{
+					{
+						Name: "metrics",
+						Port: int32(cbidMetricsPort),
+					},
+					{
+						Name: "context",
+						Port: int32(cbidContextPort),
+					},
+				}
+			}
+			return o, e
 		})
 	for _, f := range manifestGenerators {
 		m, err := f()
@@ -269,7 +294,7 @@ func GenerateServiceAccount(namespace string) (*Manifest, error) {
 	}, nil
 }
 
-func GenerateClusterRole(roCRDs []*aev1.CustomResourceDefinition) (*Manifest, error) {
+func GenerateClusterRole(crds []*aev1.CustomResourceDefinition) (*Manifest, error) {
 	o := rbacv1.ClusterRole{
 		TypeMeta: metav1.TypeMeta{
 			APIVersion: rbacv1.SchemeGroupVersion.String(),
@@ -284,13 +309,37 @@ func GenerateClusterRole(roCRDs []*aev1.CustomResourceDefinition) (*Manifest, er
 				Resources: []string{"jobs"},
 				Verbs:     []string{rbacv1.VerbAll},
 			},
+			{
+				APIGroups: []string{corev1.GroupName},
+				Resources: []string{"pods"},
+				Verbs:     []string{"get", "list", "watch"},
+			},
+			// for persisting the build logs
+			{
+				APIGroups: []string{corev1.GroupName},
+				Resources: []string{"pods/log"},
+				Verbs:     []string{"get"},
+			},
+			{
+				APIGroups: []string{corev1.GroupName},
+				Resources: []string{"events"},
+				Verbs:     []string{"create", "patch"},
+			},
+			// for leader election and persisting the build logs
+			{
+				APIGroups: []string{corev1.GroupName},
+				Resources: []string{"configmaps"},
+				Verbs:     []string{"get", "create", "update"},
+			},
 		},
 	}
-	for _, x := range roCRDs {
+	for _, x := range crds {
+		// update is for the status and the annotation for the uploaded contexts,
+		// delete is for the TTL of the finished BuildJobs
 		rule := rbacv1.PolicyRule{
 			APIGroups: []string{x.Spec.Group},
 			Resources: []string{x.Spec.Names.Plural},
-			Verbs:     []string{"get", "list", "watch"},
+			Verbs:     []string{"get", "list", "watch", "update", "delete"},
 		}
 		o.Rules = append(o.Rules, rule)
 	}
@@ -464,11 +513,34 @@ func GenerateBuildKitDaemonDeployment(namespace, imageWithTag string) (*Manifest
 	}, nil
 }
 
+// cbidMetricsPort is the port for serving Prometheus metrics of cbid.
+const cbidMetricsPort = 8080
+
+// cbidContextPort is the port for serving the Local contexts uploaded from the clients.
+const cbidContextPort = 8081
+
+// cbidContextDir is the directory for storing the Local contexts.
+const cbidContextDir = "/var/lib/cbid/contexts"
+
+// cbidReplicas is the number of the cbid replicas.
+// Only the leader runs the controller; the others are hot standbys.
+const cbidReplicas = 2
+
+// prometheusAnnotations returns the annotations for Prometheus service discovery.
+func prometheusAnnotations(port int) map[string]string {
+	return map[string]string{
+		"prometheus.io/scrape": "true",
+		"prometheus.io/port":   fmt.Sprintf("%d", port),
+		"prometheus.io/path":   "/metrics",
+	}
+}
+
 func GenerateCBIDDeployment(namespace, registry, tag, serviceAccountName string, pluginServices []*corev1.Service) (*Manifest, error) {
 	labels := map[string]string{
 		"app": "cbid",
 	}
 	name := "cbid"
+	replicas := int32(cbidReplicas)
 	var pluginAddrs []string
 	for _, svc := range pluginServices {
 		pluginAddrs = append(pluginAddrs, svc.ObjectMeta.Name)
@@ -484,12 +556,14 @@ func GenerateCBIDDeployment(namespace, registry, tag, serviceAccountName string,
 			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
 			Selector: &metav1.LabelSelector{
 				MatchLabels: labels,
 			},
 			Template: corev1.PodTemplateSpec{
 				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
+					Labels:      labels,
+					Annotations: prometheusAnnotations(cbidMetricsPort),
 				},
 				Spec: corev1.PodSpec{
 					ServiceAccountName: serviceAccountName,
@@ -499,6 +573,57 @@ func GenerateCBIDDeployment(namespace, registry, tag, serviceAccountName string,
 							Image: fmt.Sprintf("%s/cbid:%s", registry, tag),
 							Args: []string{
 								"-logtostderr", "-v=4", "-cbi-plugins=" + strings.Join(pluginAddrs, ","),
+								fmt.Sprintf("-metrics-addr=:%d", cbidMetricsPort),
+								fmt.Sprintf("-context-server-addr=:%d", cbidContextPort),
+								fmt.Sprintf("-context-server-url=http://%s.%s.svc:%d", name, namespace, cbidContextPort),
+								"-context-dir=" + cbidContextDir,
+								"-leader-elect",
+							},
+							Env: []corev1.EnvVar{
+								{
+									Name: "POD_NAMESPACE",
+									ValueFrom: &corev1.EnvVarSource{
+										FieldRef: &corev1.ObjectFieldSelector{
+											FieldPath: "metadata.namespace",
+										},
+									},
+								},
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "metrics",
+									ContainerPort: int32(cbidMetricsPort),
+								},
+								{
+									Name:          "context",
+									ContainerPort: int32(cbidContextPort),
+								},
+							},
+							// the context server is served only on the leader,
+							// so that the service routes the uploads and the downloads to the leader.
+							ReadinessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/healthz",
+										Port: intstr.FromInt(cbidContextPort),
+									},
+								},
+							},
+							VolumeMounts: []corev1.VolumeMount{
+								{
+									Name:      "contexts",
+									MountPath: cbidContextDir,
+								},
+							},
+						},
+					},
+					Volumes: []corev1.Volume{
+						{
+							// The contexts lost on the leader changes are uploaded again by cbictl unless the jobs are already created.
+							// Replace with a ReadWriteMany PVC to keep the contexts across the leader changes.
+							Name: "contexts",
+							VolumeSource: corev1.VolumeSource{
+								EmptyDir: &corev1.EmptyDirVolumeSource{},
 							},
 						},
 					},
@@ -0,0 +1,364 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/cyphar/filepath-securejoin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// writeFile writes r to the file name in dir.
+func writeFile(r io.Reader, dir, name string) error {
+	target, err := securejoin.SecureJoin(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// resolvePath resolves name within dir.
+// Unlike securejoin.SecureJoin, the last element is not followed even if it is a symlink.
+func resolvePath(dir, name string) (string, error) {
+	parent, err := securejoin.SecureJoin(dir, path.Dir(path.Clean("/"+name)))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, path.Base(name)), nil
+}
+
+const (
+	mediaTypeTar   = "application/x-tar"
+	mediaTypeGzip  = "application/gzip"
+	mediaTypeBzip2 = "application/x-bzip2"
+	mediaTypeZstd  = "application/zstd"
+	mediaTypeZip   = "application/zip"
+)
+
+// archiveMediaTypes maps the accepted media types to the canonical ones.
+// The compressed media types are for the compressed tar archives.
+var archiveMediaTypes = map[string]string{
+	mediaTypeTar:                   mediaTypeTar,
+	"application/tar":              mediaTypeTar,
+	mediaTypeGzip:                  mediaTypeGzip,
+	"application/x-gzip":           mediaTypeGzip,
+	"application/tar+gzip":         mediaTypeGzip,
+	"application/x-compressed-tar": mediaTypeGzip,
+	mediaTypeBzip2:                 mediaTypeBzip2,
+	"application/x-bzip":           mediaTypeBzip2,
+	"application/tar+bzip2":        mediaTypeBzip2,
+	mediaTypeZstd:                  mediaTypeZstd,
+	"application/tar+zstd":         mediaTypeZstd,
+	mediaTypeZip:                   mediaTypeZip,
+	"application/x-zip-compressed": mediaTypeZip,
+}
+
+// detectArchiveMediaType detects the canonical media type of the archive from the magic bytes.
+// An empty string is returned for unknown formats.
+func detectArchiveMediaType(br *bufio.Reader) string {
+	magics := []struct {
+		mediaType string
+		magic     string
+	}{
+		{mediaTypeGzip, "\x1f\x8b"},
+		{mediaTypeBzip2, "BZh"},
+		{mediaTypeZstd, "\x28\xb5\x2f\xfd"},
+		{mediaTypeZip, "PK\x03\x04"},
+		// empty zip
+		{mediaTypeZip, "PK\x05\x06"},
+	}
+	for _, m := range magics {
+		if b, err := br.Peek(len(m.magic)); err == nil && string(b) == m.magic {
+			return m.mediaType
+		}
+	}
+	// "ustar" at offset 257 (POSIX and GNU)
+	if hdr, err := br.Peek(262); err == nil && string(hdr[257:262]) == "ustar" {
+		return mediaTypeTar
+	}
+	return ""
+}
+
+const (
+	// defaultArchiveMaxSize is the default limit of the total size of the extracted files.
+	defaultArchiveMaxSize = 8 << 30
+	// defaultArchiveMaxEntries is the default limit of the number of the archive entries.
+	defaultArchiveMaxEntries = 1 << 20
+)
+
+type extractOptions struct {
+	// whiteouts makes the OCI whiteout entries remove the files, as in applying the image layers.
+	whiteouts bool
+	// maxSize is the limit of the total size of the extracted files.
+	// 0 means defaultArchiveMaxSize.
+	maxSize int64
+	// maxEntries is the limit of the number of the entries.
+	// 0 means defaultArchiveMaxEntries.
+	maxEntries int
+}
+
+// extractArchive extracts the archive to dir.
+// The format (tar, tar.gz, tar.bz2, tar.zst, or zip) is detected automatically.
+//
+// The entries containing ".." are rejected, and the other paths are resolved within dir,
+// so the entries cannot escape from dir even via symlinks.
+func extractArchive(r io.Reader, dir string, o extractOptions) error {
+	if o.maxSize == 0 {
+		o.maxSize = defaultArchiveMaxSize
+	}
+	if o.maxEntries == 0 {
+		o.maxEntries = defaultArchiveMaxEntries
+	}
+	e := &extractor{dir: dir, o: o}
+	br := bufio.NewReader(r)
+	switch detectArchiveMediaType(br) {
+	case mediaTypeGzip:
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gr.Close()
+		return e.extractTar(gr)
+	case mediaTypeBzip2:
+		return e.extractTar(bzip2.NewReader(br))
+	case mediaTypeZstd:
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		return e.extractTar(zr)
+	case mediaTypeZip:
+		return e.extractZip(br)
+	default:
+		// including the old tar archives without the "ustar" magic
+		return e.extractTar(br)
+	}
+}
+
+// extractor extracts the entries while keeping track of the limits.
+type extractor struct {
+	dir     string
+	o       extractOptions
+	size    int64
+	entries int
+}
+
+func (e *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := e.extractEntry(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// extractZip extracts the zip archive.
+// The archive is stored in a temporary file, as zip needs random access.
+func (e *extractor) extractZip(r io.Reader) error {
+	tmp, err := ioutil.TempFile("", "cbi-archive")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	n, err := io.Copy(tmp, io.LimitReader(r, e.o.maxSize+1))
+	if err != nil {
+		return err
+	}
+	if n > e.o.maxSize {
+		return errors.Errorf("archive exceeds the size limit (%d bytes)", e.o.maxSize)
+	}
+	zr, err := zip.NewReader(tmp, n)
+	if err != nil {
+		return err
+	}
+	for _, f := range zr.File {
+		hdr := &tar.Header{
+			Name:     f.Name,
+			Mode:     int64(f.Mode().Perm()),
+			Typeflag: tar.TypeReg,
+		}
+		switch {
+		case f.Mode().IsDir():
+			hdr.Typeflag = tar.TypeDir
+		case f.Mode()&os.ModeSymlink != 0:
+			hdr.Typeflag = tar.TypeSymlink
+		}
+		if err := e.extractZipEntry(f, hdr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *extractor) extractZipEntry(f *zip.File, hdr *tar.Header) error {
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if hdr.Typeflag == tar.TypeSymlink {
+		// the content is the link target
+		b, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+		if err != nil {
+			return err
+		}
+		hdr.Linkname = string(b)
+	}
+	return e.extractEntry(hdr, rc)
+}
+
+// hasDotDot returns true if name contains ".." elements.
+func hasDotDot(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// extractEntry extracts the entry. r is the content of the regular file.
+func (e *extractor) extractEntry(hdr *tar.Header, r io.Reader) error {
+	e.entries++
+	if e.entries > e.o.maxEntries {
+		return errors.Errorf("archive exceeds the entry limit (%d entries)", e.o.maxEntries)
+	}
+	if hasDotDot(hdr.Name) {
+		return errors.Errorf("entry %q must not contain \"..\"", hdr.Name)
+	}
+	dir := e.dir
+	name := path.Clean("/" + hdr.Name)
+	parent, base := path.Split(name)
+	if e.o.whiteouts && base == ".wh..wh..opq" {
+		// opaque whiteout: remove the children of the parent
+		p, err := securejoin.SecureJoin(dir, parent)
+		if err != nil {
+			return err
+		}
+		children, err := ioutil.ReadDir(p)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, child := range children {
+			if err := os.RemoveAll(filepath.Join(p, child.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if e.o.whiteouts && strings.HasPrefix(base, ".wh.") {
+		p, err := resolvePath(dir, path.Join(parent, strings.TrimPrefix(base, ".wh.")))
+		if err != nil {
+			return err
+		}
+		return os.RemoveAll(p)
+	}
+	if name == "/" {
+		return nil
+	}
+	target, err := resolvePath(dir, name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	mode := hdr.FileInfo().Mode().Perm()
+	if hdr.Typeflag != tar.TypeDir {
+		// replace the existing file, but not the existing directory
+		if fi, err := os.Lstat(target); err == nil && !fi.IsDir() {
+			if err := os.Remove(target); err != nil {
+				return err
+			}
+		}
+	}
+	switch hdr.Typeflag {
+	case tar.TypeDir:
+		return os.MkdirAll(target, mode)
+	case tar.TypeReg, tar.TypeRegA:
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+		if err != nil {
+			return err
+		}
+		if err := e.copy(f, r); err != nil {
+			f.Close()
+			return err
+		}
+		return f.Close()
+	case tar.TypeSymlink:
+		// the symlink may point outside dir, but the later entries are still resolved within dir
+		return os.Symlink(hdr.Linkname, target)
+	case tar.TypeLink:
+		if hasDotDot(hdr.Linkname) {
+			return errors.Errorf("link target %q of entry %q must not contain \"..\"", hdr.Linkname, hdr.Name)
+		}
+		source, err := securejoin.SecureJoin(dir, hdr.Linkname)
+		if err != nil {
+			return err
+		}
+		return os.Link(source, target)
+	default:
+		logrus.Debugf("skipping %s (unsupported type %q)", hdr.Name, hdr.Typeflag)
+		return nil
+	}
+}
+
+// copy copies r to w, up to the size limit.
+func (e *extractor) copy(w io.Writer, r io.Reader) error {
+	n, err := io.Copy(w, io.LimitReader(r, e.o.maxSize-e.size+1))
+	e.size += n
+	if err != nil {
+		return err
+	}
+	if e.size > e.o.maxSize {
+		return errors.Errorf("archive exceeds the size limit (%d bytes)", e.o.maxSize)
+	}
+	return nil
+}
@@ -0,0 +1,219 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestExtractArchive(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "archive-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	entries := []*tar.Header{
+		{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "src/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "src/a", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		{Name: "escape/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+	}
+	expected := map[string]string{
+		"Dockerfile": "Dockerfile",
+		"src":        "<dir>",
+		"src/a":      "src/a",
+		"escape":     "-> /etc",
+		"etc":        "<dir>",
+		"etc/passwd": "escape/passwd",
+	}
+	plain := tarball(t, entries...)
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	gw.Write(plain)
+	gw.Close()
+	var zst bytes.Buffer
+	zw, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw.Write(plain)
+	zw.Close()
+
+	testCases := []struct {
+		name        string
+		archive     []byte
+		opts        extractOptions
+		expected    map[string]string
+		expectedErr bool
+	}{
+		{name: "tar", archive: plain, expected: expected},
+		{name: "tar.gz", archive: gz.Bytes(), expected: expected},
+		{name: "tar.zst", archive: zst.Bytes(), expected: expected},
+		{name: "zip", archive: zipball(t, entries...), expected: expected},
+		{
+			name:        "dotdot",
+			archive:     tarball(t, &tar.Header{Name: "a/../../b", Typeflag: tar.TypeReg, Mode: 0644}),
+			expectedErr: true,
+		},
+		{
+			name:        "dotdot.zip",
+			archive:     zipball(t, &tar.Header{Name: "../b", Typeflag: tar.TypeReg, Mode: 0644}),
+			expectedErr: true,
+		},
+		{
+			name:        "hardlink-dotdot",
+			archive:     tarball(t, &tar.Header{Name: "b", Typeflag: tar.TypeLink, Linkname: "../etc/passwd"}),
+			expectedErr: true,
+		},
+		{
+			name:        "max-size",
+			archive:     plain,
+			opts:        extractOptions{maxSize: 10},
+			expectedErr: true,
+		},
+		{
+			name:        "max-size.zip",
+			archive:     zipball(t, entries...),
+			opts:        extractOptions{maxSize: 10},
+			expectedErr: true,
+		},
+		{
+			name:        "max-entries",
+			archive:     plain,
+			opts:        extractOptions{maxEntries: 2},
+			expectedErr: true,
+		},
+	}
+	if _, err := exec.LookPath("bzip2"); err == nil {
+		cmd := exec.Command("bzip2")
+		cmd.Stdin = bytes.NewReader(plain)
+		bz2, err := cmd.Output()
+		if err != nil {
+			t.Fatal(err)
+		}
+		testCases = append(testCases, struct {
+			name        string
+			archive     []byte
+			opts        extractOptions
+			expected    map[string]string
+			expectedErr bool
+		}{name: "tar.bz2", archive: bz2, expected: expected})
+	}
+	for i, tc := range testCases {
+		dir := filepath.Join(tmp, strconv.Itoa(i))
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		err := extractArchive(bytes.NewReader(tc.archive), dir, tc.opts)
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("%s: error is expected", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if tree := readTree(t, dir); !reflect.DeepEqual(tc.expected, tree) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, tree)
+		}
+	}
+}
+
+func TestDetectArchiveMediaType(t *testing.T) {
+	testCases := map[string][]byte{
+		mediaTypeTar:  tarball(t, &tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644}),
+		mediaTypeGzip: tarGz(t, &tar.Header{Name: "a", Typeflag: tar.TypeReg, Mode: 0644}),
+		mediaTypeZip:  zipball(t),
+		"":            []byte("FROM busybox\n"),
+	}
+	for expected, b := range testCases {
+		if mediaType := detectArchiveMediaType(bufio.NewReader(bytes.NewReader(b))); mediaType != expected {
+			t.Errorf("expected %q, got %q", expected, mediaType)
+		}
+	}
+}
+
+// tarball creates an uncompressed tar archive. The content of each regular file is the name.
+func tarball(t *testing.T, entries ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range entries {
+		var content []byte
+		if hdr.Typeflag == tar.TypeReg {
+			content = []byte(hdr.Name)
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// zipball creates a zip archive from the tar headers.
+func zipball(t *testing.T, entries ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, hdr := range entries {
+		fh := &zip.FileHeader{Name: hdr.Name, Method: zip.Deflate}
+		content := []byte(hdr.Name)
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			fh.SetMode(os.ModeDir | 0755)
+			content = nil
+		case tar.TypeSymlink:
+			fh.SetMode(os.ModeSymlink | 0777)
+			content = []byte(hdr.Linkname)
+		default:
+			fh.SetMode(0644)
+		}
+		w, err := zw.CreateHeader(fh)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,163 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/containerbuilding/cbi/pkg/buildkitsession"
+)
+
+var buildKitSessionCommand = &cli.Command{
+	Name:      "buildkit-session",
+	Usage:     "run buildctl behind the session proxy, with the context streamed from the client attached to the port",
+	ArgsUsage: "[flags] -- buildctl --addr unix://" + buildkitsession.SocketPath + " build --local context=" + buildkitsession.SocketDir + " ...",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "buildkitd-addr",
+			Usage: "buildkitd address (e.g. tcp://service:1234)",
+		},
+		&cli.IntFlag{
+			Name:  "port",
+			Usage: "port for attaching the client (bound to the loopback)",
+			Value: buildkitsession.DefaultPort,
+		},
+		&cli.StringFlag{
+			Name:  "shared-key",
+			Usage: "shared key of the session",
+		},
+		&cli.DurationFlag{
+			Name:  "attach-timeout",
+			Usage: "timeout for the client to be attached",
+			Value: 10 * time.Minute,
+		},
+	},
+	Action: buildKitSessionAction,
+}
+
+func buildKitSessionAction(clicontext *cli.Context) error {
+	args := clicontext.Args().Slice()
+	if len(args) == 0 {
+		return errors.New("buildctl command missing")
+	}
+	addr := clicontext.String("buildkitd-addr")
+	if addr == "" {
+		return errors.New("buildkitd-addr missing")
+	}
+	// listen before dialing buildkitd, so that the client can be attached as soon as the container starts.
+	// The port is not authenticated, so it is bound to the loopback, which is reachable only
+	// via the port-forwarding API (that requires pods/portforward permission).
+	l, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", clicontext.Int("port")))
+	if err != nil {
+		return err
+	}
+	buildkitd, err := dialBuildkitd(addr)
+	if err != nil {
+		l.Close()
+		return err
+	}
+	defer buildkitd.Close()
+	client, err := waitClient(l, clicontext.Duration("attach-timeout"))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if err := os.MkdirAll(buildkitsession.SocketDir, 0700); err != nil {
+		return err
+	}
+	if err := os.Remove(buildkitsession.SocketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	sl, err := net.Listen("unix", buildkitsession.SocketPath)
+	if err != nil {
+		return err
+	}
+	defer sl.Close()
+	proxy := &buildkitsession.Proxy{
+		Buildkitd: buildkitd,
+		Client:    client,
+		SharedKey: clicontext.String("shared-key"),
+	}
+	go func() {
+		if err := proxy.Serve(sl); err != nil {
+			logrus.Debugf("session proxy: %v", err)
+		}
+	}()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	logrus.Debugf("running %q (%v)", args[0], args[1:])
+	return cmd.Run()
+}
+
+// dialBuildkitd dials buildkitd at addr (tcp://HOST:PORT or unix://PATH).
+func dialBuildkitd(addr string) (*grpc.ClientConn, error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	var address string
+	switch u.Scheme {
+	case "tcp":
+		address = u.Host
+	case "unix":
+		address = u.Path
+	default:
+		return nil, errors.Errorf("unsupported buildkitd address: %q", addr)
+	}
+	dialer := func(string, time.Duration) (net.Conn, error) {
+		return net.Dial(u.Scheme, address)
+	}
+	return grpc.Dial(addr, grpc.WithInsecure(), grpc.WithDialer(dialer))
+}
+
+// waitClient waits for the client to be attached via l, and returns the conn
+// to the FileSync server of the client.
+func waitClient(l net.Listener, timeout time.Duration) (*grpc.ClientConn, error) {
+	defer l.Close()
+	fmt.Printf("waiting for the client to be attached on %s\n", l.Addr())
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		conn, err := l.Accept()
+		ch <- result{conn, err}
+	}()
+	select {
+	case r := <-ch:
+		if r.err != nil {
+			return nil, r.err
+		}
+		fmt.Printf("client attached from %s\n", r.conn.RemoteAddr())
+		return buildkitsession.DialConn(r.conn)
+	case <-time.After(timeout):
+		return nil, errors.Errorf("no client attached in %v", timeout)
+	}
+}
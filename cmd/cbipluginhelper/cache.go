@@ -0,0 +1,224 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// contextCache is the cache of the fetched contexts, shared across the builds
+// via a ReadWriteMany volume.
+//
+// The layout of dir:
+//   - git/<sha256 of the repo URL>: the mirror clone of the Git repo
+//   - blobs/sha256/<hex>: the blob (HTTP archive or OCI layer) verified with the digest
+//   - tmp: the temporary files being fetched
+//
+// Each entry is locked with flock(2) on "<entry>.lock": exclusively while being
+// fetched (or evicted), and shared while being used.
+//
+// As the cache may be shared across the namespaces, a cached blob is served only
+// after the origin authorizes the request with the credentials of the build,
+// so that the blob is never leaked to the builds without the access.
+// The modification time of the entry is updated on use, for the LRU eviction.
+type contextCache struct {
+	dir string
+}
+
+// cacheLock is the flock(2) lock of a cache entry.
+type cacheLock struct {
+	f *os.File
+}
+
+// lockCacheEntry locks the entry. how is syscall.LOCK_SH or syscall.LOCK_EX,
+// optionally with syscall.LOCK_NB.
+func lockCacheEntry(entry string, how int) (*cacheLock, error) {
+	if err := os.MkdirAll(filepath.Dir(entry), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(entry+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), how); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &cacheLock{f: f}, nil
+}
+
+// share downgrades the exclusive lock to the shared lock.
+func (l *cacheLock) share() error {
+	return syscall.Flock(int(l.f.Fd()), syscall.LOCK_SH)
+}
+
+func (l *cacheLock) unlock() {
+	// closing the file releases the lock
+	l.f.Close()
+}
+
+// touch marks the entry as recently used.
+func touch(entry string) {
+	now := time.Now()
+	if err := os.Chtimes(entry, now, now); err != nil {
+		logrus.Debugf("failed to touch %s: %v", entry, err)
+	}
+}
+
+func (c *contextCache) tempDir() (string, error) {
+	tmp := filepath.Join(c.dir, "tmp")
+	return tmp, os.MkdirAll(tmp, 0755)
+}
+
+// gitMirror creates or updates the mirror clone of repoURL, and returns the path.
+// The mirror is locked in shared mode until unlock is called.
+// gitOpts are the global options of git, e.g. the credential helper.
+func (c *contextCache) gitMirror(ctx context.Context, repoURL string, gitOpts []string) (string, func(), error) {
+	key := sha256.Sum256([]byte(repoURL))
+	mirror := filepath.Join(c.dir, "git", hex.EncodeToString(key[:]))
+	l, err := lockCacheEntry(mirror, syscall.LOCK_EX)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := os.Stat(mirror); os.IsNotExist(err) {
+		tmp, err := c.tempDir()
+		if err != nil {
+			l.unlock()
+			return "", nil, err
+		}
+		tmpMirror, err := ioutil.TempDir(tmp, "git")
+		if err != nil {
+			l.unlock()
+			return "", nil, err
+		}
+		args := append(append([]string{}, gitOpts...), "clone", "-q", "--mirror", repoURL, tmpMirror)
+		if err := run(ctx, "git", args...); err != nil {
+			os.RemoveAll(tmpMirror)
+			l.unlock()
+			return "", nil, errors.Wrap(err, "failed to create the mirror")
+		}
+		if err := os.Rename(tmpMirror, mirror); err != nil {
+			os.RemoveAll(tmpMirror)
+			l.unlock()
+			return "", nil, err
+		}
+	} else {
+		args := append(append([]string{"-C", mirror}, gitOpts...), "fetch", "-q", "--prune", "origin")
+		if err := run(ctx, "git", args...); err != nil {
+			l.unlock()
+			return "", nil, errors.Wrap(err, "failed to update the mirror")
+		}
+	}
+	touch(mirror)
+	if err := l.share(); err != nil {
+		l.unlock()
+		return "", nil, err
+	}
+	return mirror, l.unlock, nil
+}
+
+var sha256DigestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// blob returns the blob of the digest, fetching it with fetch unless cached.
+// authorize is called on the cache hit, and needs to fail unless the origin
+// grants the access to the blob, e.g. with a HEAD request.
+// The blob is locked in shared mode until release is called.
+func (c *contextCache) blob(digest string, fetch func(io.Writer) error, authorize func() error) (*os.File, func(), error) {
+	if !sha256DigestRegexp.MatchString(digest) {
+		return nil, nil, errors.Errorf("unsupported digest: %q", digest)
+	}
+	p := filepath.Join(c.dir, "blobs", "sha256", digest[len("sha256:"):])
+	l, err := lockCacheEntry(p, syscall.LOCK_EX)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		tmp, err := c.tempDir()
+		if err != nil {
+			l.unlock()
+			return nil, nil, err
+		}
+		f, err := downloadVerified(tmp, digest, fetch)
+		if err != nil {
+			l.unlock()
+			return nil, nil, err
+		}
+		f.Close()
+		if err := os.Rename(f.Name(), p); err != nil {
+			os.Remove(f.Name())
+			l.unlock()
+			return nil, nil, err
+		}
+	} else {
+		if err := authorize(); err != nil {
+			l.unlock()
+			return nil, nil, errors.Wrapf(err, "failed to authorize the cached blob %s", digest)
+		}
+		logrus.Debugf("using the cached blob %s", digest)
+	}
+	touch(p)
+	if err := l.share(); err != nil {
+		l.unlock()
+		return nil, nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		l.unlock()
+		return nil, nil, err
+	}
+	return f, func() {
+		f.Close()
+		l.unlock()
+	}, nil
+}
+
+// downloadVerified fetches the content to a temporary file in dir and verifies the sha256 digest.
+// The returned file is seeked to the beginning, and needs to be removed by the caller.
+func downloadVerified(dir, digest string, fetch func(io.Writer) error) (*os.File, error) {
+	f, err := ioutil.TempFile(dir, "blob")
+	if err != nil {
+		return nil, err
+	}
+	fail := func(err error) (*os.File, error) {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	h := sha256.New()
+	if err := fetch(io.MultiWriter(f, h)); err != nil {
+		return fail(err)
+	}
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != digest {
+		return fail(errors.Errorf("digest mismatch: expected %s, got %s", digest, actual))
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return f, nil
+}
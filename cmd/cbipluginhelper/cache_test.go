@@ -0,0 +1,162 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestContextCacheBlob(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	c := &contextCache{dir: tmp}
+	content := []byte("foo")
+	sum := sha256.Sum256(content)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	fetched := 0
+	fetch := func(w io.Writer) error {
+		fetched++
+		_, err := w.Write(content)
+		return err
+	}
+	authorized := 0
+	authorize := func() error {
+		authorized++
+		return nil
+	}
+	for i := 0; i < 2; i++ {
+		f, release, err := c.blob(digest, fetch, authorize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(f)
+		release()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(b, content) {
+			t.Fatalf("expected %q, got %q", content, b)
+		}
+	}
+	if fetched != 1 {
+		t.Fatalf("expected to be fetched once, got %d", fetched)
+	}
+	if authorized != 1 {
+		t.Fatalf("expected the cache hit to be authorized once, got %d", authorized)
+	}
+	unauthorized := func() error {
+		return errors.New("unauthorized")
+	}
+	if _, _, err := c.blob(digest, fetch, unauthorized); err == nil {
+		t.Fatal("the cached blob must not be served without the authorization")
+	}
+
+	badDigest := "sha256:" + hex.EncodeToString(make([]byte, 32))
+	if _, _, err := c.blob(badDigest, fetch, authorize); err == nil {
+		t.Fatal("digest mismatch is expected")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "blobs", "sha256", hex.EncodeToString(make([]byte, 32)))); !os.IsNotExist(err) {
+		t.Fatalf("the unverified blob must not be cached: %v", err)
+	}
+}
+
+func TestContextCacheEvict(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "cache-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	c := &contextCache{dir: tmp}
+	blobs := filepath.Join(tmp, "blobs", "sha256")
+	if err := os.MkdirAll(blobs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	// "a" is the least recently used
+	for i, name := range []string{"a", "b", "c", "d"} {
+		p := filepath.Join(blobs, name)
+		if err := ioutil.WriteFile(p, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		used := now.Add(time.Duration(i-10) * time.Minute)
+		if err := os.Chtimes(p, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(tmp, "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	staleTmp := filepath.Join(tmp, "tmp", "blob-stale")
+	if err := ioutil.WriteFile(staleTmp, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stale := now.Add(-48 * time.Hour)
+	if err := os.Chtimes(staleTmp, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+	// "b" is in use
+	l, err := lockCacheEntry(filepath.Join(blobs, "b"), syscall.LOCK_SH)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.unlock()
+
+	if err := c.evict(200, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := c.entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, filepath.Base(e.path))
+	}
+	sort.Strings(names)
+	if expected := []string{"b", "d"}; !equalStrings(names, expected) {
+		t.Fatalf("expected %v, got %v", expected, names)
+	}
+	if _, err := os.Stat(staleTmp); !os.IsNotExist(err) {
+		t.Fatalf("stale temporary file should be removed: %v", err)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
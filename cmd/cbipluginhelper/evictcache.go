@@ -0,0 +1,161 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var evictCacheCommand = &cli.Command{
+	Name:      "evict-cache",
+	Usage:     "evict the least recently used entries of the context cache",
+	ArgsUsage: "[flags] CACHE-DIRECTORY",
+	Description: `Evict the least recently used entries until the total size gets smaller than --max-bytes.
+The entries being used by the builds are not evicted.
+
+Supposed to be executed periodically as a CronJob.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "max-bytes",
+			Usage: "Maximum total size of the cache entries",
+		},
+		&cli.DurationFlag{
+			Name:  "tmp-max-age",
+			Usage: "Maximum age of the temporary files left by the interrupted fetches",
+			Value: 24 * time.Hour,
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		dir := clicontext.Args().First()
+		if dir == "" {
+			return errors.New("CACHE-DIRECTORY missing")
+		}
+		maxBytes := clicontext.Int64("max-bytes")
+		if maxBytes <= 0 {
+			return errors.New("--max-bytes needs to be positive")
+		}
+		c := &contextCache{dir: dir}
+		return c.evict(maxBytes, clicontext.Duration("tmp-max-age"))
+	},
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+	used time.Time
+}
+
+// entries returns the Git mirrors and the blobs.
+func (c *contextCache) entries() ([]cacheEntry, error) {
+	var entries []cacheEntry
+	for _, pattern := range []string{"git/*", "blobs/sha256/*"} {
+		paths, err := filepath.Glob(filepath.Join(c.dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if strings.HasSuffix(p, ".lock") {
+				continue
+			}
+			st, err := os.Stat(p)
+			if err != nil {
+				return nil, err
+			}
+			size, err := diskUsage(p)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, cacheEntry{path: p, size: size, used: st.ModTime()})
+		}
+	}
+	return entries, nil
+}
+
+func diskUsage(p string) (int64, error) {
+	var size int64
+	err := filepath.Walk(p, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		size += fi.Size()
+		return nil
+	})
+	return size, err
+}
+
+// evict removes the least recently used entries until the total size gets smaller than maxBytes.
+// The locked entries are skipped.
+// The temporary files older than tmpMaxAge are also removed.
+func (c *contextCache) evict(maxBytes int64, tmpMaxAge time.Duration) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].used.Before(entries[j].used)
+	})
+	var total int64
+	for _, e := range entries {
+		total += e.size
+	}
+	for _, e := range entries {
+		if total <= maxBytes {
+			break
+		}
+		l, err := lockCacheEntry(e.path, syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == syscall.EWOULDBLOCK {
+			logrus.Debugf("skipping %s (in use)", e.path)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		logrus.Infof("evicting %s (%d bytes, last used at %s)", e.path, e.size, e.used.Format(time.RFC3339))
+		err = os.RemoveAll(e.path)
+		l.unlock()
+		if err != nil {
+			return err
+		}
+		total -= e.size
+	}
+	if total > maxBytes {
+		logrus.Warnf("the cache (%d bytes) still exceeds %d bytes, as the entries are in use", total, maxBytes)
+	}
+	tmp := filepath.Join(c.dir, "tmp")
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fi := range files {
+		if time.Since(fi.ModTime()) > tmpMaxAge {
+			if err := os.RemoveAll(filepath.Join(tmp, fi.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/urfave/cli.v2"
+)
+
+// defaultTokenUsername is the username for the token credentials without "username".
+// Most Git forges accept any username with the personal access tokens.
+const defaultTokenUsername = "x-access-token"
+
+var gitCredentialCommand = &cli.Command{
+	Name:      "git-credential",
+	Usage:     "git credential helper that reads the credentials from the secret directory",
+	ArgsUsage: "[flags] get|store|erase",
+	Description: `Git credential helper, used by populate-git.
+
+The secret directory contains either "username" and "password" (basic-auth secret),
+or "token" and optionally "username" (token secret).
+The credentials are returned only for the host specified by --host.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "dir",
+			Usage: "Secret directory",
+		},
+		&cli.StringFlag{
+			Name:  "host",
+			Usage: "Host to return the credentials for",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		if clicontext.Args().First() != "get" {
+			// store and erase are no-op
+			return nil
+		}
+		return gitCredentialGet(clicontext.String("dir"), clicontext.String("host"), os.Stdin, os.Stdout)
+	},
+}
+
+// gitCredentialGet implements the "get" action of the git credential helper protocol.
+func gitCredentialGet(dir, host string, r io.Reader, w io.Writer) error {
+	attrs := make(map[string]string)
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" {
+			break
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) == 2 {
+			attrs[kv[0]] = kv[1]
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	if attrs["host"] != host || (attrs["protocol"] != "https" && attrs["protocol"] != "http") {
+		// let git try other helpers (and fail)
+		return nil
+	}
+	username, password, err := readGitCredentials(dir)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "username=%s\npassword=%s\n", username, password)
+	return err
+}
+
+func readGitCredentials(dir string) (username, password string, err error) {
+	read := func(name string) (string, bool, error) {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return "", false, nil
+		}
+		return strings.TrimSpace(string(b)), err == nil, err
+	}
+	username, _, err = read("username")
+	if err != nil {
+		return "", "", err
+	}
+	token, ok, err := read("token")
+	if err != nil {
+		return "", "", err
+	}
+	if ok {
+		if username == "" {
+			username = defaultTokenUsername
+		}
+		return username, token, nil
+	}
+	password, ok, err = read("password")
+	if err != nil {
+		return "", "", err
+	}
+	if !ok || username == "" {
+		return "", "", errors.Errorf("%s needs to contain either \"token\", or \"username\" and \"password\"", dir)
+	}
+	return username, password, nil
+}
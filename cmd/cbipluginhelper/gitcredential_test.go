@@ -0,0 +1,103 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitCredentialGet(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "gitcredential-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	secret := func(name string, kv map[string]string) string {
+		dir := filepath.Join(tmp, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range kv {
+			if err := ioutil.WriteFile(filepath.Join(dir, k), []byte(v), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+	basicAuth := secret("basic-auth", map[string]string{"username": "foo", "password": "bar\n"})
+	token := secret("token", map[string]string{"token": "baz"})
+	tokenWithUsername := secret("token-with-username", map[string]string{"username": "oauth2", "token": "baz"})
+	invalid := secret("invalid", map[string]string{"password": "bar"})
+	testCases := []struct {
+		dir         string
+		input       string
+		expected    string
+		expectedErr bool
+	}{
+		{
+			dir:      basicAuth,
+			input:    "protocol=https\nhost=example.com\npath=foo.git\n\n",
+			expected: "username=foo\npassword=bar\n",
+		},
+		{
+			dir:      token,
+			input:    "protocol=https\nhost=example.com\n",
+			expected: "username=" + defaultTokenUsername + "\npassword=baz\n",
+		},
+		{
+			dir:      tokenWithUsername,
+			input:    "protocol=https\nhost=example.com\n",
+			expected: "username=oauth2\npassword=baz\n",
+		},
+		{
+			// different host
+			dir:   token,
+			input: "protocol=https\nhost=evil.example.com\n",
+		},
+		{
+			dir:   token,
+			input: "protocol=ssh\nhost=example.com\n",
+		},
+		{
+			dir:         invalid,
+			input:       "protocol=https\nhost=example.com\n",
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		var out bytes.Buffer
+		err := gitCredentialGet(tc.dir, "example.com", strings.NewReader(tc.input), &out)
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("#%d: error expected", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		if out.String() != tc.expected {
+			t.Errorf("#%d: expected %q, got %q", i, tc.expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var lockCommand = &cli.Command{
+	Name:      "lock",
+	Usage:     "run the command while holding the exclusive lock of the file",
+	ArgsUsage: "[flags] -- COMMAND [ARGS...]",
+	Description: `Lock --file with flock(2) so that the builds sharing the persistent storage
+volume are serialized. The lock is released when the command exits.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "file",
+			Usage: "Path of the lock file, created if missing",
+		},
+	},
+	Action: lockAction,
+}
+
+func lockAction(clicontext *cli.Context) error {
+	args := clicontext.Args().Slice()
+	if len(args) == 0 {
+		return errors.New("command missing")
+	}
+	file := clicontext.String("file")
+	if file == "" {
+		return errors.New("file needs to be specified")
+	}
+	f, err := lockFile(file)
+	if err != nil {
+		return errors.Wrapf(err, "failed to lock %s", file)
+	}
+	// closing the file releases the lock
+	defer f.Close()
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	logrus.Debugf("running %q (%v) with the lock %s", args[0], args[1:], file)
+	return cmd.Run()
+}
+
+// lockFile locks file exclusively, blocking until the lock is acquired.
+func lockFile(file string) (*os.File, error) {
+	f, err := os.OpenFile(file, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		logrus.Infof("waiting for the lock %s, held by another build", file)
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
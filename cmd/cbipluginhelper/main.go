@@ -39,6 +39,15 @@ func main() {
 	app.Commands = []*cli.Command{
 		populateGitCommand,
 		populateHTTPCommand,
+		populateOCICommand,
+		populateS3Command,
+		prepareContextCommand,
+		buildKitSessionCommand,
+		sshAgentCommand,
+		lockCommand,
+		outputCommand,
+		gitCredentialCommand,
+		evictCacheCommand,
 	}
 	app.Before = func(context *cli.Context) error {
 		if debug {
@@ -47,6 +56,7 @@ func main() {
 		return nil
 	}
 	if err := app.Run(os.Args); err != nil {
+		writeTerminationMessage(err)
 		if debug {
 			fmt.Fprintf(os.Stderr, "error: %+v\n", err)
 		} else {
@@ -55,3 +65,18 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// terminationMessagePath is the default terminationMessagePath of Kubernetes containers.
+// The message is surfaced in the BuildJob status by the controller.
+const terminationMessagePath = "/dev/termination-log"
+
+// writeTerminationMessage writes err to terminationMessagePath when running in a Kubernetes container.
+func writeTerminationMessage(err error) {
+	f, openErr := os.OpenFile(terminationMessagePath, os.O_WRONLY|os.O_TRUNC, 0)
+	if openErr != nil {
+		// not running in a Kubernetes container
+		return
+	}
+	defer f.Close()
+	fmt.Fprintf(f, "%v", err)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var outputCommand = &cli.Command{
+	Name:      "output",
+	Usage:     "run the command that writes the image tarball, and upload the tarball",
+	ArgsUsage: "[flags] -- COMMAND [ARGS...]",
+	Description: `Create the parent directory of --file, and run the command that writes the tarball to --file.
+The tarball is removed when the command fails.
+When --upload-url is specified, the tarball is uploaded with HTTP PUT after the command succeeds.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "file",
+			Usage: "Path of the tarball written by the command",
+		},
+		&cli.StringFlag{
+			Name:  "upload-url",
+			Usage: "URL to which the tarball is uploaded",
+		},
+		&cli.StringFlag{
+			Name:  "ca-cert",
+			Usage: "PEM file of the CA certificates to be trusted in addition to the system ones",
+		},
+		&cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "Skip verifying the TLS certificate of the server",
+		},
+		&cli.StringFlag{
+			Name:  "headers-dir",
+			Usage: "Directory of the request headers. The file name is the header name, and the content is the value.",
+		},
+	},
+	Action: outputAction,
+}
+
+type uploadOptions struct {
+	caCert             string
+	insecureSkipVerify bool
+	headersDir         string
+}
+
+func outputAction(clicontext *cli.Context) error {
+	args := clicontext.Args().Slice()
+	if len(args) == 0 {
+		return errors.New("command missing")
+	}
+	file := clicontext.String("file")
+	if file == "" {
+		return errors.New("file needs to be specified")
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	logrus.Debugf("running %q (%v) for writing %s", args[0], args[1:], file)
+	if err := cmd.Run(); err != nil {
+		// the incomplete tarball must not be scanned
+		os.Remove(file)
+		return err
+	}
+	if _, err := os.Stat(file); err != nil {
+		return errors.Wrap(err, "the command did not write the tarball")
+	}
+	u := clicontext.String("upload-url")
+	if u == "" {
+		return nil
+	}
+	return uploadHTTP(context.Background(), file, u, uploadOptions{
+		caCert:             clicontext.String("ca-cert"),
+		insecureSkipVerify: clicontext.Bool("insecure-skip-verify"),
+		headersDir:         clicontext.String("headers-dir"),
+	})
+}
+
+// uploadHTTP uploads file to u with HTTP PUT.
+// The URL is never logged, as it may contain the credentials (e.g. presigned URL).
+func uploadHTTP(ctx context.Context, file, u string, o uploadOptions) error {
+	client, err := httpClient(o.caCert, o.insecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", u, f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = st.Size()
+	req.Header.Set("Content-Type", "application/x-tar")
+	if o.headersDir != "" {
+		if err := setHeadersFromDir(req.Header, o.headersDir); err != nil {
+			return err
+		}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("unexpected status %q", resp.Status)
+	}
+	logrus.Infof("uploaded %s (%d bytes)", file, st.Size())
+	return nil
+}
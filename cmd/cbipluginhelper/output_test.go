@@ -0,0 +1,77 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUploadHTTP(t *testing.T) {
+	content := []byte("dummy tarball")
+	var uploaded []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		uploaded = b
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempDir("", "output-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	file := filepath.Join(tmp, "image.tar")
+	if err := ioutil.WriteFile(file, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	headersDir := filepath.Join(tmp, "headers")
+	if err := os.Mkdir(headersDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadHTTP(context.Background(), file, srv.URL, uploadOptions{headersDir: headersDir}); err == nil {
+		t.Fatal("error is expected without the authorization header")
+	}
+	if err := ioutil.WriteFile(filepath.Join(headersDir, "Authorization"), []byte("Bearer secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := uploadHTTP(context.Background(), file, srv.URL, uploadOptions{headersDir: headersDir}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, uploaded) {
+		t.Fatalf("expected %q, got %q", content, uploaded)
+	}
+}
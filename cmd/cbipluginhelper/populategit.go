@@ -18,25 +18,82 @@ package main
 
 import (
 	"context"
+	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/urfave/cli.v2"
 )
 
 var populateGitCommand = &cli.Command{
 	Name:      "populate-git",
-	Usage:     "populate git. Requires git and ssh to be installed.",
+	Usage:     "populate git. Requires git and ssh to be installed. --lfs requires git-lfs.",
 	ArgsUsage: "[flags] REPO-URL DIRECTORY",
 	Flags: []cli.Flag{
 		&cli.StringFlag{
 			Name:  "revision",
 			Usage: "Revision. e.g. master",
 		},
+		&cli.IntFlag{
+			Name:  "depth",
+			Usage: "Depth of the shallow clone (0 for the full clone)",
+		},
+		&cli.BoolFlag{
+			Name:  "submodules",
+			Usage: "Check out the submodules recursively",
+		},
+		&cli.StringSliceFlag{
+			Name:  "sparse-path",
+			Usage: "Check out only the path (sparse-checkout pattern). Can be specified multiple times",
+		},
+		&cli.BoolFlag{
+			Name:  "lfs",
+			Usage: "Fetch the Git LFS objects",
+		},
+		&cli.StringFlag{
+			Name:  "credentials-dir",
+			Usage: "Secret directory with the HTTP(S) credentials (\"username\" and \"password\", or \"token\")",
+		},
+		&cli.StringFlag{
+			Name:  "verify-keys-dir",
+			Usage: "Directory with the trusted public keys for verifying the signature",
+		},
+		&cli.StringFlag{
+			Name:  "verify-format",
+			Usage: "Signature format (gpg or ssh)",
+			Value: "gpg",
+		},
+		&cli.BoolFlag{
+			Name:  "verify-tag",
+			Usage: "Verify the signature of the annotated tag specified by --revision, rather than the commit",
+		},
+		&cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "Context cache directory for the mirror clone",
+		},
 	},
 	Action: populateGitAction,
 }
 
+type gitPopulateOptions struct {
+	revision    string
+	depth       int
+	submodules  bool
+	sparsePaths []string
+	lfs         bool
+	// credentialsDir is passed to the git-credential helper
+	credentialsDir string
+	// verify is enabled when verify.keysDir is set
+	verify gitVerifyOptions
+	// cacheDir is the context cache directory
+	cacheDir string
+}
+
 func populateGitAction(clicontext *cli.Context) error {
 	repoURL := clicontext.Args().Get(0)
 	if repoURL == "" {
@@ -46,13 +103,158 @@ func populateGitAction(clicontext *cli.Context) error {
 	if dir == "" {
 		return errors.New("DIRECTORY missing")
 	}
-	ctx := context.Background()
-	if err := run(ctx, "git", "clone", repoURL, dir); err != nil {
+	return populateGit(context.Background(), repoURL, dir, gitPopulateOptions{
+		revision:       clicontext.String("revision"),
+		depth:          clicontext.Int("depth"),
+		submodules:     clicontext.Bool("submodules"),
+		sparsePaths:    clicontext.StringSlice("sparse-path"),
+		lfs:            clicontext.Bool("lfs"),
+		credentialsDir: clicontext.String("credentials-dir"),
+		verify: gitVerifyOptions{
+			keysDir: clicontext.String("verify-keys-dir"),
+			format:  clicontext.String("verify-format"),
+			tag:     clicontext.Bool("verify-tag"),
+		},
+		cacheDir: clicontext.String("cache-dir"),
+	})
+}
+
+// populateGit fetches only the revision (with the depth) rather than cloning the whole repo.
+// A commit SHA is also fetched directly when the server allows it (e.g. GitHub),
+// otherwise the whole repo is fetched.
+// When the cache is enabled, the objects in the mirror clone are borrowed.
+func populateGit(ctx context.Context, repoURL, dir string, o gitPopulateOptions) error {
+	if o.depth < 0 {
+		return errors.Errorf("invalid depth: %d", o.depth)
+	}
+	if o.verify.tag && o.revision == "" {
+		return errors.New("tag verification requires the revision")
+	}
+	var gitOpts []string
+	if o.credentialsDir != "" {
+		helperOpts, err := gitCredentialHelperOpts(repoURL, o.credentialsDir)
+		if err != nil {
+			return err
+		}
+		gitOpts = append(gitOpts, helperOpts...)
+	}
+	git := func(args ...string) error {
+		return run(ctx, "git", append(append([]string{"-C", dir}, gitOpts...), args...)...)
+	}
+	if err := run(ctx, "git", "init", "-q", dir); err != nil {
 		return err
 	}
-	os.Chdir(dir)
-	if revision := clicontext.String("revision"); revision != "" {
-		return run(ctx, "git", "checkout", revision)
+	if err := git("remote", "add", "origin", repoURL); err != nil {
+		return err
+	}
+	alternates := filepath.Join(dir, ".git", "objects", "info", "alternates")
+	if o.cacheDir != "" {
+		mirror, unlock, err := (&contextCache{dir: o.cacheDir}).gitMirror(ctx, repoURL, gitOpts)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		// same as `git clone --reference`
+		if err := ioutil.WriteFile(alternates, []byte(filepath.Join(mirror, "objects")+"\n"), 0644); err != nil {
+			return err
+		}
+	}
+	if len(o.sparsePaths) > 0 {
+		if err := git("config", "core.sparseCheckout", "true"); err != nil {
+			return err
+		}
+		patterns := strings.Join(o.sparsePaths, "\n") + "\n"
+		if err := ioutil.WriteFile(filepath.Join(dir, ".git", "info", "sparse-checkout"), []byte(patterns), 0644); err != nil {
+			return err
+		}
+	}
+	if o.lfs {
+		// the LFS objects are pulled after the checkout, for the sparse paths only
+		if err := git("lfs", "install", "--local", "--skip-smudge"); err != nil {
+			return errors.Wrap(err, "failed to install git-lfs")
+		}
+	}
+	fetch := []string{"fetch", "-q"}
+	if o.depth > 0 {
+		fetch = append(fetch, "--depth", strconv.Itoa(o.depth))
+	}
+	ref := o.revision
+	if ref == "" {
+		ref = "HEAD"
+	}
+	checkout := "FETCH_HEAD"
+	if err := git(append(fetch, "origin", ref)...); err != nil {
+		if o.revision == "" {
+			return err
+		}
+		// abbreviated SHAs, or SHAs not allowed to be fetched by the server
+		logrus.Debugf("failed to fetch %q directly, fetching the whole repo: %v", ref, err)
+		if err := git("fetch", "-q", "--tags", "origin", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+			return err
+		}
+		checkout = o.revision
+	}
+	if err := git("checkout", "-q", "--detach", checkout); err != nil {
+		return err
+	}
+	if o.cacheDir != "" {
+		// same as `git clone --dissociate`, as the cache is not mounted on the build container
+		if err := git("repack", "-a", "-d", "-q"); err != nil {
+			return err
+		}
+		if err := os.Remove(alternates); err != nil {
+			return err
+		}
+	}
+	if o.verify.keysDir != "" {
+		// FETCH_HEAD is the tag object when the tag is fetched directly
+		if err := verifyGit(ctx, dir, gitOpts, checkout, o.verify); err != nil {
+			return err
+		}
+	}
+	if o.submodules {
+		if err := git("submodule", "update", "-q", "--init", "--recursive"); err != nil {
+			return err
+		}
+	}
+	if o.lfs {
+		if err := git("lfs", "pull"); err != nil {
+			return err
+		}
 	}
 	return nil
 }
+
+// gitCredentialHelperOpts returns the `git -c` options for using the git-credential command
+// of this executable as the credential helper.
+// The helper is configured on the command line rather than in .git/config, and the helper
+// reads the credentials from dir, so that the credentials are never written to the clone
+// nor printed in the logs.
+func gitCredentialHelperOpts(repoURL, dir string) ([]string, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" && u.Scheme != "http" {
+		return nil, errors.Errorf("credentials are supported only for HTTP(S) repos, got %q", repoURL)
+	}
+	if u.User != nil {
+		return nil, errors.Errorf("repo URL must not contain the credentials when the credentials directory is specified")
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	// "!" lets git run the helper via the shell, with the action appended
+	helper := "!" + strings.Join([]string{shellQuote(self), "git-credential",
+		"--dir", shellQuote(dir), "--host", shellQuote(u.Host)}, " ")
+	return []string{
+		// clear the helpers configured elsewhere
+		"-c", "credential.helper=",
+		"-c", "credential.helper=" + helper,
+	}, nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'"'"'`, -1) + "'"
+}
@@ -0,0 +1,152 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPopulateGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmp, err := ioutil.TempDir("", "populategit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// recent git disallows file:// submodules by default
+	os.Setenv("GIT_CONFIG_COUNT", "1")
+	os.Setenv("GIT_CONFIG_KEY_0", "protocol.file.allow")
+	os.Setenv("GIT_CONFIG_VALUE_0", "always")
+	defer os.Unsetenv("GIT_CONFIG_COUNT")
+
+	git := func(dir string, args ...string) string {
+		args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	write := func(dir, name, content string) {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lib := filepath.Join(tmp, "lib")
+	git(tmp, "init", "-q", lib)
+	write(lib, "lib.txt", "lib")
+	git(lib, "add", ".")
+	git(lib, "commit", "-q", "-m", "lib")
+
+	repo := filepath.Join(tmp, "repo")
+	git(tmp, "init", "-q", repo)
+	write(repo, "a.txt", "1")
+	git(repo, "add", ".")
+	git(repo, "commit", "-q", "-m", "1")
+	git(repo, "tag", "v1")
+	commit1 := git(repo, "rev-parse", "HEAD")
+	write(repo, "a.txt", "2")
+	write(repo, "c/d.txt", "d")
+	git(repo, "submodule", "-q", "add", lib, "lib")
+	git(repo, "add", ".")
+	git(repo, "commit", "-q", "-m", "2")
+
+	cacheDir := filepath.Join(tmp, "cache")
+
+	testCases := []struct {
+		opts gitPopulateOptions
+		// expected is the content of the files, "" for the absent files
+		expected      map[string]string
+		expectedDepth int
+	}{
+		{
+			expected: map[string]string{"a.txt": "2", "c/d.txt": "d", "lib/lib.txt": ""},
+		},
+		{
+			opts:          gitPopulateOptions{revision: "v1", depth: 1},
+			expected:      map[string]string{"a.txt": "1", "c/d.txt": ""},
+			expectedDepth: 1,
+		},
+		{
+			opts:     gitPopulateOptions{revision: commit1, depth: 1},
+			expected: map[string]string{"a.txt": "1", "c/d.txt": ""},
+		},
+		{
+			// abbreviated SHA cannot be fetched directly
+			opts:     gitPopulateOptions{revision: commit1[:7], depth: 1},
+			expected: map[string]string{"a.txt": "1", "c/d.txt": ""},
+		},
+		{
+			opts:     gitPopulateOptions{sparsePaths: []string{"c/"}},
+			expected: map[string]string{"a.txt": "", "c/d.txt": "d"},
+		},
+		{
+			opts:     gitPopulateOptions{submodules: true, depth: 1},
+			expected: map[string]string{"a.txt": "2", "lib/lib.txt": "lib"},
+		},
+		{
+			opts:     gitPopulateOptions{revision: "v1", cacheDir: cacheDir},
+			expected: map[string]string{"a.txt": "1", "c/d.txt": ""},
+		},
+		{
+			// mirror is updated
+			opts:     gitPopulateOptions{revision: commit1[:7], cacheDir: cacheDir},
+			expected: map[string]string{"a.txt": "1", "c/d.txt": ""},
+		},
+	}
+	for i, tc := range testCases {
+		dir := filepath.Join(tmp, "context", strconv.Itoa(i))
+		if err := populateGit(context.TODO(), "file://"+repo, dir, tc.opts); err != nil {
+			t.Errorf("#%d: %v", i, err)
+			continue
+		}
+		for name, expected := range tc.expected {
+			b, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			if string(b) != expected {
+				t.Errorf("#%d: %s: expected %q, got %q", i, name, expected, string(b))
+			}
+		}
+		// the cache must not be referred after populating
+		if _, err := os.Stat(filepath.Join(dir, ".git", "objects", "info", "alternates")); !os.IsNotExist(err) {
+			t.Errorf("#%d: alternates should not exist: %v", i, err)
+		}
+		if out := git(dir, "fsck", "--no-progress"); out != "" {
+			t.Errorf("#%d: fsck: %s", i, out)
+		}
+		if tc.expectedDepth > 0 {
+			if depth := git(dir, "rev-list", "--count", "HEAD"); depth != strconv.Itoa(tc.expectedDepth) {
+				t.Errorf("#%d: expected depth %d, got %s", i, tc.expectedDepth, depth)
+			}
+		}
+	}
+}
@@ -17,20 +17,74 @@ limitations under the License.
 package main
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
 	"gopkg.in/urfave/cli.v2"
 )
 
 var populateHTTPCommand = &cli.Command{
 	Name:      "populate-http",
-	Usage:     "populate an tar archive via HTTP(S). Requires bsdtar to be installed (for auto-detecting gzip compression).",
+	Usage:     "populate an archive (tar, tar.gz, tar.bz2, tar.zst, or zip) via HTTP(S)",
 	ArgsUsage: "[flags] URL DIRECTORY",
-	Action:    populateHTTPAction,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "digest",
+			Usage: "Expected digest of the archive. e.g. sha256:deadbeef...",
+		},
+		&cli.StringFlag{
+			Name:  "media-type",
+			Usage: "Expected media type of the archive. e.g. application/x-tar, application/gzip",
+		},
+		&cli.StringFlag{
+			Name:  "ca-cert",
+			Usage: "PEM file of the CA certificates to be trusted in addition to the system ones",
+		},
+		&cli.BoolFlag{
+			Name:  "insecure-skip-verify",
+			Usage: "Skip verifying the TLS certificate of the server",
+		},
+		&cli.StringFlag{
+			Name:  "headers-dir",
+			Usage: "Directory of the request headers. The file name is the header name, and the content is the value.",
+		},
+		&cli.Int64Flag{
+			Name:  "max-size",
+			Usage: "Limit of the total size of the extracted files in bytes",
+			Value: defaultArchiveMaxSize,
+		},
+		&cli.IntFlag{
+			Name:  "max-entries",
+			Usage: "Limit of the number of the archive entries",
+			Value: defaultArchiveMaxEntries,
+		},
+		&cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "Context cache directory. The archive is cached only when --digest is specified.",
+		},
+	},
+	Action: populateHTTPAction,
+}
+
+type httpPopulateOptions struct {
+	digest             string
+	mediaType          string
+	caCert             string
+	insecureSkipVerify bool
+	headersDir         string
+	extract            extractOptions
+	// cacheDir is the context cache directory, used only when digest is set
+	cacheDir string
 }
 
 func populateHTTPAction(clicontext *cli.Context) error {
@@ -42,21 +96,174 @@ func populateHTTPAction(clicontext *cli.Context) error {
 	if dir == "" {
 		return errors.New("DIRECTORY missing")
 	}
+	return populateHTTP(context.Background(), u, dir, httpPopulateOptions{
+		digest:             clicontext.String("digest"),
+		mediaType:          clicontext.String("media-type"),
+		caCert:             clicontext.String("ca-cert"),
+		insecureSkipVerify: clicontext.Bool("insecure-skip-verify"),
+		headersDir:         clicontext.String("headers-dir"),
+		extract: extractOptions{
+			maxSize:    clicontext.Int64("max-size"),
+			maxEntries: clicontext.Int("max-entries"),
+		},
+		cacheDir: clicontext.String("cache-dir"),
+	})
+}
+
+// populateHTTP downloads the archive and extracts it to dir.
+// When the digest is specified, the archive is verified before the extraction,
+// and cached by the digest if the cache is enabled.
+func populateHTTP(ctx context.Context, u, dir string, o httpPopulateOptions) error {
+	if o.digest != "" && !strings.HasPrefix(o.digest, "sha256:") {
+		return errors.Errorf("unsupported digest: %q", o.digest)
+	}
+	var mediaType string
+	if o.mediaType != "" {
+		var ok bool
+		mediaType, ok = archiveMediaTypes[strings.ToLower(o.mediaType)]
+		if !ok {
+			return errors.Errorf("unsupported media type: %q", o.mediaType)
+		}
+	}
+	client, err := httpClient(o.caCert, o.insecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	do := func(method string) (io.ReadCloser, error) {
+		req, err := http.NewRequest(method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if o.headersDir != "" {
+			if err := setHeadersFromDir(req.Header, o.headersDir); err != nil {
+				return nil, err
+			}
+		}
+		resp, err := client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.Errorf("%s: unexpected status %q", method, resp.Status)
+		}
+		return resp.Body, nil
+	}
+	get := func() (io.ReadCloser, error) {
+		return do(http.MethodGet)
+	}
+	// head checks that the cached archive is still accessible with the headers and the CA
+	head := func() error {
+		body, err := do(http.MethodHead)
+		if err != nil {
+			return err
+		}
+		return body.Close()
+	}
+	download := func(w io.Writer) error {
+		body, err := get()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		_, err = io.Copy(w, body)
+		return err
+	}
+	var r io.Reader
+	switch {
+	case o.digest != "" && o.cacheDir != "":
+		f, release, err := (&contextCache{dir: o.cacheDir}).blob(o.digest, download, head)
+		if err != nil {
+			return err
+		}
+		defer release()
+		r = f
+	case o.digest != "":
+		// download to a temporary file, so that the unverified archive is never extracted
+		f, err := downloadVerified("", o.digest, download)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+		r = f
+	default:
+		body, err := get()
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+		r = body
+	}
+	br := bufio.NewReader(r)
+	if mediaType != "" {
+		if detected := detectArchiveMediaType(br); detected != mediaType {
+			return errors.Errorf("media type mismatch: expected %s, got %q", mediaType, detected)
+		}
+	}
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	resp, err := http.Get(u)
+	return extractArchive(br, dir, o.extract)
+}
+
+// httpClient returns the HTTP client that trusts caCert in addition to the system CAs.
+func httpClient(caCert string, insecureSkipVerify bool) (*http.Client, error) {
+	if caCert == "" && !insecureSkipVerify {
+		return http.DefaultClient, nil
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: insecureSkipVerify,
+	}
+	if caCert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			logrus.Debugf("failed to load the system CAs: %v", err)
+			pool = x509.NewCertPool()
+		}
+		pem, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.Errorf("no certificate found in %s", caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
+
+// setHeadersFromDir sets the headers read from the files in dir.
+// Hidden files (e.g. "..data" of the secret volume) are ignored.
+// The values are never logged, as they may contain the credentials.
+func setHeadersFromDir(h http.Header, dir string) error {
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
-	ctx := context.Background()
-	// busybox tar and GNU tar can auto-detect gzip files, but not gzip stream.
-	// so we use bsdtar.
-	// TODO: rewrite in pure Go.
-	cmd := exec.CommandContext(ctx, "bsdtar", "Cxvf", dir, "-")
-	cmd.Stdin = resp.Body
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	for _, name := range names {
+		base := filepath.Base(name)
+		if strings.HasPrefix(base, ".") {
+			continue
+		}
+		st, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		if !st.Mode().IsRegular() {
+			continue
+		}
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		h.Set(base, strings.TrimSpace(string(b)))
+		logrus.Debugf("setting header %q", base)
+	}
+	return nil
 }
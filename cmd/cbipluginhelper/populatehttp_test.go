@@ -0,0 +1,129 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestPopulateHTTP(t *testing.T) {
+	archive := tarGz(t, &tar.Header{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644})
+	sum := sha256.Sum256(archive)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(archive)
+	}))
+	defer srv.Close()
+
+	tmp, err := ioutil.TempDir("", "populatehttp-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	caCert := filepath.Join(tmp, "ca.crt")
+	if err := ioutil.WriteFile(caCert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	headersDir := filepath.Join(tmp, "headers")
+	if err := os.Mkdir(headersDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(headersDir, "Authorization"), []byte("Bearer secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheDir := filepath.Join(tmp, "cache")
+
+	testCases := []struct {
+		opts        httpPopulateOptions
+		expectedErr bool
+	}{
+		{
+			opts: httpPopulateOptions{caCert: caCert, headersDir: headersDir, digest: digest, mediaType: "application/x-gzip"},
+		},
+		{
+			opts: httpPopulateOptions{caCert: caCert, headersDir: headersDir, digest: digest, cacheDir: cacheDir},
+		},
+		{
+			// cached
+			opts: httpPopulateOptions{caCert: caCert, headersDir: headersDir, digest: digest, cacheDir: cacheDir},
+		},
+		{
+			// cached, but no auth header
+			opts:        httpPopulateOptions{caCert: caCert, digest: digest, cacheDir: cacheDir},
+			expectedErr: true,
+		},
+		{
+			opts: httpPopulateOptions{insecureSkipVerify: true, headersDir: headersDir},
+		},
+		{
+			// unknown CA
+			opts:        httpPopulateOptions{headersDir: headersDir},
+			expectedErr: true,
+		},
+		{
+			// no auth header
+			opts:        httpPopulateOptions{caCert: caCert},
+			expectedErr: true,
+		},
+		{
+			opts:        httpPopulateOptions{caCert: caCert, headersDir: headersDir, digest: "sha256:" + hex.EncodeToString(make([]byte, 32))},
+			expectedErr: true,
+		},
+		{
+			opts:        httpPopulateOptions{caCert: caCert, headersDir: headersDir, mediaType: "application/x-tar"},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		dir := filepath.Join(tmp, "context", strconv.Itoa(i))
+		err := populateHTTP(context.TODO(), srv.URL, dir, tc.opts)
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("#%d: error is expected", i)
+			}
+			if _, statErr := os.Stat(dir); !os.IsNotExist(statErr) {
+				t.Errorf("#%d: the archive should not be extracted", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		expected := map[string]string{"Dockerfile": "Dockerfile"}
+		if tree := readTree(t, dir); !reflect.DeepEqual(expected, tree) {
+			t.Errorf("#%d: expected %v, got %v", i, expected, tree)
+		}
+	}
+}
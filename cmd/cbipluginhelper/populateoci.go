@@ -0,0 +1,500 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var populateOCICommand = &cli.Command{
+	Name:      "populate-oci",
+	Usage:     "populate the layers of an OCI image (or artifact) from a registry",
+	ArgsUsage: "[flags] REF DIRECTORY",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "digest",
+			Usage: "Expected digest of the manifest. e.g. sha256:deadbeef...",
+		},
+		&cli.StringFlag{
+			Name:  "docker-config",
+			Usage: "Path to the Docker config.json for the registry credentials",
+		},
+		&cli.StringFlag{
+			Name:  "platform",
+			Usage: "Platform to be chosen from the image index",
+			Value: runtime.GOOS + "/" + runtime.GOARCH,
+		},
+		&cli.BoolFlag{
+			Name:  "plain-http",
+			Usage: "Use plain HTTP instead of HTTPS",
+		},
+		&cli.StringFlag{
+			Name:  "cache-dir",
+			Usage: "Context cache directory for the layers",
+		},
+	},
+	Action: populateOCIAction,
+}
+
+func populateOCIAction(clicontext *cli.Context) error {
+	ref := clicontext.Args().Get(0)
+	if ref == "" {
+		return errors.New("REF missing")
+	}
+	dir := clicontext.Args().Get(1)
+	if dir == "" {
+		return errors.New("DIRECTORY missing")
+	}
+	return pullOCI(context.Background(), ociPullOptions{
+		ref:          ref,
+		digest:       clicontext.String("digest"),
+		dockerConfig: clicontext.String("docker-config"),
+		platform:     clicontext.String("platform"),
+		plainHTTP:    clicontext.Bool("plain-http"),
+		cacheDir:     clicontext.String("cache-dir"),
+	}, dir)
+}
+
+const (
+	mediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	mediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// annotationTitle is the file name of non-tar layers (e.g. ORAS artifacts).
+	annotationTitle = "org.opencontainers.image.title"
+	// annotationUnpack is set by ORAS for the tar+gzip layers of directories.
+	annotationUnpack = "io.deis.oras.content.unpack"
+)
+
+type ociPullOptions struct {
+	ref          string
+	digest       string
+	dockerConfig string
+	// platform is "OS/ARCH"
+	platform  string
+	plainHTTP bool
+	// cacheDir is the context cache directory
+	cacheDir string
+}
+
+type ociReference struct {
+	// host is the registry API host, e.g. "registry-1.docker.io"
+	host       string
+	repository string
+	tag        string
+	digest     string
+}
+
+// parseOCIReference parses the image reference in the same way as Docker,
+// e.g. "alpine" is "registry-1.docker.io/library/alpine:latest".
+func parseOCIReference(s string) (ociReference, error) {
+	var ref ociReference
+	if i := strings.Index(s, "@"); i >= 0 {
+		s, ref.digest = s[:i], s[i+1:]
+	}
+	if i := strings.LastIndex(s, ":"); i > strings.LastIndex(s, "/") {
+		s, ref.tag = s[:i], s[i+1:]
+	}
+	if i := strings.Index(s, "/"); i >= 0 && (strings.ContainsAny(s[:i], ".:") || s[:i] == "localhost") {
+		ref.host, ref.repository = s[:i], s[i+1:]
+	} else {
+		ref.host, ref.repository = "docker.io", s
+	}
+	if ref.host == "docker.io" {
+		ref.host = "registry-1.docker.io"
+		if !strings.Contains(ref.repository, "/") {
+			ref.repository = "library/" + ref.repository
+		}
+	}
+	if ref.repository == "" || ref.repository != strings.ToLower(ref.repository) {
+		return ref, errors.Errorf("invalid reference: %q", s)
+	}
+	if ref.tag == "" && ref.digest == "" {
+		ref.tag = "latest"
+	}
+	return ref, nil
+}
+
+// registryCredentials returns the credentials for host in the Docker config.json.
+func registryCredentials(configPath, host string) (username, password string, err error) {
+	if configPath == "" {
+		return "", "", nil
+	}
+	b, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return "", "", err
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth     string `json:"auth"`
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal(b, &config); err != nil {
+		return "", "", errors.Wrapf(err, "failed to parse %s", configPath)
+	}
+	keys := []string{host, "https://" + host, "http://" + host}
+	if host == "registry-1.docker.io" {
+		keys = append(keys, "https://index.docker.io/v1/", "docker.io")
+	}
+	for _, k := range keys {
+		a, ok := config.Auths[k]
+		if !ok {
+			continue
+		}
+		if a.Auth == "" {
+			return a.Username, a.Password, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return "", "", errors.Wrapf(err, "failed to decode the auth for %s", k)
+		}
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return "", "", errors.Errorf("invalid auth for %s", k)
+		}
+		return parts[0], parts[1], nil
+	}
+	return "", "", nil
+}
+
+// registryClient is a minimal client of the registry API (pull only).
+type registryClient struct {
+	client     *http.Client
+	baseURL    string
+	repository string
+	username   string
+	password   string
+	// basic is true when the registry requires the basic auth.
+	basic bool
+	// token is the bearer token.
+	token string
+	// cache is nil when the cache is disabled.
+	cache *contextCache
+}
+
+var challengeParamRegexp = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authorize handles the WWW-Authenticate challenge.
+func (c *registryClient) authorize(ctx context.Context, challenge string) error {
+	scheme := strings.ToLower(strings.SplitN(challenge, " ", 2)[0])
+	switch scheme {
+	case "basic":
+		if c.username == "" {
+			return errors.New("the registry requires credentials")
+		}
+		c.basic = true
+		return nil
+	case "bearer":
+	default:
+		return errors.Errorf("unsupported auth challenge: %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, m := range challengeParamRegexp.FindAllStringSubmatch(challenge, -1) {
+		params[m[1]] = m[2]
+	}
+	if params["realm"] == "" {
+		return errors.Errorf("no realm in the auth challenge: %q", challenge)
+	}
+	u, err := url.Parse(params["realm"])
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	q.Set("scope", "repository:"+c.repository+":pull")
+	u.RawQuery = q.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("failed to get the token from %s: unexpected status %q", params["realm"], resp.Status)
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return err
+	}
+	c.token = token.Token
+	if c.token == "" {
+		c.token = token.AccessToken
+	}
+	if c.token == "" {
+		return errors.Errorf("no token returned from %s", params["realm"])
+	}
+	return nil
+}
+
+// get gets /v2/<repository>/<p>, authorizing on the first 401.
+func (c *registryClient) get(ctx context.Context, p string, accept ...string) (*http.Response, error) {
+	return c.do(ctx, http.MethodGet, p, accept...)
+}
+
+// head checks that /v2/<repository>/<p> is accessible, authorizing on the first 401.
+func (c *registryClient) head(ctx context.Context, p string) error {
+	resp, err := c.do(ctx, http.MethodHead, p)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (c *registryClient) do(ctx context.Context, method, p string, accept ...string) (*http.Response, error) {
+	for retried := false; ; retried = true {
+		req, err := http.NewRequest(method, c.baseURL+"/v2/"+c.repository+"/"+p, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range accept {
+			req.Header.Add("Accept", a)
+		}
+		switch {
+		case c.token != "":
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		case c.basic:
+			req.SetBasicAuth(c.username, c.password)
+		}
+		resp, err := c.client.Do(req.WithContext(ctx))
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && !retried {
+			resp.Body.Close()
+			if err := c.authorize(ctx, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, errors.Errorf("%s %s: unexpected status %q", method, p, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+// ociDescriptor is the OCI content descriptor, also used for the Docker manifests.
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations"`
+	Platform    *struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+	} `json:"platform"`
+}
+
+// ociManifest is either the image manifest or the image index.
+type ociManifest struct {
+	MediaType string          `json:"mediaType"`
+	Manifests []ociDescriptor `json:"manifests"`
+	Layers    []ociDescriptor `json:"layers"`
+}
+
+func verifyDigest(h []byte, expected string) error {
+	if actual := "sha256:" + hex.EncodeToString(h); actual != expected {
+		return errors.Errorf("digest mismatch: expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// fetchManifest fetches the manifest of the reference (tag or digest).
+// The index is resolved to the manifest for the platform.
+func (c *registryClient) fetchManifest(ctx context.Context, reference, platform string) (*ociManifest, error) {
+	resp, err := c.get(ctx, "manifests/"+reference,
+		mediaTypeOCIManifest, mediaTypeOCIIndex, mediaTypeDockerManifest, mediaTypeDockerManifestList)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(reference, "sha256:") {
+		h := sha256.Sum256(b)
+		if err := verifyDigest(h[:], reference); err != nil {
+			return nil, err
+		}
+	}
+	var m ociManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	mediaType := m.MediaType
+	if mediaType == "" {
+		mediaType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	}
+	switch mediaType {
+	case mediaTypeOCIManifest, mediaTypeDockerManifest:
+		return &m, nil
+	case mediaTypeOCIIndex, mediaTypeDockerManifestList:
+		d, err := choosePlatform(m.Manifests, platform)
+		if err != nil {
+			return nil, err
+		}
+		logrus.Debugf("resolved %s to %s for %s", reference, d.Digest, platform)
+		return c.fetchManifest(ctx, d.Digest, platform)
+	default:
+		return nil, errors.Errorf("unsupported manifest media type: %q", mediaType)
+	}
+}
+
+func choosePlatform(manifests []ociDescriptor, platform string) (*ociDescriptor, error) {
+	for i := range manifests {
+		d := &manifests[i]
+		if d.Platform != nil && d.Platform.OS+"/"+d.Platform.Architecture == platform {
+			return d, nil
+		}
+	}
+	// artifacts are usually platform-independent
+	if len(manifests) == 1 {
+		return &manifests[0], nil
+	}
+	return nil, errors.Errorf("no manifest for platform %q", platform)
+}
+
+// fetchLayer fetches the layer and applies it to dir.
+// The layer is verified before being applied, so that the tampered layer is never extracted.
+func (c *registryClient) fetchLayer(ctx context.Context, d ociDescriptor, dir string) error {
+	fetch := func(w io.Writer) error {
+		resp, err := c.get(ctx, "blobs/"+d.Digest)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(w, resp.Body)
+		return err
+	}
+	if c.cache != nil {
+		f, release, err := c.cache.blob(d.Digest, fetch, func() error {
+			// the blob may have been cached from another repository
+			return c.head(ctx, "blobs/"+d.Digest)
+		})
+		if err != nil {
+			return err
+		}
+		defer release()
+		return applyLayer(f, d, dir)
+	}
+	f, err := downloadVerified("", d.Digest, fetch)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	return applyLayer(f, d, dir)
+}
+
+// applyLayer extracts the tar layer, or writes the non-tar layer with the title.
+func applyLayer(r io.Reader, d ociDescriptor, dir string) error {
+	title := d.Annotations[annotationTitle]
+	switch {
+	case strings.Contains(d.MediaType, ".tar"), d.Annotations[annotationUnpack] == "true":
+		if err := extractArchive(r, dir, extractOptions{whiteouts: true}); err != nil {
+			return errors.Wrapf(err, "failed to apply layer %s", d.Digest)
+		}
+	case title != "":
+		if err := writeFile(r, dir, title); err != nil {
+			return errors.Wrapf(err, "failed to write layer %s", d.Digest)
+		}
+	default:
+		return errors.Errorf("unsupported layer media type %q (%s)", d.MediaType, d.Digest)
+	}
+	return nil
+}
+
+// pullOCI pulls the image and applies the layers to dir in order.
+func pullOCI(ctx context.Context, o ociPullOptions, dir string) error {
+	ref, err := parseOCIReference(o.ref)
+	if err != nil {
+		return err
+	}
+	reference := ref.tag
+	if ref.digest != "" {
+		reference = ref.digest
+	}
+	if o.digest != "" {
+		if !strings.HasPrefix(o.digest, "sha256:") {
+			return errors.Errorf("unsupported digest: %q", o.digest)
+		}
+		if ref.digest != "" && ref.digest != o.digest {
+			return errors.Errorf("digest mismatch: %s is pinned to %s", o.ref, o.digest)
+		}
+		reference = o.digest
+	}
+	c := &registryClient{
+		client:     http.DefaultClient,
+		baseURL:    "https://" + ref.host,
+		repository: ref.repository,
+	}
+	if o.plainHTTP {
+		c.baseURL = "http://" + ref.host
+	}
+	if o.cacheDir != "" {
+		c.cache = &contextCache{dir: o.cacheDir}
+	}
+	if c.username, c.password, err = registryCredentials(o.dockerConfig, ref.host); err != nil {
+		return err
+	}
+	m, err := c.fetchManifest(ctx, reference, o.platform)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, d := range m.Layers {
+		logrus.Debugf("fetching layer %s (%s, %d bytes)", d.Digest, d.MediaType, d.Size)
+		if err := c.fetchLayer(ctx, d, dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func digestOf(b []byte) string {
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func tarGz(t *testing.T, entries ...*tar.Header) []byte {
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for _, hdr := range entries {
+		var content []byte
+		if hdr.Typeflag == tar.TypeReg {
+			content = []byte(hdr.Name)
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// testRegistry is a registry stand-in that requires the bearer token.
+type testRegistry struct {
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	token     string
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		if u, p, ok := req.BasicAuth(); !ok || u != "user" || p != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+		return
+	}
+	if req.Header.Get("Authorization") != "Bearer "+r.token {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="http://`+req.Host+`/token",service="test"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	const prefix = "/v2/foo/bundle/"
+	if !strings.HasPrefix(req.URL.Path, prefix) {
+		http.NotFound(w, req)
+		return
+	}
+	p := strings.TrimPrefix(req.URL.Path, prefix)
+	switch {
+	case strings.HasPrefix(p, "manifests/"):
+		b, ok := r.manifests[strings.TrimPrefix(p, "manifests/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		var m ociManifest
+		json.Unmarshal(b, &m)
+		w.Header().Set("Content-Type", m.MediaType)
+		w.Write(b)
+	case strings.HasPrefix(p, "blobs/"):
+		b, ok := r.blobs[strings.TrimPrefix(p, "blobs/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write(b)
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func readTree(t *testing.T, dir string) map[string]string {
+	tree := make(map[string]string)
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, p)
+		switch {
+		case fi.IsDir():
+			tree[rel] = "<dir>"
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, _ := os.Readlink(p)
+			tree[rel] = "-> " + link
+		default:
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				return err
+			}
+			tree[rel] = string(b)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+func TestPullOCI(t *testing.T) {
+	layer1 := tarGz(t,
+		&tar.Header{Name: "src/", Typeflag: tar.TypeDir, Mode: 0755},
+		&tar.Header{Name: "src/a", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "src/b", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "old/c", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "escape", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+	)
+	layer2 := tarGz(t,
+		&tar.Header{Name: "src/.wh.b", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "old/.wh..wh..opq", Typeflag: tar.TypeReg, Mode: 0644},
+		&tar.Header{Name: "escape/passwd", Typeflag: tar.TypeReg, Mode: 0644},
+	)
+	file := []byte("FROM busybox\n")
+	manifest, _ := json.Marshal(ociManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers: []ociDescriptor{
+			{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digestOf(layer1), Size: int64(len(layer1))},
+			{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digestOf(layer2), Size: int64(len(layer2))},
+			{MediaType: "application/vnd.example.file", Digest: digestOf(file), Size: int64(len(file)),
+				Annotations: map[string]string{annotationTitle: "Dockerfile"}},
+		},
+	})
+	index, _ := json.Marshal(map[string]interface{}{
+		"mediaType": mediaTypeOCIIndex,
+		"manifests": []map[string]interface{}{
+			{"mediaType": mediaTypeOCIManifest, "digest": digestOf(manifest), "size": len(manifest)},
+		},
+	})
+	reg := &testRegistry{
+		blobs: map[string][]byte{
+			digestOf(layer1): layer1,
+			digestOf(layer2): layer2,
+			digestOf(file):   file,
+		},
+		manifests: map[string][]byte{
+			"v1":               index,
+			digestOf(index):    index,
+			digestOf(manifest): manifest,
+		},
+		token: "deadbeef",
+	}
+	ts := httptest.NewServer(reg)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	tmp, err := ioutil.TempDir("", "populateoci-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dockerConfig := filepath.Join(tmp, "config.json")
+	if err := ioutil.WriteFile(dockerConfig, []byte(`{"auths":{"`+host+`":{"auth":"dXNlcjpwYXNz"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cacheDir := filepath.Join(tmp, "cache")
+
+	testCases := []struct {
+		opts        ociPullOptions
+		expectedErr bool
+	}{
+		{
+			opts: ociPullOptions{ref: host + "/foo/bundle:v1", dockerConfig: dockerConfig},
+		},
+		{
+			opts: ociPullOptions{ref: host + "/foo/bundle:v1", dockerConfig: dockerConfig, cacheDir: cacheDir},
+		},
+		{
+			// cached
+			opts: ociPullOptions{ref: host + "/foo/bundle:v1", dockerConfig: dockerConfig, cacheDir: cacheDir},
+		},
+		{
+			opts: ociPullOptions{ref: host + "/foo/bundle:v1", digest: digestOf(index), dockerConfig: dockerConfig},
+		},
+		{
+			opts: ociPullOptions{ref: host + "/foo/bundle@" + digestOf(manifest), dockerConfig: dockerConfig},
+		},
+		{
+			// no credentials
+			opts:        ociPullOptions{ref: host + "/foo/bundle:v1"},
+			expectedErr: true,
+		},
+		{
+			// digest mismatch
+			opts:        ociPullOptions{ref: host + "/foo/bundle:v1", digest: digestOf([]byte("foo")), dockerConfig: dockerConfig},
+			expectedErr: true,
+		},
+	}
+	expected := map[string]string{
+		"Dockerfile": "FROM busybox\n",
+		"escape":     "-> /etc",
+		"old":        "<dir>",
+		"src":        "<dir>",
+		"src/a":      "src/a",
+		"etc":        "<dir>",
+		"etc/passwd": "escape/passwd",
+	}
+	for i, tc := range testCases {
+		dir := filepath.Join(tmp, "context", strconv.Itoa(i))
+		tc.opts.plainHTTP = true
+		err := pullOCI(context.Background(), tc.opts, dir)
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("#%d: error is expected", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: unexpected error: %v", i, err)
+			continue
+		}
+		if tree := readTree(t, dir); !reflect.DeepEqual(expected, tree) {
+			t.Errorf("#%d: expected %v, got %v", i, expected, tree)
+		}
+	}
+}
+
+func TestPullOCITamperedLayer(t *testing.T) {
+	layer := tarGz(t, &tar.Header{Name: "Dockerfile", Typeflag: tar.TypeReg, Mode: 0644})
+	tampered := tarGz(t, &tar.Header{Name: "evil", Typeflag: tar.TypeReg, Mode: 0644})
+	manifest, _ := json.Marshal(ociManifest{
+		MediaType: mediaTypeOCIManifest,
+		Layers: []ociDescriptor{
+			{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digestOf(layer), Size: int64(len(layer))},
+		},
+	})
+	reg := &testRegistry{
+		blobs: map[string][]byte{
+			digestOf(layer): tampered,
+		},
+		manifests: map[string][]byte{
+			"v1": manifest,
+		},
+		token: "deadbeef",
+	}
+	ts := httptest.NewServer(reg)
+	defer ts.Close()
+	host := strings.TrimPrefix(ts.URL, "http://")
+
+	tmp, err := ioutil.TempDir("", "populateoci-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dockerConfig := filepath.Join(tmp, "config.json")
+	if err := ioutil.WriteFile(dockerConfig, []byte(`{"auths":{"`+host+`":{"auth":"dXNlcjpwYXNz"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	for i, cacheDir := range []string{"", filepath.Join(tmp, "cache")} {
+		dir := filepath.Join(tmp, "context", strconv.Itoa(i))
+		opts := ociPullOptions{ref: host + "/foo/bundle:v1", dockerConfig: dockerConfig, cacheDir: cacheDir, plainHTTP: true}
+		if err := pullOCI(context.Background(), opts, dir); err == nil {
+			t.Fatalf("#%d: digest mismatch is expected", i)
+		}
+		// the tampered layer must not be extracted before the verification
+		if _, err := os.Stat(filepath.Join(dir, "evil")); !os.IsNotExist(err) {
+			t.Fatalf("#%d: the tampered layer is extracted: %v", i, err)
+		}
+	}
+}
+
+func TestParseOCIReference(t *testing.T) {
+	testCases := []struct {
+		s        string
+		expected ociReference
+	}{
+		{"alpine", ociReference{host: "registry-1.docker.io", repository: "library/alpine", tag: "latest"}},
+		{"foo/bar:v1", ociReference{host: "registry-1.docker.io", repository: "foo/bar", tag: "v1"}},
+		{"localhost:5000/foo@sha256:abc", ociReference{host: "localhost:5000", repository: "foo", digest: "sha256:abc"}},
+		{"example.com/foo/bar:v1@sha256:abc", ociReference{host: "example.com", repository: "foo/bar", tag: "v1", digest: "sha256:abc"}},
+	}
+	for _, tc := range testCases {
+		ref, err := parseOCIReference(tc.s)
+		if err != nil {
+			t.Errorf("%s: %v", tc.s, err)
+			continue
+		}
+		if ref != tc.expected {
+			t.Errorf("%s: expected %+v, got %+v", tc.s, tc.expected, ref)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/containerbuilding/cbi/pkg/s3"
+)
+
+var populateS3Command = &cli.Command{
+	Name:      "populate-s3",
+	Usage:     "populate a tar archive or the objects with a prefix from S3-compatible storage",
+	ArgsUsage: "[flags] DIRECTORY",
+	Description: `Populate the archive (--key) or the objects with the prefix (--prefix).
+
+The credentials are read from $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY, and $AWS_SESSION_TOKEN.
+Anonymous requests are sent when $AWS_ACCESS_KEY_ID is empty.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "endpoint",
+			Usage: "URL of the storage",
+			Value: "https://s3.amazonaws.com",
+		},
+		&cli.StringFlag{
+			Name:  "region",
+			Usage: "Region",
+			Value: s3.DefaultRegion,
+		},
+		&cli.StringFlag{
+			Name:  "bucket",
+			Usage: "Bucket",
+		},
+		&cli.StringFlag{
+			Name:  "key",
+			Usage: "Key of the archive (tar, tar.gz, tar.bz2, tar.zst, or zip)",
+		},
+		&cli.StringFlag{
+			Name:  "prefix",
+			Usage: "Prefix of the objects to be populated, e.g. foo/",
+		},
+		&cli.BoolFlag{
+			Name:  "path-style",
+			Usage: "Use the path-style requests (ENDPOINT/BUCKET/KEY) rather than the virtual-hosted-style requests (BUCKET.ENDPOINT/KEY)",
+		},
+	},
+	Action: populateS3Action,
+}
+
+type s3PopulateOptions struct {
+	bucket string
+	key    string
+	prefix string
+}
+
+func populateS3Action(clicontext *cli.Context) error {
+	dir := clicontext.Args().Get(0)
+	if dir == "" {
+		return errors.New("DIRECTORY missing")
+	}
+	client, err := s3.New(s3.Config{
+		Endpoint:           clicontext.String("endpoint"),
+		Region:             clicontext.String("region"),
+		VirtualHostedStyle: !clicontext.Bool("path-style"),
+		Credentials:        s3.CredentialsFromEnv(),
+	})
+	if err != nil {
+		return err
+	}
+	return populateS3(context.Background(), client, s3PopulateOptions{
+		bucket: clicontext.String("bucket"),
+		key:    clicontext.String("key"),
+		prefix: clicontext.String("prefix"),
+	}, dir)
+}
+
+func populateS3(ctx context.Context, client *s3.Client, o s3PopulateOptions, dir string) error {
+	if o.bucket == "" {
+		return errors.New("bucket missing")
+	}
+	if (o.key == "") == (o.prefix == "") {
+		return errors.New("either key or prefix needs to be specified")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if o.key != "" {
+		r, err := client.GetObject(ctx, o.bucket, o.key)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get %s", o.key)
+		}
+		defer r.Close()
+		return extractArchive(r, dir, extractOptions{})
+	}
+	objects, err := client.ListObjects(ctx, o.bucket, o.prefix)
+	if err != nil {
+		return errors.Wrapf(err, "failed to list %s", o.prefix)
+	}
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, o.prefix)
+		// directory markers created by the consoles
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			continue
+		}
+		logrus.Debugf("fetching %s (%d bytes)", obj.Key, obj.Size)
+		if err := populateS3Object(ctx, client, o.bucket, obj.Key, dir, rel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func populateS3Object(ctx context.Context, client *s3.Client, bucket, key, dir, rel string) error {
+	r, err := client.GetObject(ctx, bucket, key)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get %s", key)
+	}
+	defer r.Close()
+	return writeFile(r, dir, rel)
+}
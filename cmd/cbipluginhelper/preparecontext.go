@@ -0,0 +1,197 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/moby/patternmatcher"
+	"github.com/moby/patternmatcher/ignorefile"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var prepareContextCommand = &cli.Command{
+	Name:      "prepare-context",
+	Usage:     "apply .dockerignore to the context directory and check the size",
+	ArgsUsage: "[flags] CONTEXT-DIRECTORY",
+	Description: `Remove the files excluded by .dockerignore in the context directory, so that
+the backends that do not support .dockerignore do not receive them.
+Dockerfile and .dockerignore are never removed.
+
+Fails when the remaining files exceed --max-bytes or --max-files.`,
+	Flags: []cli.Flag{
+		&cli.Int64Flag{
+			Name:  "max-bytes",
+			Usage: "Maximum total size of the files in the context (0 for unlimited)",
+		},
+		&cli.IntFlag{
+			Name:  "max-files",
+			Usage: "Maximum number of the files in the context (0 for unlimited)",
+		},
+	},
+	Action: func(clicontext *cli.Context) error {
+		dir := clicontext.Args().First()
+		if dir == "" {
+			return errors.New("CONTEXT-DIRECTORY missing")
+		}
+		limits := contextLimits{
+			maxBytes: clicontext.Int64("max-bytes"),
+			maxFiles: clicontext.Int("max-files"),
+		}
+		size, files, err := prepareContext(dir, limits)
+		if err != nil {
+			return err
+		}
+		logrus.Infof("context: %d files, %d bytes", files, size)
+		return nil
+	},
+}
+
+type contextLimits struct {
+	// zero means unlimited
+	maxBytes int64
+	maxFiles int
+}
+
+// contextKeptFiles are never removed, as in `docker build`.
+var contextKeptFiles = map[string]struct{}{
+	"Dockerfile":    {},
+	".dockerignore": {},
+}
+
+// prepareContext removes the files excluded by .dockerignore in dir, and
+// returns the total size and the number of the remaining files.
+func prepareContext(dir string, limits contextLimits) (int64, int, error) {
+	var patterns []string
+	f, err := os.Open(filepath.Join(dir, ".dockerignore"))
+	if err == nil {
+		patterns, err = ignorefile.ReadAll(f)
+		f.Close()
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "failed to read .dockerignore")
+		}
+	} else if !os.IsNotExist(err) {
+		return 0, 0, err
+	}
+	pm, err := patternmatcher.New(patterns)
+	if err != nil {
+		return 0, 0, errors.Wrap(err, "invalid .dockerignore")
+	}
+	p := &contextPreparer{dir: dir, pm: pm, limits: limits}
+	if err := p.walk("", patternmatcher.MatchInfo{}); err != nil {
+		return 0, 0, err
+	}
+	return p.size, p.files, nil
+}
+
+type contextPreparer struct {
+	dir    string
+	pm     *patternmatcher.PatternMatcher
+	limits contextLimits
+	size   int64
+	files  int
+}
+
+// walk walks the directory rel (slash-separated, relative to p.dir).
+// parent is the match result of rel.
+func (p *contextPreparer) walk(rel string, parent patternmatcher.MatchInfo) error {
+	d, err := os.Open(filepath.Join(p.dir, filepath.FromSlash(rel)))
+	if err != nil {
+		return err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := path.Join(rel, name)
+		full := filepath.Join(p.dir, filepath.FromSlash(r))
+		fi, err := os.Lstat(full)
+		if err != nil {
+			return err
+		}
+		excluded, info, err := p.pm.MatchesUsingParentResults(r, parent)
+		if err != nil {
+			return err
+		}
+		if _, ok := contextKeptFiles[r]; ok {
+			excluded = false
+		}
+		if fi.IsDir() {
+			if excluded && !p.pm.Exclusions() {
+				logrus.Debugf("removing %s/", r)
+				if err := os.RemoveAll(full); err != nil {
+					return err
+				}
+				continue
+			}
+			// the files in the excluded directory may be included by "!" patterns
+			if err := p.walk(r, info); err != nil {
+				return err
+			}
+			if excluded {
+				if err := removeIfEmpty(full); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if excluded {
+			logrus.Debugf("removing %s", r)
+			if err := os.Remove(full); err != nil {
+				return err
+			}
+			continue
+		}
+		p.files++
+		if fi.Mode().IsRegular() {
+			p.size += fi.Size()
+		}
+		if p.limits.maxFiles > 0 && p.files > p.limits.maxFiles {
+			return errors.Errorf("context exceeds the limit of %d files", p.limits.maxFiles)
+		}
+		if p.limits.maxBytes > 0 && p.size > p.limits.maxBytes {
+			return errors.Errorf("context exceeds the limit of %d bytes", p.limits.maxBytes)
+		}
+	}
+	return nil
+}
+
+// removeIfEmpty removes the directory if it is empty.
+func removeIfEmpty(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	names, err := d.Readdirnames(1)
+	d.Close()
+	if len(names) > 0 {
+		return nil
+	}
+	if err != nil && err != io.EOF {
+		return err
+	}
+	return os.Remove(dir)
+}
@@ -0,0 +1,142 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPrepareContext(t *testing.T) {
+	files := map[string]string{
+		".dockerignore":           "# comment\n.git\nnode_modules\n*.log\n!important.log\nDockerfile\ndocs/**\n!docs/keep/**\n",
+		"Dockerfile":              "FROM scratch",
+		".git/HEAD":               "ref",
+		"node_modules/foo/a.js":   "a",
+		"src/main.go":             "main",
+		"src/debug.log":           "x",
+		"debug.log":               "x",
+		"important.log":           "important",
+		"docs/a.md":               "a",
+		"docs/keep/b.md":          "b",
+		"src/node_modules/b.js":   "b",
+		"src/sub/.git/config.txt": "c",
+	}
+	testCases := []struct {
+		limits        contextLimits
+		expected      map[string]string
+		expectedSize  int64
+		expectedFiles int
+		expectedErr   bool
+	}{
+		{
+			expected: map[string]string{
+				".dockerignore":           files[".dockerignore"],
+				"Dockerfile":              "FROM scratch",
+				"src":                     "<dir>",
+				"src/main.go":             "main",
+				"src/debug.log":           "x",
+				"important.log":           "important",
+				"docs":                    "<dir>",
+				"docs/keep":               "<dir>",
+				"docs/keep/b.md":          "b",
+				"src/node_modules":        "<dir>",
+				"src/node_modules/b.js":   "b",
+				"src/sub":                 "<dir>",
+				"src/sub/.git":            "<dir>",
+				"src/sub/.git/config.txt": "c",
+			},
+			expectedFiles: 8,
+			expectedSize:  int64(len(files[".dockerignore"]) + len("FROM scratch") + len("main") + len("x") + len("important") + len("b") + len("b") + len("c")),
+		},
+		{
+			limits:        contextLimits{maxFiles: 8, maxBytes: 1024},
+			expectedFiles: 8,
+		},
+		{
+			limits:      contextLimits{maxFiles: 7},
+			expectedErr: true,
+		},
+		{
+			limits:      contextLimits{maxBytes: 100},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		tmp, err := ioutil.TempDir("", "preparecontext-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tmp)
+		for name, content := range files {
+			p := filepath.Join(tmp, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		size, n, err := prepareContext(tmp, tc.limits)
+		if tc.expectedErr {
+			if err == nil {
+				t.Fatalf("#%d: error is expected", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		if n != tc.expectedFiles {
+			t.Fatalf("#%d: expected %d files, got %d", i, tc.expectedFiles, n)
+		}
+		if tc.expectedSize != 0 && size != tc.expectedSize {
+			t.Fatalf("#%d: expected %d bytes, got %d", i, tc.expectedSize, size)
+		}
+		if tc.expected != nil {
+			tree := readTree(t, tmp)
+			expected := make(map[string]string)
+			for k, v := range tc.expected {
+				expected[filepath.FromSlash(k)] = v
+			}
+			if !reflect.DeepEqual(expected, tree) {
+				t.Fatalf("#%d: expected %v, got %v", i, expected, tree)
+			}
+		}
+	}
+}
+
+func TestPrepareContextWithoutDockerignore(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "preparecontext-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "Dockerfile"), []byte("FROM scratch"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	size, n, err := prepareContext(tmp, contextLimits{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 || size != int64(len("FROM scratch")) {
+		t.Fatalf("unexpected result: %d files, %d bytes", n, size)
+	}
+}
@@ -0,0 +1,124 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"net"
+	"os"
+	"os/exec"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"gopkg.in/urfave/cli.v2"
+)
+
+var sshAgentCommand = &cli.Command{
+	Name:      "ssh-agent",
+	Usage:     "run the command with the SSH agent serving the private keys",
+	ArgsUsage: "[flags] -- COMMAND [ARGS...]",
+	Description: `Serve the private keys in --keys-dir on the SSH agent socket, and run the
+command with $SSH_AUTH_SOCK.
+The files that are not private keys (e.g. known_hosts) are ignored.
+Passphrase-protected keys are not supported.`,
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "keys-dir",
+			Usage: "Directory containing the private keys",
+		},
+		&cli.StringFlag{
+			Name:  "socket",
+			Usage: "Path of the agent socket",
+		},
+	},
+	Action: sshAgentAction,
+}
+
+func sshAgentAction(clicontext *cli.Context) error {
+	args := clicontext.Args().Slice()
+	if len(args) == 0 {
+		return errors.New("command missing")
+	}
+	keysDir, socket := clicontext.String("keys-dir"), clicontext.String("socket")
+	if keysDir == "" || socket == "" {
+		return errors.New("keys-dir and socket need to be specified")
+	}
+	keyring, err := loadSSHKeys(keysDir)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+	if err := os.Chmod(socket, 0600); err != nil {
+		return err
+	}
+	go serveSSHAgent(l, keyring)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = append(os.Environ(), "SSH_AUTH_SOCK="+socket)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	logrus.Debugf("running %q (%v) with SSH_AUTH_SOCK=%s", args[0], args[1:], socket)
+	return cmd.Run()
+}
+
+// loadSSHKeys loads the private keys in dir into a new keyring.
+func loadSSHKeys(dir string) (agent.Agent, error) {
+	files, err := readTrustedKeys(dir)
+	if err != nil {
+		return nil, err
+	}
+	keyring := agent.NewKeyring()
+	n := 0
+	for _, b := range files {
+		key, err := ssh.ParseRawPrivateKey(b)
+		if err != nil {
+			logrus.Debugf("ignoring a file that is not a private key: %v", err)
+			continue
+		}
+		if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			return nil, err
+		}
+		n++
+	}
+	if n == 0 {
+		return nil, errors.Errorf("no private key found in %s", dir)
+	}
+	return keyring, nil
+}
+
+func serveSSHAgent(l net.Listener, keyring agent.Agent) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			logrus.Debugf("ssh agent: %v", err)
+			return
+		}
+		go func() {
+			defer conn.Close()
+			if err := agent.ServeAgent(keyring, conn); err != nil {
+				logrus.Debugf("ssh agent: %v", err)
+			}
+		}()
+	}
+}
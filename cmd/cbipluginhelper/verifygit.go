@@ -0,0 +1,157 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// gitVerifyOptions is the signature verification of the checked-out revision.
+type gitVerifyOptions struct {
+	// keysDir contains the trusted public keys
+	keysDir string
+	// format is "gpg" or "ssh"
+	format string
+	// tag verifies the annotated tag rather than the commit
+	tag bool
+}
+
+// verifyGit verifies the signature of the commit (or the tag) with the trusted keys.
+// gitOpts are the global options of git, ref is the tag to be verified.
+func verifyGit(ctx context.Context, dir string, gitOpts []string, ref string, o gitVerifyOptions) error {
+	keys, err := readTrustedKeys(o.keysDir)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return errors.Errorf("no trusted key found in %s", o.keysDir)
+	}
+	tmp, err := ioutil.TempDir("", "cbi-gitverify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	env := os.Environ()
+	switch o.format {
+	case "", "gpg":
+		gnupgHome := filepath.Join(tmp, "gnupg")
+		if err := os.Mkdir(gnupgHome, 0700); err != nil {
+			return err
+		}
+		env = append(env, "GNUPGHOME="+gnupgHome)
+		for _, key := range keys {
+			cmd := exec.CommandContext(ctx, "gpg", "--batch", "--quiet", "--import")
+			cmd.Env = env
+			cmd.Stdin = bytes.NewReader(key)
+			if out, err := cmd.CombinedOutput(); err != nil {
+				return errors.Wrapf(err, "failed to import the GPG key: %s", strings.TrimSpace(string(out)))
+			}
+		}
+	case "ssh":
+		allowedSigners := filepath.Join(tmp, "allowed_signers")
+		if err := ioutil.WriteFile(allowedSigners, sshAllowedSigners(keys), 0600); err != nil {
+			return err
+		}
+		gitOpts = append(gitOpts, "-c", "gpg.ssh.allowedSignersFile="+allowedSigners)
+	default:
+		return errors.Errorf("unknown verification format: %q", o.format)
+	}
+	args := append([]string{"-C", dir}, gitOpts...)
+	what := "commit HEAD"
+	if o.tag {
+		args = append(args, "verify-tag", ref)
+		what = "tag " + ref
+	} else {
+		args = append(args, "verify-commit", "HEAD")
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = env
+	logrus.Debugf("running %q (%v)", "git", args)
+	out, err := cmd.CombinedOutput()
+	logrus.Debugf("git: %s", string(out))
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if msg == "" {
+			msg = err.Error()
+		}
+		return errors.Errorf("signature verification of %s failed: %s", what, msg)
+	}
+	return nil
+}
+
+// readTrustedKeys reads the files in the secret or configmap directory.
+// Hidden files (e.g. "..data") are ignored.
+func readTrustedKeys(dir string) ([][]byte, error) {
+	names, err := filepath.Glob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, err
+	}
+	var keys [][]byte
+	for _, name := range names {
+		if strings.HasPrefix(filepath.Base(name), ".") {
+			continue
+		}
+		st, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if !st.Mode().IsRegular() {
+			continue
+		}
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, b)
+	}
+	return keys, nil
+}
+
+// sshAllowedSigners converts the keys to the allowed signers format of ssh-keygen(1).
+// The lines already in the format are kept as-is, and the plain public keys
+// are allowed for any principal.
+func sshAllowedSigners(keys [][]byte) []byte {
+	var b bytes.Buffer
+	for _, key := range keys {
+		sc := bufio.NewScanner(bytes.NewReader(key))
+		for sc.Scan() {
+			line := strings.TrimSpace(sc.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			if isSSHKeyType(strings.Fields(line)[0]) {
+				line = "* " + line
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.Bytes()
+}
+
+func isSSHKeyType(s string) bool {
+	return strings.HasPrefix(s, "ssh-") || strings.HasPrefix(s, "ecdsa-") || strings.HasPrefix(s, "sk-")
+}
@@ -0,0 +1,170 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestVerifyGit(t *testing.T) {
+	for _, tool := range []string{"git", "ssh-keygen", "gpg"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+	tmp, err := ioutil.TempDir("", "verifygit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	command := func(env []string, name string, args ...string) string {
+		cmd := exec.Command(name, args...)
+		cmd.Env = append(os.Environ(), env...)
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s %v: %v: %s", name, args, err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	mkdir := func(name string) string {
+		dir := filepath.Join(tmp, name)
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+	keysDir := func(name string, keys ...string) string {
+		dir := mkdir(name)
+		for i, key := range keys {
+			if err := ioutil.WriteFile(filepath.Join(dir, "key"+strconv.Itoa(i)), []byte(key), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return dir
+	}
+
+	// SSH keys
+	sshKey := filepath.Join(mkdir("ssh"), "id_ed25519")
+	command(nil, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", sshKey)
+	sshPub, err := ioutil.ReadFile(sshKey + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	untrustedSSHKey := filepath.Join(mkdir("ssh-untrusted"), "id_ed25519")
+	command(nil, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", untrustedSSHKey)
+	untrustedSSHPub, err := ioutil.ReadFile(untrustedSSHKey + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// GPG key
+	gnupgEnv := []string{"GNUPGHOME=" + mkdir("gnupg")}
+	command(gnupgEnv, "gpg", "--batch", "--passphrase", "", "--quick-gen-key", "test <test@example.com>", "ed25519", "sign", "never")
+	gpgPub := command(gnupgEnv, "gpg", "--armor", "--export", "test@example.com")
+
+	repo := mkdir("repo")
+	git := func(args ...string) string {
+		args = append([]string{"-C", repo, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		return command(gnupgEnv, "git", args...)
+	}
+	sshSign := func(key string) []string {
+		return []string{"-c", "gpg.format=ssh", "-c", "user.signingkey=" + key}
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "unsigned")
+	git("tag", "unsigned")
+	git(append(sshSign(sshKey), "commit", "-q", "-S", "--allow-empty", "-m", "ssh")...)
+	git(append(sshSign(sshKey), "tag", "-s", "-m", "ssh", "ssh")...)
+	git(append(sshSign(untrustedSSHKey), "commit", "-q", "-S", "--allow-empty", "-m", "ssh-untrusted")...)
+	git("tag", "ssh-untrusted")
+	git("commit", "-q", "-S", "--allow-empty", "-m", "gpg")
+	git("tag", "gpg")
+
+	sshKeys := keysDir("keys-ssh", string(sshPub), "# allowed signers format\ntest@example.com "+string(sshPub))
+	untrustedSSHKeys := keysDir("keys-ssh-untrusted", string(untrustedSSHPub))
+	gpgKeys := keysDir("keys-gpg", gpgPub)
+	testCases := []struct {
+		revision    string
+		verify      gitVerifyOptions
+		expectedErr bool
+	}{
+		{
+			revision: "ssh",
+			verify:   gitVerifyOptions{keysDir: sshKeys, format: "ssh"},
+		},
+		{
+			revision: "ssh",
+			verify:   gitVerifyOptions{keysDir: sshKeys, format: "ssh", tag: true},
+		},
+		{
+			revision:    "ssh",
+			verify:      gitVerifyOptions{keysDir: untrustedSSHKeys, format: "ssh"},
+			expectedErr: true,
+		},
+		{
+			revision: "ssh-untrusted",
+			verify:   gitVerifyOptions{keysDir: untrustedSSHKeys, format: "ssh"},
+		},
+		{
+			revision:    "ssh-untrusted",
+			verify:      gitVerifyOptions{keysDir: sshKeys, format: "ssh"},
+			expectedErr: true,
+		},
+		{
+			revision:    "unsigned",
+			verify:      gitVerifyOptions{keysDir: sshKeys, format: "ssh"},
+			expectedErr: true,
+		},
+		{
+			revision: "gpg",
+			verify:   gitVerifyOptions{keysDir: gpgKeys, format: "gpg"},
+		},
+		{
+			revision:    "ssh",
+			verify:      gitVerifyOptions{keysDir: gpgKeys, format: "gpg"},
+			expectedErr: true,
+		},
+		{
+			// lightweight tag cannot be verified
+			revision:    "gpg",
+			verify:      gitVerifyOptions{keysDir: gpgKeys, format: "gpg", tag: true},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		dir := filepath.Join(tmp, "context", strconv.Itoa(i))
+		err := populateGit(context.TODO(), "file://"+repo, dir, gitPopulateOptions{revision: tc.revision, verify: tc.verify})
+		if tc.expectedErr {
+			if err == nil {
+				t.Errorf("#%d: error expected", i)
+			} else if !strings.Contains(err.Error(), "signature verification") {
+				t.Errorf("#%d: unexpected error: %v", i, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+}
@@ -27,22 +27,91 @@ if [ -z "${DBP_DIALECT}" ]; then
     exit 1
 fi
 
+# DBP_CACHE_REF (optional) is the image reference for importing and exporting the cache.
+# Not supported by img, as "img build" has no --cache-from flag.
+cache_flags=""
+if [ -n "${DBP_CACHE_REF}" ]; then
+    case ${DBP_DIALECT} in
+        docker )
+            # the cache may not exist yet, but the other errors (e.g. authentication) are fatal
+            if pull_out=$(${DBP_DOCKER_BINARY} pull ${DBP_CACHE_REF} 2>&1); then
+                echo "${pull_out}"
+            else
+                echo "${pull_out}"
+                case "${pull_out}" in
+                    *"not found"* | *"manifest unknown"* ) ;;
+                    * ) exit 1 ;;
+                esac
+            fi
+            cache_flags="--cache-from ${DBP_CACHE_REF}" ;;
+        buildah )
+            cache_flags="--layers --cache-from ${DBP_CACHE_REF} --cache-to ${DBP_CACHE_REF}" ;;
+    esac
+fi
+
+# DBP_PLATFORMS (optional) is the comma-separated list of the platforms, only for buildah dialect.
+# The images are added to the manifest list named DBP_IMAGE_NAME, which is recreated on every build.
+if [ -n "${DBP_PLATFORMS}" ] && [ "${DBP_DIALECT}" != buildah ]; then
+    echo "DBP_PLATFORMS is not supported for dialect: ${DBP_DIALECT}"
+    exit 1
+fi
+
 case ${DBP_DIALECT} in
     docker )
-        ${DBP_DOCKER_BINARY} build -t ${DBP_IMAGE_NAME} $@ ;;
+        ${DBP_DOCKER_BINARY} build -t ${DBP_IMAGE_NAME} ${cache_flags} $@ ;;
     buildah )
-        ${DBP_DOCKER_BINARY} bud -t ${DBP_IMAGE_NAME} $@ ;;
+        if [ -n "${DBP_PLATFORMS}" ]; then
+            # "bud --manifest" appends to the existing manifest list, which may be left
+            # in the persistent storage by the previous build
+            if ! rm_out=$(${DBP_DOCKER_BINARY} manifest rm ${DBP_IMAGE_NAME} 2>&1); then
+                case "${rm_out}" in
+                    *"not known"* | *"not found"* | *"unknown"* ) ;;
+                    * )
+                        echo "${rm_out}"
+                        exit 1 ;;
+                esac
+            fi
+            ${DBP_DOCKER_BINARY} bud --platform ${DBP_PLATFORMS} --manifest ${DBP_IMAGE_NAME} ${cache_flags} $@
+        else
+            ${DBP_DOCKER_BINARY} bud -t ${DBP_IMAGE_NAME} ${cache_flags} $@
+        fi ;;
     *)
         echo "Unsupported dialect: ${DBP_DIALECT}"
         exit 1
 esac
 
+if [ -n "${DBP_CACHE_REF}" ] && [ "${DBP_DIALECT}" = docker ]; then
+    ${DBP_DOCKER_BINARY} tag ${DBP_IMAGE_NAME} ${DBP_CACHE_REF}
+    ${DBP_DOCKER_BINARY} push ${DBP_CACHE_REF}
+fi
+
 if [ "${DBP_PUSH}" = 1 ]; then
     case ${DBP_DIALECT} in
         docker )
             ${DBP_DOCKER_BINARY} push ${DBP_IMAGE_NAME} ;;
         buildah )
-            ${DBP_DOCKER_BINARY} push ${DBP_IMAGE_NAME} docker://${DBP_IMAGE_NAME} ;;
+            if [ -n "${DBP_PLATFORMS}" ]; then
+                ${DBP_DOCKER_BINARY} manifest push --all ${DBP_IMAGE_NAME} docker://${DBP_IMAGE_NAME}
+            else
+                ${DBP_DOCKER_BINARY} push ${DBP_IMAGE_NAME} docker://${DBP_IMAGE_NAME}
+            fi ;;
+        *)
+            echo "Unsupported dialect: ${DBP_DIALECT}"
+            exit 1
+    esac
+fi
+
+# DBP_OUTPUT_FILE (optional) is the path of the tarball of the image.
+if [ -n "${DBP_OUTPUT_FILE}" ]; then
+    case ${DBP_DIALECT} in
+        docker )
+            ${DBP_DOCKER_BINARY} save -o ${DBP_OUTPUT_FILE} ${DBP_IMAGE_NAME} ;;
+        buildah )
+            if [ -n "${DBP_PLATFORMS}" ]; then
+                ${DBP_DOCKER_BINARY} manifest push --all ${DBP_IMAGE_NAME} oci-archive:${DBP_OUTPUT_FILE}
+            else
+                ${DBP_DOCKER_BINARY} push ${DBP_IMAGE_NAME} oci-archive:${DBP_OUTPUT_FILE}
+            fi ;;
         *)
             echo "Unsupported dialect: ${DBP_DIALECT}"
             exit 1
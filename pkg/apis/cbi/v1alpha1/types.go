@@ -45,6 +45,37 @@ type BuildJobSpec struct {
 	Language Language `json:"language"`
 	// Context specifies the context.
 	Context Context `json:"context"`
+	// Contexts specifies the additional named contexts, e.g. for `COPY --from=NAME` in Dockerfile.
+	// When Contexts is not empty, the controller MUST add "context.named" and
+	// the kinds of the contexts to its default plugin selector logic.
+	// +optional
+	Contexts []NamedContext `json:"contexts,omitempty"`
+	// BuildSecrets specifies the secrets exposed to the build steps, without being stored in the image.
+	// e.g. `RUN --mount=type=secret,id=npmrc` in Dockerfile.
+	// When BuildSecrets is not empty, the controller MUST add "feature.buildsecrets"
+	// to its default plugin selector logic.
+	// +optional
+	BuildSecrets []BuildSecret `json:"buildSecrets,omitempty" yaml:"buildSecrets"`
+	// SSH forwards the SSH agent to the build steps.
+	// e.g. `RUN --mount=type=ssh` in Dockerfile.
+	// When SSH.SecretRef is set, the controller MUST add "feature.ssh"
+	// to its default plugin selector logic.
+	// +optional
+	SSH SSH `json:"ssh"`
+	// Cache specifies the remote build cache in the registry.
+	// When Cache.Ref is set, the controller MUST add "feature.buildcache"
+	// to its default plugin selector logic.
+	// +optional
+	Cache BuildCache `json:"cache"`
+	// Platforms specifies the target platforms, e.g. "linux/amd64" and "linux/arm64".
+	// When multiple platforms are specified, the image is pushed as a manifest list.
+	// When Platforms is not empty, the controller MUST add "feature.multiplatform"
+	// to its default plugin selector logic.
+	// +optional
+	Platforms []string `json:"platforms,omitempty"`
+	// Output specifies the output of the image other than the registry.
+	// +optional
+	Output Output `json:"output"`
 	// PluginSelector specifies additional hints for selecting the plugin
 	// using the plugin labels.
 	// e.g. `plugin.name = docker`.
@@ -59,6 +90,11 @@ type BuildJobSpec struct {
 	//
 	// +optional
 	PluginSelector string `json:"pluginSelector" yaml:"pluginSelector"`
+	// Canceled cancels the build.
+	// When Canceled is set to true, the controller deletes the unfinished job
+	// and sets the phase to BuildJobPhaseCanceled.
+	// +optional
+	Canceled bool `json:"canceled"`
 }
 
 // Registry specifies the registry.
@@ -124,6 +160,19 @@ type Context struct {
 	ConfigMapRef corev1.LocalObjectReference `json:"configMapRef" yaml:"configMapRef"`
 	HTTP         HTTP                        `json:"http"`
 	Rclone       Rclone                      `json:"rclone"`
+	Local        Local                       `json:"local"`
+	// +optional
+	BuildKitSession BuildKitSession `json:"buildKitSession" yaml:"buildKitSession"`
+	OCI             OCI             `json:"oci"`
+	S3              S3              `json:"s3"`
+	// Cache is set by the controller before passing the BuildJob to the plugin,
+	// and MUST NOT be set by the client.
+	// +optional
+	Cache ContextCache `json:"cache"`
+	// Limits limits the context after applying .dockerignore.
+	// The controller MAY lower the limits.
+	// +optional
+	Limits ContextLimits `json:"limits"`
 }
 
 const (
@@ -146,8 +195,190 @@ const (
 	// When BuildJob.Context.Kind is set to ContextKindHTTP, the controller
 	// MUST add "context.rclone" to its default plugin selector logic.
 	ContextKindRclone ContextKind = "Rclone"
+
+	// ContextKindLocal stands for a local directory uploaded from the client
+	// to the context server of the controller.
+	// When BuildJob.Context.Kind is set to ContextKindLocal, the controller
+	// MUST add "context.local" to its default plugin selector logic.
+	ContextKindLocal ContextKind = "Local"
+
+	// ContextKindBuildKitSession stands for a local directory streamed from
+	// the client attached to the build pod, using the BuildKit session.
+	// When BuildJob.Context.Kind is set to ContextKindBuildKitSession, the controller
+	// MUST add "context.buildkitsession" to its default plugin selector logic.
+	ContextKindBuildKitSession ContextKind = "BuildKitSession"
+
+	// ContextKindOCI stands for OCI image (or artifact) context.
+	// When BuildJob.Context.Kind is set to ContextKindOCI, the controller
+	// MUST add "context.oci" to its default plugin selector logic.
+	ContextKindOCI ContextKind = "OCI"
+
+	// ContextKindS3 stands for S3-compatible object storage context.
+	// When BuildJob.Context.Kind is set to ContextKindS3, the controller
+	// MUST add "context.s3" to its default plugin selector logic.
+	ContextKindS3 ContextKind = "S3"
+)
+
+type OutputKind string
+
+const (
+	// OutputKindRegistry pushes the image to Registry.Target when Registry.Push is true.
+	// OutputKindRegistry is the default.
+	OutputKindRegistry OutputKind = "Registry"
+
+	// OutputKindOCITarball writes the OCI image layout archive of the image into the PersistentVolumeClaim.
+	// When BuildJob.Output.Kind is set to OutputKindOCITarball, the controller
+	// MUST add "output.ocitarball" to its default plugin selector logic.
+	OutputKindOCITarball OutputKind = "OCITarball"
+
+	// OutputKindDockerTarball writes the `docker save` archive of the image into the PersistentVolumeClaim.
+	// When BuildJob.Output.Kind is set to OutputKindDockerTarball, the controller
+	// MUST add "output.dockertarball" to its default plugin selector logic.
+	OutputKindDockerTarball OutputKind = "DockerTarball"
+
+	// OutputKindHTTPUpload uploads the tarball of the image to the URL.
+	// When BuildJob.Output.Kind is set to OutputKindHTTPUpload, the controller
+	// MUST add "output.httpupload" to its default plugin selector logic.
+	OutputKindHTTPUpload OutputKind = "HTTPUpload"
+)
+
+// Output specifies the output of the image.
+// Registry.Push MUST be false when Kind is not OutputKindRegistry.
+//
+// The tarball of OutputKindHTTPUpload is an OCI image layout archive, or a `docker save`
+// archive depending on the plugin implementation (e.g. docker and kaniko).
+// The format is shown as the "output.format" label of the plugin ("oci" or "docker"),
+// and can be specified in BuildJobSpec.PluginSelector, e.g. "output.format=oci".
+// Registry.Target is used as the image name in the tarball.
+type Output struct {
+	// Kind defaults to OutputKindRegistry.
+	// +optional
+	Kind          OutputKind          `json:"kind"`
+	OCITarball    OCITarballOutput    `json:"ociTarball" yaml:"ociTarball"`
+	DockerTarball DockerTarballOutput `json:"dockerTarball" yaml:"dockerTarball"`
+	HTTPUpload    HTTPUploadOutput    `json:"httpUpload" yaml:"httpUpload"`
+}
+
+// OCITarballOutput
+type OCITarballOutput struct {
+	// ClaimName is the PersistentVolumeClaim in the namespace of the BuildJob.
+	ClaimName string `json:"claimName" yaml:"claimName"`
+	// Path of the tarball within the volume, e.g. "images/foo.tar".
+	// The parent directories are created if missing, and the existing file is overwritten.
+	Path string `json:"path"`
+}
+
+// DockerTarballOutput
+type DockerTarballOutput struct {
+	// ClaimName is the PersistentVolumeClaim in the namespace of the BuildJob.
+	ClaimName string `json:"claimName" yaml:"claimName"`
+	// Path of the tarball within the volume, e.g. "images/foo.tar".
+	// The parent directories are created if missing, and the existing file is overwritten.
+	Path string `json:"path"`
+}
+
+// HTTPUploadOutput
+type HTTPUploadOutput struct {
+	// URL to which the tarball is uploaded with PUT, e.g. a presigned URL of S3.
+	// URL MUST be http:// or https:// .
+	URL string `json:"url"`
+	// CASecretRef contains "ca.crt", the PEM-encoded CA certificates trusted
+	// in addition to the system ones.
+	// +optional
+	CASecretRef corev1.LocalObjectReference `json:"caSecretRef" yaml:"caSecretRef"`
+	// InsecureSkipVerify skips verifying the TLS certificate of the server.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+	// HeadersSecretRef contains the request headers, keyed by the header names.
+	// e.g. {"Authorization": "Bearer deadbeef"}
+	// +optional
+	HeadersSecretRef corev1.LocalObjectReference `json:"headersSecretRef" yaml:"headersSecretRef"`
+}
+
+type BuildCacheMode string
+
+const (
+	// BuildCacheModeMin exports the cache only for the layers of the resulting image.
+	BuildCacheModeMin BuildCacheMode = "min"
+	// BuildCacheModeMax exports the cache for all the intermediate layers as well.
+	BuildCacheModeMax BuildCacheMode = "max"
 )
 
+// BuildCache specifies the remote build cache.
+// The cache is imported before the build, and exported after the build.
+type BuildCache struct {
+	// Ref is the image reference of the cache, e.g. `example.com/foo/bar:buildcache`.
+	// Some plugin implementations (e.g. kaniko) require the repository without the tag.
+	// The buildah plugin strips the tag.
+	Ref string `json:"ref"`
+	// Mode is BuildCacheModeMin or BuildCacheModeMax.
+	// Defaults to the mode that is natively supported by the plugin.
+	// The plugin returns an error when the mode is not supported.
+	// +optional
+	Mode BuildCacheMode `json:"mode"`
+	// SecretRef is the .dockerconfigjson secret used for pushing and pulling the cache.
+	// Defaults to Registry.SecretRef.
+	// When both Registry.SecretRef and SecretRef are set, they MUST be the same secret,
+	// as most plugin implementations can use only a single docker config.
+	// +optional
+	SecretRef corev1.LocalObjectReference `json:"secretRef" yaml:"secretRef"`
+}
+
+// BuildSecret is a key of the secret exposed to the build steps.
+type BuildSecret struct {
+	// ID is the ID of the secret used in the build steps.
+	ID string `json:"id"`
+	// SecretRef is the secret in the namespace of the BuildJob.
+	SecretRef corev1.LocalObjectReference `json:"secretRef" yaml:"secretRef"`
+	// Key in the secret. Defaults to ID.
+	// +optional
+	Key string `json:"key"`
+}
+
+// SSH specifies the private keys served by the SSH agent.
+type SSH struct {
+	// SecretRef is the secret containing the private keys, e.g. "id_rsa".
+	// The keys that are not private keys (e.g. "known_hosts") are ignored,
+	// so the secret for Git.SSHSecretRef can be reused.
+	// Passphrase-protected keys are not supported.
+	// +optional
+	SecretRef corev1.LocalObjectReference `json:"secretRef" yaml:"secretRef"`
+	// ID is the ID of the agent used in the build steps. Defaults to "default".
+	// +optional
+	ID string `json:"id"`
+}
+
+// NamedContext is an additional context with the name.
+type NamedContext struct {
+	// Name consists of lower case alphanumeric characters or '-', up to 32 characters.
+	Name string `json:"name"`
+	// Context MUST NOT be Local or BuildKitSession.
+	Context Context `json:"context"`
+}
+
+// ContextLimits limits the size of the context.
+// The files excluded by .dockerignore are not counted.
+type ContextLimits struct {
+	// MaxBytes is the maximum total size of the files. Zero means no limit.
+	// +optional
+	MaxBytes int64 `json:"maxBytes" yaml:"maxBytes"`
+	// MaxFiles is the maximum number of the files. Zero means no limit.
+	// +optional
+	MaxFiles int `json:"maxFiles" yaml:"maxFiles"`
+}
+
+// ContextCache is the cache of the Git, HTTP and OCI contexts, shared across the BuildJobs.
+//
+// The cache is stored on a ReadWriteMany PersistentVolumeClaim in the namespace of the BuildJob.
+// Git repositories are cached as mirror clones, and HTTP archives and OCI layers are cached by their digests.
+// HTTP archives are cached only when SHA256 is specified.
+type ContextCache struct {
+	// ClaimName is the name of the PersistentVolumeClaim.
+	// Empty ClaimName disables the cache.
+	// +optional
+	ClaimName string `json:"claimName" yaml:"claimName"`
+}
+
 // Git
 type Git struct {
 	// URL is defined in `git-clone(1)`.
@@ -167,20 +398,180 @@ type Git struct {
 	// SSHSecretRef contains the contents of ~/.ssh.
 	// +optional
 	SSHSecretRef corev1.LocalObjectReference `json:"sshSecretRef" yaml:"sshSecretRef"`
+	// Depth of the shallow clone. 0 for the full clone.
+	// +optional
+	Depth int `json:"depth"`
+	// Submodules are checked out recursively when true.
+	// +optional
+	Submodules bool `json:"submodules"`
+	// SparsePaths limits the checkout to the paths, in the sparse-checkout pattern format.
+	// e.g. ["docs/", "Dockerfile"]
+	// +optional
+	SparsePaths []string `json:"sparsePaths" yaml:"sparsePaths"`
+	// LFS fetches the Git LFS objects when true.
+	// +optional
+	LFS bool `json:"lfs"`
+	// BasicAuthSecretRef is a `kubernetes.io/basic-auth` secret ("username" and "password")
+	// for HTTP(S) repos.
+	// +optional
+	BasicAuthSecretRef corev1.LocalObjectReference `json:"basicAuthSecretRef" yaml:"basicAuthSecretRef"`
+	// TokenSecretRef is a secret with "token" (e.g. a personal access token) for HTTP(S) repos.
+	// The optional "username" defaults to "x-access-token".
+	// Mutually exclusive with BasicAuthSecretRef.
+	// +optional
+	TokenSecretRef corev1.LocalObjectReference `json:"tokenSecretRef" yaml:"tokenSecretRef"`
+	// Verify enables the signature verification of the checked-out revision.
+	// +optional
+	Verify GitVerify `json:"verify"`
+}
+
+type GitVerifyFormat string
+
+const (
+	// GitVerifyFormatGPG is for the GPG signatures.
+	GitVerifyFormatGPG GitVerifyFormat = "GPG"
+	// GitVerifyFormatSSH is for the SSH signatures.
+	GitVerifyFormatSSH GitVerifyFormat = "SSH"
+)
+
+// GitVerify is the signature verification of the Git revision.
+// The verification is enabled when either SecretRef or ConfigMapRef is set.
+// The build fails when the signature is missing or not made by the trusted keys.
+type GitVerify struct {
+	// Format is GPG (default) or SSH.
+	// +optional
+	Format GitVerifyFormat `json:"format"`
+	// SecretRef contains the trusted public keys.
+	// For GPG, each key is an (armored) public key.
+	// For SSH, each key is a public key, or the lines in the "allowed signers" format of ssh-keygen(1).
+	// +optional
+	SecretRef corev1.LocalObjectReference `json:"secretRef" yaml:"secretRef"`
+	// ConfigMapRef is same as SecretRef but for ConfigMap.
+	// Mutually exclusive with SecretRef.
+	// +optional
+	ConfigMapRef corev1.LocalObjectReference `json:"configMapRef" yaml:"configMapRef"`
+	// Tag verifies the signature of the annotated tag specified by Revision, rather than the commit.
+	// +optional
+	Tag bool `json:"tag"`
 }
 
 // HTTP
 type HTTP struct {
-	// URL for a tar archive.
+	// URL for an archive.
 	// URL MUST be http:// or https:// .
 	// Implementations SHOULD accept tar+gz.
+	// cbipluginhelper also accepts tar+bzip2, tar+zstd and zip.
 	URL string `json:"url"`
-	// TODO: add mediatype
-	// TODO: add TLS stuff
-	//
 	// SubPath within the archive.
 	// +optinal
 	SubPath string `json:"subPath" yaml:"subPath"`
+	// SHA256 is the expected hex-encoded SHA256 digest of the archive.
+	// The archive is not extracted when the digest does not match.
+	// +optional
+	SHA256 string `json:"sha256"`
+	// MediaType is the expected media type of the archive, detected from the content.
+	// e.g. "application/x-tar", "application/gzip", "application/x-bzip2", "application/zstd", "application/zip"
+	// +optional
+	MediaType string `json:"mediaType" yaml:"mediaType"`
+	// CASecretRef contains "ca.crt", the PEM-encoded CA certificates trusted
+	// in addition to the system ones.
+	// +optional
+	CASecretRef corev1.LocalObjectReference `json:"caSecretRef" yaml:"caSecretRef"`
+	// InsecureSkipVerify skips verifying the TLS certificate of the server.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify" yaml:"insecureSkipVerify"`
+	// HeadersSecretRef contains the request headers, keyed by the header names.
+	// e.g. {"Authorization": "Bearer deadbeef"}
+	// +optional
+	HeadersSecretRef corev1.LocalObjectReference `json:"headersSecretRef" yaml:"headersSecretRef"`
+}
+
+// Local
+//
+// The client creates the BuildJob and then uploads the tar archive of the
+// directory to the context server with the UID of the BuildJob.
+// The controller does not create the job until the archive is uploaded.
+type Local struct {
+	// Digest of the tar archive, e.g. "sha256:deadbeef...".
+	// The archive MAY be compressed with gzip.
+	Digest string `json:"digest"`
+	// SubPath within the archive.
+	// +optional
+	SubPath string `json:"subPath" yaml:"subPath"`
+	// URL for fetching the archive from the context server.
+	// URL is set by the controller before passing the BuildJob to the plugin,
+	// and MUST NOT be set by the client.
+	// +optional
+	URL string `json:"url"`
+}
+
+// OCI
+//
+// The layers of the image are unpacked in order, with the whiteouts applied.
+// Non-tar layers with "org.opencontainers.image.title" annotation
+// (e.g. ORAS artifacts) are written as the files with the title.
+type OCI struct {
+	// Ref is the image reference, e.g. "example.com/foo/bundle:v1".
+	// Docker Hub is used when the registry is omitted.
+	Ref string `json:"ref"`
+	// Digest pins the manifest (or the index), e.g. "sha256:deadbeef...".
+	// When set, the manifest is pulled by the digest rather than by the tag of Ref.
+	// +optional
+	Digest string `json:"digest"`
+	// SubPath within the unpacked layers.
+	// +optional
+	SubPath string `json:"subPath" yaml:"subPath"`
+	// SecretRef is a .dockerconfigjson secret for pulling from the registry.
+	// +optional
+	SecretRef corev1.LocalObjectReference `json:"secretRef" yaml:"secretRef"`
+}
+
+// S3
+//
+// Either Key or Prefix needs to be set.
+type S3 struct {
+	// Endpoint is the URL of the storage, e.g. "http://minio:9000".
+	// Defaults to "https://s3.amazonaws.com".
+	// +optional
+	Endpoint string `json:"endpoint"`
+	// Region defaults to "us-east-1".
+	// +optional
+	Region string `json:"region"`
+	Bucket string `json:"bucket"`
+	// Key of the tar archive.
+	// Implementations SHOULD accept tar+gz.
+	// +optional
+	Key string `json:"key"`
+	// Prefix of the objects to be synced to the context directory, e.g. "foo/".
+	// The prefix is trimmed from the object keys.
+	// +optional
+	Prefix string `json:"prefix"`
+	// SubPath within the archive or the prefix.
+	// +optional
+	SubPath string `json:"subPath" yaml:"subPath"`
+	// SecretRef contains "accessKeyID", "secretAccessKey", and optionally "sessionToken".
+	// Anonymous requests are sent when SecretRef is empty.
+	// +optional
+	SecretRef corev1.LocalObjectReference `json:"secretRef" yaml:"secretRef"`
+	// PathStyle sends the path-style requests (ENDPOINT/BUCKET/KEY) rather than
+	// the virtual-hosted-style requests (BUCKET.ENDPOINT/KEY).
+	// Typically required for MinIO.
+	// +optional
+	PathStyle bool `json:"pathStyle" yaml:"pathStyle"`
+}
+
+// BuildKitSession
+//
+// The client creates the BuildJob and then attaches to the build pod via
+// port-forwarding (buildkitsession.DefaultPort), so that BuildKit can pull
+// the directory from the client using the FileSync service of the BuildKit session.
+// The build waits for the client to be attached.
+type BuildKitSession struct {
+	// SharedKey is the key for reusing the files transferred in the previous
+	// builds with the same key, so that only the changed files are transferred.
+	// Typically derived from the hostname of the client and the directory path.
+	// +optional
+	SharedKey string `json:"sharedKey" yaml:"sharedKey"`
 }
 
 // Rclone
@@ -198,8 +589,88 @@ type Rclone struct {
 // BuildJobStatus is the status for a BuildJob resource
 type BuildJobStatus struct {
 	Job string `json:"job"`
+	// Phase is set by the controller, corresponding to the status of the job.
+	// +optional
+	Phase BuildJobPhase `json:"phase"`
+	// Plugin is the name of the plugin selected by the controller.
+	// +optional
+	Plugin string `json:"plugin"`
+	// Log is the location of the build log persisted by the controller
+	// after the job has finished.
+	// +optional
+	Log BuildJobLog `json:"log"`
+	// Message is the human-readable reason of the failure, including the
+	// termination message of the failed container.
+	// e.g. the signature verification failure of the Git context.
+	// When the job could not be created, Message is prefixed with the reason,
+	// e.g. "PolicyViolation: ...".
+	// +optional
+	Message string `json:"message"`
+}
+
+type BuildJobLogKind string
+
+const (
+	// BuildJobLogKindConfigMap means the log is stored in a ConfigMap.
+	BuildJobLogKindConfigMap BuildJobLogKind = "ConfigMap"
+	// BuildJobLogKindPersistentVolumeClaim means the log is stored in a PersistentVolumeClaim.
+	BuildJobLogKindPersistentVolumeClaim BuildJobLogKind = "PersistentVolumeClaim"
+	// BuildJobLogKindS3 means the log is stored in an S3-compatible bucket.
+	BuildJobLogKindS3 BuildJobLogKind = "S3"
+)
+
+// BuildJobLog is the location of the persisted build log.
+type BuildJobLog struct {
+	// Kind is empty when the log is not persisted (yet).
+	Kind BuildJobLogKind `json:"kind"`
+	// ConfigMap is set for BuildJobLogKindConfigMap.
+	ConfigMap BuildJobLogConfigMap `json:"configMap" yaml:"configMap"`
+	// PersistentVolumeClaim is set for BuildJobLogKindPersistentVolumeClaim.
+	PersistentVolumeClaim BuildJobLogPersistentVolumeClaim `json:"persistentVolumeClaim" yaml:"persistentVolumeClaim"`
+	// S3 is set for BuildJobLogKindS3.
+	S3 BuildJobLogS3 `json:"s3"`
+	// Truncated is true when the head of the log was truncated due to the size limit.
+	// +optional
+	Truncated bool `json:"truncated"`
+}
+
+// BuildJobLogConfigMap is the ConfigMap in the namespace of the BuildJob.
+type BuildJobLogConfigMap struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// BuildJobLogPersistentVolumeClaim is the file in the PersistentVolumeClaim.
+// The claim is not necessarily in the namespace of the BuildJob.
+type BuildJobLogPersistentVolumeClaim struct {
+	Namespace string `json:"namespace"`
+	ClaimName string `json:"claimName" yaml:"claimName"`
+	// Path is relative to the root of the volume.
+	Path string `json:"path"`
+}
+
+// BuildJobLogS3 is the object in the S3-compatible bucket.
+type BuildJobLogS3 struct {
+	Endpoint string `json:"endpoint"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key"`
 }
 
+type BuildJobPhase string
+
+const (
+	// BuildJobPhasePending means the job has been created but its pod is not running yet.
+	BuildJobPhasePending BuildJobPhase = "Pending"
+	// BuildJobPhaseRunning means the pod of the job is running.
+	BuildJobPhaseRunning BuildJobPhase = "Running"
+	// BuildJobPhaseSucceeded means the job has completed successfully.
+	BuildJobPhaseSucceeded BuildJobPhase = "Succeeded"
+	// BuildJobPhaseFailed means the job has failed.
+	BuildJobPhaseFailed BuildJobPhase = "Failed"
+	// BuildJobPhaseCanceled means the build has been canceled before finishing.
+	BuildJobPhaseCanceled BuildJobPhase = "Canceled"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // BuildJobList is a list of BuildJob resources
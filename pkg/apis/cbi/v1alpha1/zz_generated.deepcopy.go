@@ -24,12 +24,29 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildCache) DeepCopyInto(out *BuildCache) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildCache.
+func (in *BuildCache) DeepCopy() *BuildCache {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuildJob) DeepCopyInto(out *BuildJob) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	out.Status = in.Status
 	return
 }
@@ -85,12 +102,99 @@ func (in *BuildJobList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildJobLog) DeepCopyInto(out *BuildJobLog) {
+	*out = *in
+	out.ConfigMap = in.ConfigMap
+	out.PersistentVolumeClaim = in.PersistentVolumeClaim
+	out.S3 = in.S3
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildJobLog.
+func (in *BuildJobLog) DeepCopy() *BuildJobLog {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildJobLog)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildJobLogConfigMap) DeepCopyInto(out *BuildJobLogConfigMap) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildJobLogConfigMap.
+func (in *BuildJobLogConfigMap) DeepCopy() *BuildJobLogConfigMap {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildJobLogConfigMap)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildJobLogPersistentVolumeClaim) DeepCopyInto(out *BuildJobLogPersistentVolumeClaim) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildJobLogPersistentVolumeClaim.
+func (in *BuildJobLogPersistentVolumeClaim) DeepCopy() *BuildJobLogPersistentVolumeClaim {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildJobLogPersistentVolumeClaim)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildJobLogS3) DeepCopyInto(out *BuildJobLogS3) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildJobLogS3.
+func (in *BuildJobLogS3) DeepCopy() *BuildJobLogS3 {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildJobLogS3)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuildJobSpec) DeepCopyInto(out *BuildJobSpec) {
 	*out = *in
 	out.Registry = in.Registry
 	out.Language = in.Language
-	out.Context = in.Context
+	in.Context.DeepCopyInto(&out.Context)
+	if in.Contexts != nil {
+		in, out := &in.Contexts, &out.Contexts
+		*out = make([]NamedContext, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.BuildSecrets != nil {
+		in, out := &in.BuildSecrets, &out.BuildSecrets
+		*out = make([]BuildSecret, len(*in))
+		copy(*out, *in)
+	}
+	out.SSH = in.SSH
+	out.Cache = in.Cache
+	if in.Platforms != nil {
+		in, out := &in.Platforms, &out.Platforms
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.Output = in.Output
 	return
 }
 
@@ -107,6 +211,7 @@ func (in *BuildJobSpec) DeepCopy() *BuildJobSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BuildJobStatus) DeepCopyInto(out *BuildJobStatus) {
 	*out = *in
+	out.Log = in.Log
 	return
 }
 
@@ -120,6 +225,39 @@ func (in *BuildJobStatus) DeepCopy() *BuildJobStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildKitSession) DeepCopyInto(out *BuildKitSession) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildKitSession.
+func (in *BuildKitSession) DeepCopy() *BuildKitSession {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildKitSession)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuildSecret) DeepCopyInto(out *BuildSecret) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuildSecret.
+func (in *BuildSecret) DeepCopy() *BuildSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(BuildSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Cloudbuild) DeepCopyInto(out *Cloudbuild) {
 	*out = *in
@@ -139,10 +277,16 @@ func (in *Cloudbuild) DeepCopy() *Cloudbuild {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Context) DeepCopyInto(out *Context) {
 	*out = *in
-	out.Git = in.Git
+	in.Git.DeepCopyInto(&out.Git)
 	out.ConfigMapRef = in.ConfigMapRef
 	out.HTTP = in.HTTP
 	out.Rclone = in.Rclone
+	out.Local = in.Local
+	out.BuildKitSession = in.BuildKitSession
+	out.OCI = in.OCI
+	out.S3 = in.S3
+	out.Cache = in.Cache
+	out.Limits = in.Limits
 	return
 }
 
@@ -156,6 +300,54 @@ func (in *Context) DeepCopy() *Context {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContextCache) DeepCopyInto(out *ContextCache) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContextCache.
+func (in *ContextCache) DeepCopy() *ContextCache {
+	if in == nil {
+		return nil
+	}
+	out := new(ContextCache)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ContextLimits) DeepCopyInto(out *ContextLimits) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ContextLimits.
+func (in *ContextLimits) DeepCopy() *ContextLimits {
+	if in == nil {
+		return nil
+	}
+	out := new(ContextLimits)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DockerTarballOutput) DeepCopyInto(out *DockerTarballOutput) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DockerTarballOutput.
+func (in *DockerTarballOutput) DeepCopy() *DockerTarballOutput {
+	if in == nil {
+		return nil
+	}
+	out := new(DockerTarballOutput)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Dockerfile) DeepCopyInto(out *Dockerfile) {
 	*out = *in
@@ -176,6 +368,14 @@ func (in *Dockerfile) DeepCopy() *Dockerfile {
 func (in *Git) DeepCopyInto(out *Git) {
 	*out = *in
 	out.SSHSecretRef = in.SSHSecretRef
+	if in.SparsePaths != nil {
+		in, out := &in.SparsePaths, &out.SparsePaths
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	out.BasicAuthSecretRef = in.BasicAuthSecretRef
+	out.TokenSecretRef = in.TokenSecretRef
+	out.Verify = in.Verify
 	return
 }
 
@@ -189,9 +389,29 @@ func (in *Git) DeepCopy() *Git {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GitVerify) DeepCopyInto(out *GitVerify) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	out.ConfigMapRef = in.ConfigMapRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GitVerify.
+func (in *GitVerify) DeepCopy() *GitVerify {
+	if in == nil {
+		return nil
+	}
+	out := new(GitVerify)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HTTP) DeepCopyInto(out *HTTP) {
 	*out = *in
+	out.CASecretRef = in.CASecretRef
+	out.HeadersSecretRef = in.HeadersSecretRef
 	return
 }
 
@@ -205,6 +425,24 @@ func (in *HTTP) DeepCopy() *HTTP {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPUploadOutput) DeepCopyInto(out *HTTPUploadOutput) {
+	*out = *in
+	out.CASecretRef = in.CASecretRef
+	out.HeadersSecretRef = in.HeadersSecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPUploadOutput.
+func (in *HTTPUploadOutput) DeepCopy() *HTTPUploadOutput {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPUploadOutput)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Language) DeepCopyInto(out *Language) {
 	*out = *in
@@ -224,6 +462,91 @@ func (in *Language) DeepCopy() *Language {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Local) DeepCopyInto(out *Local) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Local.
+func (in *Local) DeepCopy() *Local {
+	if in == nil {
+		return nil
+	}
+	out := new(Local)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamedContext) DeepCopyInto(out *NamedContext) {
+	*out = *in
+	in.Context.DeepCopyInto(&out.Context)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamedContext.
+func (in *NamedContext) DeepCopy() *NamedContext {
+	if in == nil {
+		return nil
+	}
+	out := new(NamedContext)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OCI) DeepCopyInto(out *OCI) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OCI.
+func (in *OCI) DeepCopy() *OCI {
+	if in == nil {
+		return nil
+	}
+	out := new(OCI)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OCITarballOutput) DeepCopyInto(out *OCITarballOutput) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OCITarballOutput.
+func (in *OCITarballOutput) DeepCopy() *OCITarballOutput {
+	if in == nil {
+		return nil
+	}
+	out := new(OCITarballOutput)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Output) DeepCopyInto(out *Output) {
+	*out = *in
+	out.OCITarball = in.OCITarball
+	out.DockerTarball = in.DockerTarball
+	out.HTTPUpload = in.HTTPUpload
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Output.
+func (in *Output) DeepCopy() *Output {
+	if in == nil {
+		return nil
+	}
+	out := new(Output)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Rclone) DeepCopyInto(out *Rclone) {
 	*out = *in
@@ -274,3 +597,37 @@ func (in *S2I) DeepCopy() *S2I {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3) DeepCopyInto(out *S3) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3.
+func (in *S3) DeepCopy() *S3 {
+	if in == nil {
+		return nil
+	}
+	out := new(S3)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SSH) DeepCopyInto(out *SSH) {
+	*out = *in
+	out.SecretRef = in.SecretRef
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SSH.
+func (in *SSH) DeepCopy() *SSH {
+	if in == nil {
+		return nil
+	}
+	out := new(SSH)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,116 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package buildkitsession implements the subset of the BuildKit session protocol
+// used for streaming the build context from the client (ContextKindBuildKitSession).
+//
+// BuildKit pulls the local directories of a build from the "session" attached
+// by the client that requested the build, using the FileSync gRPC service.
+// Only the files that have changed since the previous build with the same
+// shared key are transferred.
+//
+// For CBI, the build pod runs buildctl behind Proxy, which replaces the
+// session of buildctl with the session that forwards the FileSync calls to
+// the client (cbictl) attached to the pod via port-forwarding.
+// The other session calls (e.g. the registry auth) are still served by buildctl.
+//
+// The messages are defined by hand so as to avoid depending on BuildKit,
+// and only need to be compatible on the wire.
+package buildkitsession
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// DefaultPort is the port of the build pod for attaching the client.
+	DefaultPort = 12120
+
+	// SocketDir is the directory in the build pod for the proxy socket.
+	// buildctl needs to be executed with `--addr unix://SocketDir/buildkitd.sock`.
+	SocketDir = "/run/cbi-buildkit-session"
+
+	// SocketPath is the path of the proxy socket.
+	SocketPath = SocketDir + "/buildkitd.sock"
+)
+
+// gRPC metadata of the session, lower-cased.
+const (
+	headerSessionSharedKey = "x-docker-expose-session-sharedkey"
+
+	// keyDirName is the metadata of the FileSync calls for specifying the local directory name.
+	keyDirName = "dir-name"
+	// keyIncludePatterns is the metadata of the FileSync calls for filtering the files.
+	keyIncludePatterns = "include-patterns"
+	// keyExcludePatterns is the metadata of the FileSync calls for excluding the files, e.g. .dockerignore.
+	keyExcludePatterns = "exclude-patterns"
+	// keyFollowPaths is the metadata of the FileSync calls for the symlinks to be followed.
+	keyFollowPaths = "followpaths"
+)
+
+// gRPC methods.
+const (
+	methodSession   = "/moby.buildkit.v1.Control/Session"
+	methodDiffCopy  = "/moby.filesync.v1.FileSync/DiffCopy"
+	fileSyncService = "/moby.filesync.v1.FileSync/"
+)
+
+// BytesMessage is moby.buildkit.v1.BytesMessage, used for the session stream.
+type BytesMessage struct {
+	Data []byte `protobuf:"bytes,1,opt,name=data,proto3"`
+}
+
+func (m *BytesMessage) Reset()         { *m = BytesMessage{} }
+func (m *BytesMessage) String() string { return proto.CompactTextString(m) }
+func (*BytesMessage) ProtoMessage()    {}
+
+// PacketType is fsutil.Packet.PacketType.
+type PacketType int32
+
+const (
+	PacketStat PacketType = 0
+	PacketReq  PacketType = 1
+	PacketData PacketType = 2
+	PacketFin  PacketType = 3
+)
+
+// Packet is fsutil.Packet, used for the DiffCopy stream.
+type Packet struct {
+	Type PacketType `protobuf:"varint,1,opt,name=type,proto3"`
+	Stat *Stat      `protobuf:"bytes,2,opt,name=stat"`
+	ID   uint32     `protobuf:"varint,3,opt,name=ID,proto3"`
+	Data []byte     `protobuf:"bytes,4,opt,name=data,proto3"`
+}
+
+func (m *Packet) Reset()         { *m = Packet{} }
+func (m *Packet) String() string { return proto.CompactTextString(m) }
+func (*Packet) ProtoMessage()    {}
+
+// Stat is fsutil.Stat.
+// Mode is os.FileMode, and ModTime is in nanoseconds.
+type Stat struct {
+	Path     string `protobuf:"bytes,1,opt,name=path,proto3"`
+	Mode     uint32 `protobuf:"varint,2,opt,name=mode,proto3"`
+	Uid      uint32 `protobuf:"varint,3,opt,name=uid,proto3"`
+	Gid      uint32 `protobuf:"varint,4,opt,name=gid,proto3"`
+	Size     int64  `protobuf:"varint,5,opt,name=size,proto3"`
+	ModTime  int64  `protobuf:"varint,6,opt,name=modTime,proto3"`
+	Linkname string `protobuf:"bytes,7,opt,name=linkname,proto3"`
+}
+
+func (m *Stat) Reset()         { *m = Stat{} }
+func (m *Stat) String() string { return proto.CompactTextString(m) }
+func (*Stat) ProtoMessage()    {}
@@ -0,0 +1,244 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildkitsession
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// receive receives the files with DiffCopy, in the same way as the receiver of BuildKit.
+// The directories are returned as "<dir>", and the symlinks as "-> TARGET".
+func receive(ctx context.Context, conn *grpc.ClientConn, dirName string, includes, excludes, followPaths []string) (map[string]string, error) {
+	md := metadata.MD{keyDirName: []string{dirName}}
+	if len(includes) > 0 {
+		md[keyIncludePatterns] = includes
+	}
+	if len(excludes) > 0 {
+		md[keyExcludePatterns] = excludes
+	}
+	if len(followPaths) > 0 {
+		md[keyFollowPaths] = followPaths
+	}
+	stream, err := grpc.NewClientStream(metadata.NewOutgoingContext(ctx, md), &fileSyncServiceDesc.Streams[0], conn, methodDiffCopy)
+	if err != nil {
+		return nil, err
+	}
+	var stats []*Stat
+	for {
+		var p Packet
+		if err := stream.RecvMsg(&p); err != nil {
+			return nil, err
+		}
+		if p.Type != PacketStat {
+			return nil, fmt.Errorf("unexpected packet %v", p.Type)
+		}
+		if p.Stat == nil {
+			break
+		}
+		stats = append(stats, p.Stat)
+	}
+	files := make(map[string]string)
+	for id, st := range stats {
+		if os.FileMode(st.Mode).IsDir() {
+			files[st.Path] = "<dir>"
+			continue
+		}
+		if os.FileMode(st.Mode)&os.ModeSymlink != 0 {
+			files[st.Path] = "-> " + st.Linkname
+			continue
+		}
+		if err := stream.SendMsg(&Packet{Type: PacketReq, ID: uint32(id)}); err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		for {
+			var p Packet
+			if err := stream.RecvMsg(&p); err != nil {
+				return nil, err
+			}
+			if p.Type != PacketData || p.ID != uint32(id) {
+				return nil, fmt.Errorf("unexpected packet %v (id %d)", p.Type, p.ID)
+			}
+			if len(p.Data) == 0 {
+				break
+			}
+			buf.Write(p.Data)
+		}
+		if int64(buf.Len()) != st.Size {
+			return nil, fmt.Errorf("%s: expected %d bytes, got %d", st.Path, st.Size, buf.Len())
+		}
+		files[st.Path] = buf.String()
+	}
+	if err := stream.SendMsg(&Packet{Type: PacketFin}); err != nil {
+		return nil, err
+	}
+	var p Packet
+	if err := stream.RecvMsg(&p); err != nil {
+		return nil, err
+	}
+	if p.Type != PacketFin {
+		return nil, fmt.Errorf("unexpected packet %v", p.Type)
+	}
+	return files, nil
+}
+
+func serve(t *testing.T, gs *grpc.Server) *grpc.ClientConn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gs.Serve(l)
+	conn, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestFileSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildkitsession-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	large := string(bytes.Repeat([]byte("x"), 100*1024))
+	for name, content := range map[string]string{
+		"Dockerfile": "FROM busybox\n",
+		"sub/large":  large,
+		"other/foo":  "foo",
+		"other/bar":  "bar",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("other/foo", filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	gs := grpc.NewServer()
+	RegisterFileSync(gs, map[string]string{"context": dir})
+	defer gs.Stop()
+	fileSyncConn := serve(t, gs)
+	defer fileSyncConn.Close()
+	// the FileSync calls are forwarded in the same way as Proxy
+	fwd := newForwardingServer(func(string) (*grpc.ClientConn, error) { return fileSyncConn, nil })
+	defer fwd.Stop()
+	conn := serve(t, fwd)
+	defer conn.Close()
+
+	testCases := []struct {
+		dirName     string
+		includes    []string
+		excludes    []string
+		followPaths []string
+		expected    map[string]string
+		expectedErr codes.Code
+	}{
+		{
+			dirName: "context",
+			expected: map[string]string{
+				"Dockerfile": "FROM busybox\n",
+				"link":       "-> other/foo",
+				"other":      "<dir>",
+				"other/bar":  "bar",
+				"other/foo":  "foo",
+				"sub":        "<dir>",
+				"sub/large":  large,
+			},
+		},
+		{
+			dirName:  "context",
+			includes: []string{"Dockerfile", "sub/large"},
+			expected: map[string]string{
+				"Dockerfile": "FROM busybox\n",
+				"sub":        "<dir>",
+				"sub/large":  large,
+			},
+		},
+		{
+			dirName:     "context",
+			includes:    []string{"Dockerfile"},
+			followPaths: []string{"link"},
+			expected: map[string]string{
+				"Dockerfile": "FROM busybox\n",
+				"link":       "-> other/foo",
+				"other":      "<dir>",
+				"other/foo":  "foo",
+			},
+		},
+		{
+			dirName:  "context",
+			excludes: []string{"sub", "link", "other/bar"},
+			expected: map[string]string{
+				"Dockerfile": "FROM busybox\n",
+				"other":      "<dir>",
+				"other/foo":  "foo",
+			},
+		},
+		{
+			dirName:  "context",
+			excludes: []string{"*", "!other/foo"},
+			expected: map[string]string{
+				"other":     "<dir>",
+				"other/foo": "foo",
+			},
+		},
+		{
+			dirName:     "context",
+			excludes:    []string{"["},
+			expectedErr: codes.InvalidArgument,
+		},
+		{
+			dirName:     "dockerfile",
+			expectedErr: codes.NotFound,
+		},
+	}
+	for _, tc := range testCases {
+		files, err := receive(context.Background(), conn, tc.dirName, tc.includes, tc.excludes, tc.followPaths)
+		if tc.expectedErr != codes.OK {
+			if status.Code(err) != tc.expectedErr {
+				t.Errorf("%s %v %v: expected %v, got %v", tc.dirName, tc.includes, tc.excludes, tc.expectedErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s %v %v: %v", tc.dirName, tc.includes, tc.excludes, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.expected, files) {
+			t.Errorf("%s %v %v: expected %d files, got %v", tc.dirName, tc.includes, tc.excludes, len(tc.expected), files)
+		}
+	}
+}
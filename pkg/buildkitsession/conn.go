@@ -0,0 +1,175 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildkitsession
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// msgStream is implemented by grpc.ClientStream and grpc.ServerStream.
+type msgStream interface {
+	SendMsg(m interface{}) error
+	RecvMsg(m interface{}) error
+}
+
+// streamConn is net.Conn over the stream of BytesMessage.
+type streamConn struct {
+	stream msgStream
+	// closeSend is called on Close, for the client stream.
+	closeSend func() error
+
+	readMu  sync.Mutex
+	lastBuf []byte
+
+	writeMu sync.Mutex
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// StreamConn returns net.Conn over the session stream.
+func StreamConn(stream msgStream) net.Conn {
+	c := &streamConn{
+		stream: stream,
+		closed: make(chan struct{}),
+	}
+	if cs, ok := stream.(grpc.ClientStream); ok {
+		c.closeSend = cs.CloseSend
+	}
+	return c
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if len(c.lastBuf) == 0 {
+		var m BytesMessage
+		if err := c.stream.RecvMsg(&m); err != nil {
+			return 0, err
+		}
+		c.lastBuf = m.Data
+	}
+	n := copy(b, c.lastBuf)
+	c.lastBuf = c.lastBuf[n:]
+	return n, nil
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	// SendMsg may retain the buffer after returning
+	data := append([]byte(nil), b...)
+	if err := c.stream.SendMsg(&BytesMessage{Data: data}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *streamConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		if c.closeSend != nil {
+			c.writeMu.Lock()
+			err = c.closeSend()
+			c.writeMu.Unlock()
+		}
+	})
+	return err
+}
+
+func (c *streamConn) LocalAddr() net.Addr                { return dummyAddr{} }
+func (c *streamConn) RemoteAddr() net.Addr               { return dummyAddr{} }
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type dummyAddr struct{}
+
+func (dummyAddr) Network() string { return "session" }
+func (dummyAddr) String() string  { return "session" }
+
+// singleConnListener is net.Listener that accepts the conn only once.
+// The second Accept blocks until the listener is closed.
+type singleConnListener struct {
+	mu     sync.Mutex
+	conn   net.Conn
+	closed chan struct{}
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	return &singleConnListener{conn: conn, closed: make(chan struct{})}
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	l.mu.Lock()
+	conn := l.conn
+	l.conn = nil
+	l.mu.Unlock()
+	if conn != nil {
+		return conn, nil
+	}
+	<-l.closed
+	return nil, errors.New("listener closed")
+}
+
+func (l *singleConnListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	select {
+	case <-l.closed:
+	default:
+		close(l.closed)
+	}
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return dummyAddr{}
+}
+
+// ServeConn serves gs on conn, until conn is closed or gs is stopped.
+func ServeConn(gs *grpc.Server, conn net.Conn) error {
+	return gs.Serve(newSingleConnListener(conn))
+}
+
+// DialConn creates the client conn over conn.
+// The client conn cannot reconnect after conn is closed.
+func DialConn(conn net.Conn, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	var once sync.Once
+	dialer := func(string, time.Duration) (net.Conn, error) {
+		var c net.Conn
+		once.Do(func() { c = conn })
+		if c == nil {
+			return nil, errors.New("session conn already used")
+		}
+		return c, nil
+	}
+	opts = append(opts, grpc.WithDialer(dialer), grpc.WithInsecure())
+	return grpc.Dial("session", opts...)
+}
@@ -0,0 +1,333 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildkitsession
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/moby/patternmatcher"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// fileSync is the handler type of the FileSync service.
+type fileSync interface {
+	diffCopy(stream grpc.ServerStream) error
+}
+
+var fileSyncServiceDesc = grpc.ServiceDesc{
+	ServiceName: "moby.filesync.v1.FileSync",
+	HandlerType: (*fileSync)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "DiffCopy",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(fileSync).diffCopy(stream)
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+}
+
+type fileSyncServer struct {
+	dirs map[string]string
+}
+
+// RegisterFileSync registers the FileSync service that serves dirs.
+// dirs is keyed by the local directory name of the build, e.g. "context" and "dockerfile".
+func RegisterFileSync(gs *grpc.Server, dirs map[string]string) {
+	gs.RegisterService(&fileSyncServiceDesc, &fileSyncServer{dirs: dirs})
+}
+
+func (s *fileSyncServer) diffCopy(stream grpc.ServerStream) error {
+	md, _ := metadata.FromIncomingContext(stream.Context())
+	var name string
+	if v := md[keyDirName]; len(v) > 0 {
+		name = v[0]
+	}
+	dir, ok := s.dirs[name]
+	if !ok {
+		return status.Errorf(codes.NotFound, "no access allowed to dir %q", name)
+	}
+	excludes, err := patternmatcher.New(md[keyExcludePatterns])
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid exclude patterns: %v", err)
+	}
+	includes := md[keyIncludePatterns]
+	if len(includes) > 0 {
+		// the followed paths are not filtered by the include patterns
+		includes = append(includes, followPaths(dir, md[keyFollowPaths])...)
+	}
+	snd := &sender{
+		stream:   stream,
+		dir:      dir,
+		includes: includes,
+		excludes: excludes,
+		files:    make(map[uint32]string),
+		reqs:     make(chan uint32, 128),
+	}
+	return snd.run()
+}
+
+// followPaths returns paths and the targets of the symlinks in paths, relative to dir.
+// The targets outside dir are ignored.
+func followPaths(dir string, paths []string) []string {
+	var res []string
+	for _, p := range paths {
+		p = filepath.Clean(p)
+		res = append(res, p)
+		fi, err := os.Lstat(filepath.Join(dir, p))
+		if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		target, err := filepath.EvalSymlinks(filepath.Join(dir, p))
+		if err != nil {
+			continue
+		}
+		root, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			continue
+		}
+		rel, err := filepath.Rel(root, target)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		res = append(res, rel)
+	}
+	return res
+}
+
+// sender implements the sender side of the fsutil DiffCopy protocol:
+//
+//  1. The sender sends the stats of all the files in the walk order, followed by an empty stat.
+//  2. The receiver requests the files that differ from its cache, using the index of the stats as the IDs.
+//  3. The sender sends each requested file as data packets, followed by an empty data packet.
+//  4. The receiver sends a fin packet, and the sender replies with a fin packet.
+type sender struct {
+	stream   grpc.ServerStream
+	dir      string
+	includes []string
+	excludes *patternmatcher.PatternMatcher
+
+	sendMu sync.Mutex
+
+	filesMu sync.Mutex
+	files   map[uint32]string
+
+	reqs chan uint32
+}
+
+func (s *sender) send(p *Packet) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return s.stream.SendMsg(p)
+}
+
+func (s *sender) run() error {
+	errCh := make(chan error, 3)
+	go func() { errCh <- s.walk() }()
+	go func() { errCh <- s.serveRequests() }()
+	go func() { errCh <- s.recv() }()
+	for i := 0; i < cap(errCh); i++ {
+		if err := <-errCh; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// included returns whether rel is included by the include patterns.
+// dir is also included when it is a parent of a pattern, so that the
+// receiver gets the parent directories before the children.
+func (s *sender) included(rel string, isDir bool) bool {
+	if len(s.includes) == 0 {
+		return true
+	}
+	for _, pattern := range s.includes {
+		pattern = filepath.Clean(pattern)
+		if m, _ := filepath.Match(pattern, rel); m {
+			return true
+		}
+		if strings.HasPrefix(rel, pattern+string(filepath.Separator)) {
+			return true
+		}
+		if isDir && strings.HasPrefix(pattern, rel+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingDir is an excluded directory that is sent only if some files under
+// it are re-included by the "!" exclude patterns.
+type pendingDir struct {
+	rel  string
+	path string
+	fi   os.FileInfo
+}
+
+func (s *sender) walk() error {
+	var (
+		id uint32
+		// matches is the exclude match results of the directories, for MatchesUsingParentResults
+		matches = map[string]patternmatcher.MatchInfo{}
+		pending []pendingDir
+	)
+	sendStat := func(rel, path string, fi os.FileInfo) error {
+		st := &Stat{
+			Path:    filepath.ToSlash(rel),
+			Mode:    uint32(fi.Mode()),
+			ModTime: fi.ModTime().UnixNano(),
+		}
+		switch mode := fi.Mode(); {
+		case mode.IsRegular():
+			st.Size = fi.Size()
+		case mode.IsDir():
+		case mode&os.ModeSymlink != 0:
+			var err error
+			if st.Linkname, err = os.Readlink(path); err != nil {
+				return err
+			}
+		default:
+			// devices, sockets, and so on
+			return nil
+		}
+		s.filesMu.Lock()
+		s.files[id] = path
+		s.filesMu.Unlock()
+		id++
+		return s.send(&Packet{Type: PacketStat, Stat: st})
+	}
+	err := filepath.Walk(s.dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if !s.included(rel, fi.IsDir()) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		// drop the pending directories that have been left by the walk
+		for len(pending) > 0 && !strings.HasPrefix(rel, pending[len(pending)-1].rel+string(filepath.Separator)) {
+			pending = pending[:len(pending)-1]
+		}
+		excluded, info, err := s.excludes.MatchesUsingParentResults(filepath.ToSlash(rel), matches[filepath.Dir(rel)])
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			matches[rel] = info
+		}
+		if excluded {
+			if !fi.IsDir() {
+				return nil
+			}
+			if !s.excludes.Exclusions() {
+				return filepath.SkipDir
+			}
+			// the files in the excluded directory may be re-included by "!" patterns
+			pending = append(pending, pendingDir{rel: rel, path: path, fi: fi})
+			return nil
+		}
+		for _, d := range pending {
+			if err := sendStat(d.rel, d.path, d.fi); err != nil {
+				return err
+			}
+		}
+		pending = pending[:0]
+		return sendStat(rel, path, fi)
+	})
+	if err != nil {
+		return err
+	}
+	return s.send(&Packet{Type: PacketStat})
+}
+func (s *sender) recv() error {
+	defer close(s.reqs)
+	for {
+		var p Packet
+		if err := s.stream.RecvMsg(&p); err != nil {
+			return err
+		}
+		switch p.Type {
+		case PacketReq:
+			select {
+			case s.reqs <- p.ID:
+			case <-s.stream.Context().Done():
+				return s.stream.Context().Err()
+			}
+		case PacketFin:
+			return s.send(&Packet{Type: PacketFin})
+		}
+	}
+}
+
+func (s *sender) serveRequests() error {
+	buf := make([]byte, 32*1024)
+	for id := range s.reqs {
+		s.filesMu.Lock()
+		path, ok := s.files[id]
+		s.filesMu.Unlock()
+		if !ok {
+			return status.Errorf(codes.InvalidArgument, "invalid file id %d", id)
+		}
+		if err := s.sendFile(id, path, buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sender) sendFile(id uint32, path string, buf []byte) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for {
+		n, err := f.Read(buf)
+		if n > 0 {
+			// the buffer is reused
+			data := append([]byte(nil), buf[:n]...)
+			if err := s.send(&Packet{Type: PacketData, ID: id, Data: data}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.send(&Packet{Type: PacketData, ID: id})
+}
@@ -0,0 +1,199 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildkitsession
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// rawCodec passes *[]byte frames through, so that the proxy does not need to know the messages.
+// proto.Message is marshaled as usual.
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	if b, ok := v.(*[]byte); ok {
+		return *b, nil
+	}
+	return proto.Marshal(v.(proto.Message))
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	if b, ok := v.(*[]byte); ok {
+		*b = append((*b)[:0], data...)
+		return nil
+	}
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+func (rawCodec) String() string {
+	return "proto"
+}
+
+// forwardedMetadata returns the incoming metadata to be forwarded.
+func forwardedMetadata(ctx context.Context) metadata.MD {
+	in, _ := metadata.FromIncomingContext(ctx)
+	out := metadata.MD{}
+	for k, v := range in {
+		switch {
+		case strings.HasPrefix(k, ":"), k == "content-type", k == "user-agent":
+			// set by the transport
+		default:
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// forward forwards the server stream to conn.
+// Unary RPCs can be also forwarded, as they are the same as streams on the wire.
+func forward(serverStream grpc.ServerStream, conn *grpc.ClientConn, method string, md metadata.MD) error {
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
+	clientStream, err := grpc.NewClientStream(metadata.NewOutgoingContext(ctx, md),
+		&grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, conn, method, grpc.CallCustomCodec(rawCodec{}))
+	if err != nil {
+		return err
+	}
+	// server -> client
+	go func() {
+		for {
+			var frame []byte
+			if err := serverStream.RecvMsg(&frame); err != nil {
+				if err == io.EOF {
+					clientStream.CloseSend()
+				} else {
+					cancel()
+				}
+				return
+			}
+			if err := clientStream.SendMsg(&frame); err != nil {
+				return
+			}
+		}
+	}()
+	// client -> server
+	header, err := clientStream.Header()
+	if err != nil {
+		return err
+	}
+	if err := serverStream.SendHeader(header); err != nil {
+		return err
+	}
+	for {
+		var frame []byte
+		if err := clientStream.RecvMsg(&frame); err != nil {
+			serverStream.SetTrailer(clientStream.Trailer())
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := serverStream.SendMsg(&frame); err != nil {
+			return err
+		}
+	}
+}
+
+// newServer creates the server that handles all the RPCs with handle.
+func newServer(handle func(serverStream grpc.ServerStream, method string) error) *grpc.Server {
+	handler := func(srv interface{}, serverStream grpc.ServerStream) error {
+		method, ok := grpc.MethodFromServerStream(serverStream)
+		if !ok {
+			return status.Error(codes.Internal, "method not found in the stream")
+		}
+		return handle(serverStream, method)
+	}
+	return grpc.NewServer(grpc.CustomCodec(rawCodec{}), grpc.UnknownServiceHandler(handler))
+}
+
+// newForwardingServer creates the server that forwards all the RPCs to the
+// conn returned by route.
+func newForwardingServer(route func(method string) (*grpc.ClientConn, error)) *grpc.Server {
+	return newServer(func(serverStream grpc.ServerStream, method string) error {
+		conn, err := route(method)
+		if err != nil {
+			return err
+		}
+		return forward(serverStream, conn, method, forwardedMetadata(serverStream.Context()))
+	})
+}
+
+// Proxy is the buildkitd proxy for buildctl in the build pod.
+type Proxy struct {
+	// Buildkitd is the conn to buildkitd.
+	Buildkitd *grpc.ClientConn
+	// Client is the conn to the attached client that serves FileSync.
+	Client *grpc.ClientConn
+	// SharedKey replaces the shared key of the session, if non-empty.
+	// BuildKit reuses the files transferred in the previous session with the same shared key.
+	SharedKey string
+}
+
+// Serve serves the proxy on l, until l is closed.
+func (p *Proxy) Serve(l net.Listener) error {
+	gs := newServer(func(serverStream grpc.ServerStream, method string) error {
+		if method == methodSession {
+			return p.session(serverStream)
+		}
+		return forward(serverStream, p.Buildkitd, method, forwardedMetadata(serverStream.Context()))
+	})
+	return gs.Serve(l)
+}
+
+// session opens the session to buildkitd on behalf of buildctl.
+// The FileSync calls from buildkitd are forwarded to the client, and the
+// other calls are forwarded to the session of buildctl.
+func (p *Proxy) session(serverStream grpc.ServerStream) error {
+	ctx, cancel := context.WithCancel(serverStream.Context())
+	defer cancel()
+	md := forwardedMetadata(ctx)
+	if p.SharedKey != "" {
+		md[headerSessionSharedKey] = []string{p.SharedKey}
+	}
+	buildctlSession, err := DialConn(StreamConn(serverStream))
+	if err != nil {
+		return err
+	}
+	defer buildctlSession.Close()
+	clientStream, err := grpc.NewClientStream(metadata.NewOutgoingContext(ctx, md),
+		&grpc.StreamDesc{ServerStreams: true, ClientStreams: true}, p.Buildkitd, methodSession)
+	if err != nil {
+		return err
+	}
+	gs := newForwardingServer(func(method string) (*grpc.ClientConn, error) {
+		if strings.HasPrefix(method, fileSyncService) {
+			return p.Client, nil
+		}
+		return buildctlSession, nil
+	})
+	// buildctl cancels the session after the build
+	go func() {
+		<-ctx.Done()
+		gs.Stop()
+	}()
+	ServeConn(gs, StreamConn(clientStream))
+	return nil
+}
@@ -0,0 +1,436 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config provides the versioned configuration file format of cbid.
+//
+// e.g.
+//
+//	apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+//	kind: CBIDConfiguration
+//	plugins:
+//	- address: cbi-plugins:12111
+//	  name: docker
+//	- address: cbi-plugins:12111
+//	  name: buildkit
+//	pluginSelectorStrategy: RoundRobin
+//	workers: 4
+//	resyncPeriod: 30s
+//	defaults:
+//	  timeout: 1h
+//	  ttl: 24h
+//	  registrySecretRef:
+//	    name: docker-registry-secret
+//	policy:
+//	  allowedContextKinds: ["Git", "HTTP"]
+//	  allowedRegistries: ["registry.example.com/"]
+//	logSink:
+//	  kind: ConfigMap
+//	contextCache:
+//	  claimName: cbi-context-cache
+//	contextLimits:
+//	  maxBytes: 1073741824
+//	  maxFiles: 100000
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/containerbuilding/cbi/pkg/apis/cbi"
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+const (
+	// APIVersion is the apiVersion of the current CBIDConfiguration.
+	APIVersion = "config." + cbi.GroupName + "/v1alpha1"
+	// Kind is the kind of CBIDConfiguration.
+	Kind = "CBIDConfiguration"
+)
+
+const (
+	// DefaultWorkers is the default number of the controller workers.
+	DefaultWorkers = 2
+	// DefaultResyncPeriod is the default resync period of the informers.
+	DefaultResyncPeriod = 30 * time.Second
+)
+
+// CBIDConfiguration is the configuration of cbid.
+//
+// Plugins, Workers, ResyncPeriod, and LogSink are structural settings that are
+// loaded only on startup.
+// The other settings are reloaded when the file changes.
+type CBIDConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+	// Plugins is the list of the CBI plugins.
+	// +optional
+	Plugins []Plugin `json:"plugins,omitempty"`
+	// PluginSelectorStrategy specifies how to select a plugin when
+	// multiple plugins match a BuildJob.
+	// +optional
+	PluginSelectorStrategy PluginSelectorStrategy `json:"pluginSelectorStrategy,omitempty"`
+	// Workers is the number of the controller workers.
+	// Defaults to DefaultWorkers.
+	// +optional
+	Workers int `json:"workers,omitempty"`
+	// ResyncPeriod is the resync period of the informers.
+	// Defaults to DefaultResyncPeriod.
+	// +optional
+	ResyncPeriod metav1.Duration `json:"resyncPeriod,omitempty"`
+	// Defaults are applied to BuildJobs.
+	// +optional
+	Defaults Defaults `json:"defaults,omitempty"`
+	// Policy restricts BuildJobs.
+	// +optional
+	Policy Policy `json:"policy,omitempty"`
+	// LogSink specifies where to persist the build logs after the jobs have finished.
+	// +optional
+	LogSink LogSink `json:"logSink,omitempty"`
+	// ContextCache is the cache of the Git, HTTP and OCI contexts.
+	// The ReadWriteMany claim needs to exist in each namespace of the BuildJobs.
+	// The cached HTTP archives and OCI layers are served only after a HEAD request
+	// with the credentials of the BuildJob succeeds, as the claims may share the volume.
+	// +optional
+	ContextCache crd.ContextCache `json:"contextCache,omitempty"`
+	// ContextLimits is applied to the BuildJobs without the limits, and also caps
+	// the limits of the BuildJobs.
+	// +optional
+	ContextLimits crd.ContextLimits `json:"contextLimits,omitempty"`
+}
+
+// Plugin specifies a CBI plugin.
+type Plugin struct {
+	// Address is hostname[:port]. The port defaults to plugin.DefaultPort.
+	Address string `json:"address"`
+	// Name is required when multiple plugins are served on a single port (e.g. cbi-plugins).
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+type PluginSelectorStrategy string
+
+const (
+	// PluginSelectorStrategyFirst selects the first matching plugin.
+	PluginSelectorStrategyFirst PluginSelectorStrategy = "First"
+	// PluginSelectorStrategyRoundRobin selects the matching plugins in round-robin order.
+	PluginSelectorStrategyRoundRobin PluginSelectorStrategy = "RoundRobin"
+)
+
+// Defaults are applied to BuildJobs.
+type Defaults struct {
+	// Timeout is set to the activeDeadlineSeconds of the Jobs.
+	// Zero means no timeout.
+	// +optional
+	Timeout metav1.Duration `json:"timeout,omitempty"`
+	// TTL is the duration after which the finished BuildJobs are deleted.
+	// Zero means the BuildJobs are never deleted.
+	// +optional
+	TTL metav1.Duration `json:"ttl,omitempty"`
+	// RegistrySecretRef is used for BuildJobs without registry.secretRef.
+	// +optional
+	RegistrySecretRef corev1.LocalObjectReference `json:"registrySecretRef,omitempty"`
+}
+
+// Policy restricts BuildJobs.
+// Empty lists mean no restriction.
+// The BuildJobs violating the policy are not built.
+type Policy struct {
+	// AllowedLanguageKinds is the list of the allowed language kinds.
+	// +optional
+	AllowedLanguageKinds []crd.LanguageKind `json:"allowedLanguageKinds,omitempty"`
+	// AllowedContextKinds is the list of the allowed context kinds.
+	// +optional
+	AllowedContextKinds []crd.ContextKind `json:"allowedContextKinds,omitempty"`
+	// AllowedRegistries is the list of the allowed prefixes of registry.target.
+	// e.g. "registry.example.com/"
+	// +optional
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+}
+
+// DefaultConfigMapLogMaxBytes is the default size limit of the logs stored in ConfigMaps.
+const DefaultConfigMapLogMaxBytes = 512 * 1024
+
+// maxConfigMapLogMaxBytes is the upper limit of ConfigMapLogSink.MaxBytes.
+// The size of a ConfigMap (including the metadata) cannot exceed 1MiB.
+const maxConfigMapLogMaxBytes = 1000 * 1000
+
+// LogSink specifies where to persist the build logs.
+type LogSink struct {
+	// Kind is empty when the logs are not persisted.
+	// +optional
+	Kind crd.BuildJobLogKind `json:"kind,omitempty"`
+	// +optional
+	ConfigMap ConfigMapLogSink `json:"configMap,omitempty"`
+	// +optional
+	PersistentVolumeClaim PersistentVolumeClaimLogSink `json:"persistentVolumeClaim,omitempty"`
+	// +optional
+	S3 S3LogSink `json:"s3,omitempty"`
+}
+
+// ConfigMapLogSink stores the logs in the ConfigMaps owned by the BuildJobs.
+type ConfigMapLogSink struct {
+	// MaxBytes is the size limit of the log. The head of the log is truncated.
+	// Defaults to DefaultConfigMapLogMaxBytes.
+	// +optional
+	MaxBytes int `json:"maxBytes,omitempty"`
+}
+
+// PersistentVolumeClaimLogSink stores the logs in the PersistentVolumeClaim
+// mounted on cbid.
+type PersistentVolumeClaimLogSink struct {
+	// Namespace of the claim. Defaults to the namespace of cbid.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	ClaimName string `json:"claimName"`
+	// MountPath is the path where the claim is mounted on the cbid container.
+	MountPath string `json:"mountPath"`
+}
+
+// S3LogSink stores the logs in the S3-compatible bucket.
+// The credentials are read from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY.
+type S3LogSink struct {
+	// Endpoint is the URL of the storage, e.g. "https://s3.amazonaws.com" or "http://minio:9000".
+	Endpoint string `json:"endpoint"`
+	// +optional
+	Region string `json:"region,omitempty"`
+	Bucket string `json:"bucket"`
+	// Prefix of the object keys, e.g. "cbi-logs/".
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// Default returns the default configuration.
+func Default() *CBIDConfiguration {
+	cfg := &CBIDConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: APIVersion,
+			Kind:       Kind,
+		},
+	}
+	SetDefaults(cfg)
+	return cfg
+}
+
+// SetDefaults fills the unset fields of cfg with the default values.
+func SetDefaults(cfg *CBIDConfiguration) {
+	if cfg.PluginSelectorStrategy == "" {
+		cfg.PluginSelectorStrategy = PluginSelectorStrategyFirst
+	}
+	if cfg.Workers == 0 {
+		cfg.Workers = DefaultWorkers
+	}
+	if cfg.ResyncPeriod.Duration == 0 {
+		cfg.ResyncPeriod.Duration = DefaultResyncPeriod
+	}
+	if cfg.LogSink.Kind == crd.BuildJobLogKindConfigMap && cfg.LogSink.ConfigMap.MaxBytes == 0 {
+		cfg.LogSink.ConfigMap.MaxBytes = DefaultConfigMapLogMaxBytes
+	}
+}
+
+// Parse parses the YAML (or JSON) configuration, and sets the default values.
+// Unknown fields are rejected.
+func Parse(b []byte) (*CBIDConfiguration, error) {
+	j, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.DisallowUnknownFields()
+	var cfg CBIDConfiguration
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, err
+	}
+	if cfg.APIVersion != APIVersion {
+		return nil, fmt.Errorf("unsupported apiVersion %q (expected %q)", cfg.APIVersion, APIVersion)
+	}
+	if cfg.Kind != Kind {
+		return nil, fmt.Errorf("unsupported kind %q (expected %q)", cfg.Kind, Kind)
+	}
+	SetDefaults(&cfg)
+	return &cfg, nil
+}
+
+// Load loads the configuration file, and validates it.
+func Load(path string) (*CBIDConfiguration, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if err := Validate(cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate validates cfg. cfg needs to be defaulted.
+func Validate(cfg *CBIDConfiguration) error {
+	type key struct{ address, name string }
+	seen := make(map[key]struct{})
+	for i, p := range cfg.Plugins {
+		if p.Address == "" {
+			return fmt.Errorf("plugins[%d]: address is required", i)
+		}
+		if strings.Contains(p.Address, "://") {
+			return fmt.Errorf("plugins[%d]: address must not contain scheme: %q", i, p.Address)
+		}
+		if strings.Contains(p.Address, "/") || strings.Contains(p.Name, "/") {
+			return fmt.Errorf("plugins[%d]: address and name must not contain \"/\"", i)
+		}
+		k := key{p.Address, p.Name}
+		if _, ok := seen[k]; ok {
+			return fmt.Errorf("plugins[%d]: duplicated plugin %q", i, p.Address+"/"+p.Name)
+		}
+		seen[k] = struct{}{}
+	}
+	switch cfg.PluginSelectorStrategy {
+	case PluginSelectorStrategyFirst, PluginSelectorStrategyRoundRobin:
+	default:
+		return fmt.Errorf("unknown pluginSelectorStrategy: %q", cfg.PluginSelectorStrategy)
+	}
+	if cfg.Workers < 1 {
+		return fmt.Errorf("workers must be positive, got %d", cfg.Workers)
+	}
+	if cfg.ResyncPeriod.Duration < 0 {
+		return fmt.Errorf("resyncPeriod must not be negative, got %v", cfg.ResyncPeriod.Duration)
+	}
+	if cfg.Defaults.Timeout.Duration < 0 {
+		return fmt.Errorf("defaults.timeout must not be negative, got %v", cfg.Defaults.Timeout.Duration)
+	}
+	if d := cfg.Defaults.Timeout.Duration; d > 0 && d < time.Second {
+		return fmt.Errorf("defaults.timeout must not be shorter than 1s, got %v", d)
+	}
+	if cfg.Defaults.TTL.Duration < 0 {
+		return fmt.Errorf("defaults.ttl must not be negative, got %v", cfg.Defaults.TTL.Duration)
+	}
+	for i, s := range cfg.Policy.AllowedLanguageKinds {
+		if s == "" {
+			return fmt.Errorf("policy.allowedLanguageKinds[%d]: empty", i)
+		}
+	}
+	for i, s := range cfg.Policy.AllowedContextKinds {
+		if s == "" {
+			return fmt.Errorf("policy.allowedContextKinds[%d]: empty", i)
+		}
+	}
+	for i, s := range cfg.Policy.AllowedRegistries {
+		if s == "" {
+			return fmt.Errorf("policy.allowedRegistries[%d]: empty", i)
+		}
+	}
+	if cfg.ContextLimits.MaxBytes < 0 {
+		return fmt.Errorf("contextLimits.maxBytes must not be negative, got %d", cfg.ContextLimits.MaxBytes)
+	}
+	if cfg.ContextLimits.MaxFiles < 0 {
+		return fmt.Errorf("contextLimits.maxFiles must not be negative, got %d", cfg.ContextLimits.MaxFiles)
+	}
+	return validateLogSink(&cfg.LogSink)
+}
+
+func validateLogSink(ls *LogSink) error {
+	switch ls.Kind {
+	case "":
+	case crd.BuildJobLogKindConfigMap:
+		if m := ls.ConfigMap.MaxBytes; m <= 0 || m > maxConfigMapLogMaxBytes {
+			return fmt.Errorf("logSink.configMap.maxBytes must be in (0, %d], got %d", maxConfigMapLogMaxBytes, m)
+		}
+	case crd.BuildJobLogKindPersistentVolumeClaim:
+		if ls.PersistentVolumeClaim.ClaimName == "" {
+			return fmt.Errorf("logSink.persistentVolumeClaim.claimName is required")
+		}
+		if !strings.HasPrefix(ls.PersistentVolumeClaim.MountPath, "/") {
+			return fmt.Errorf("logSink.persistentVolumeClaim.mountPath must be an absolute path, got %q", ls.PersistentVolumeClaim.MountPath)
+		}
+	case crd.BuildJobLogKindS3:
+		if ls.S3.Endpoint == "" {
+			return fmt.Errorf("logSink.s3.endpoint is required")
+		}
+		if ls.S3.Bucket == "" {
+			return fmt.Errorf("logSink.s3.bucket is required")
+		}
+	default:
+		return fmt.Errorf("unknown logSink.kind: %q", ls.Kind)
+	}
+	return nil
+}
+
+// StructuralChanged returns true if the structural settings, which cannot be
+// reloaded, differ between a and b.
+func StructuralChanged(a, b *CBIDConfiguration) bool {
+	return !reflect.DeepEqual(a.Plugins, b.Plugins) ||
+		a.Workers != b.Workers ||
+		a.ResyncPeriod != b.ResyncPeriod ||
+		a.LogSink != b.LogSink
+}
+
+// Check returns an error if bj violates the policy.
+// The kinds are compared case-insensitively, as in the plugin labels.
+func (p *Policy) Check(bj *crd.BuildJob) error {
+	if l := p.AllowedLanguageKinds; len(l) > 0 {
+		allowed := false
+		for _, k := range l {
+			if strings.EqualFold(string(k), string(bj.Spec.Language.Kind)) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("language kind %q is not allowed (allowed: %v)", bj.Spec.Language.Kind, l)
+		}
+	}
+	if l := p.AllowedContextKinds; len(l) > 0 {
+		kinds := []crd.ContextKind{bj.Spec.Context.Kind}
+		for _, nc := range bj.Spec.Contexts {
+			kinds = append(kinds, nc.Context.Kind)
+		}
+		for _, kind := range kinds {
+			allowed := false
+			for _, k := range l {
+				if strings.EqualFold(string(k), string(kind)) {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return fmt.Errorf("context kind %q is not allowed (allowed: %v)", kind, l)
+			}
+		}
+	}
+	if l := p.AllowedRegistries; len(l) > 0 {
+		allowed := false
+		for _, prefix := range l {
+			if strings.HasPrefix(bj.Spec.Registry.Target, prefix) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return fmt.Errorf("registry target %q is not allowed (allowed: %v)", bj.Spec.Registry.Target, l)
+		}
+	}
+	return nil
+}
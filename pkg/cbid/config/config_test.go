@@ -0,0 +1,272 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"testing"
+	"time"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		s           string
+		expectedErr bool
+	}{
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+plugins:
+- address: cbi-plugins:12111
+  name: docker
+- address: cbi-plugins:12111
+  name: buildkit
+pluginSelectorStrategy: RoundRobin
+workers: 4
+resyncPeriod: 1m
+defaults:
+  timeout: 1h
+  ttl: 24h
+  registrySecretRef:
+    name: docker-registry-secret
+policy:
+  allowedContextKinds: ["Git", "HTTP"]
+  allowedRegistries: ["registry.example.com/"]
+`,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+`,
+		},
+		{
+			// unknown apiVersion
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1
+kind: CBIDConfiguration
+`,
+			expectedErr: true,
+		},
+		{
+			// unknown field
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+worker: 4
+`,
+			expectedErr: true,
+		},
+		{
+			// invalid duration
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+resyncPeriod: 30
+`,
+			expectedErr: true,
+		},
+		{
+			// unknown strategy
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+pluginSelectorStrategy: Random
+`,
+			expectedErr: true,
+		},
+		{
+			// duplicated plugin
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+plugins:
+- address: cbi-docker
+- address: cbi-docker
+`,
+			expectedErr: true,
+		},
+		{
+			// scheme
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+plugins:
+- address: tcp://cbi-docker
+`,
+			expectedErr: true,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+defaults:
+  ttl: -1h
+`,
+			expectedErr: true,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+logSink:
+  kind: S3
+  s3:
+    endpoint: http://minio:9000
+    bucket: cbi-logs
+`,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+logSink:
+  kind: PersistentVolumeClaim
+  persistentVolumeClaim:
+    claimName: cbi-logs
+`,
+			expectedErr: true,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+logSink:
+  kind: ConfigMap
+  configMap:
+    maxBytes: 2000000
+`,
+			expectedErr: true,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+logSink:
+  kind: Syslog
+`,
+			expectedErr: true,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+contextCache:
+  claimName: cbi-context-cache
+`,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+contextLimits:
+  maxBytes: 1073741824
+  maxFiles: 100000
+`,
+		},
+		{
+			s: `apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+contextLimits:
+  maxFiles: -1
+`,
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		cfg, err := Parse([]byte(tc.s))
+		if err == nil {
+			err = Validate(cfg)
+		}
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil && tc.expectedErr {
+			t.Fatalf("%d: error is expected", i)
+		}
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	cfg, err := Parse([]byte(`apiVersion: config.cbi.containerbuilding.github.io/v1alpha1
+kind: CBIDConfiguration
+defaults:
+  timeout: 10m
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != DefaultWorkers {
+		t.Fatalf("expected %d, got %d", DefaultWorkers, cfg.Workers)
+	}
+	if cfg.ResyncPeriod.Duration != DefaultResyncPeriod {
+		t.Fatalf("expected %v, got %v", DefaultResyncPeriod, cfg.ResyncPeriod.Duration)
+	}
+	if cfg.PluginSelectorStrategy != PluginSelectorStrategyFirst {
+		t.Fatalf("expected %q, got %q", PluginSelectorStrategyFirst, cfg.PluginSelectorStrategy)
+	}
+	if cfg.Defaults.Timeout.Duration != 10*time.Minute {
+		t.Fatalf("expected 10m, got %v", cfg.Defaults.Timeout.Duration)
+	}
+	if StructuralChanged(cfg, Default()) {
+		t.Fatal("structural settings are expected to be unchanged")
+	}
+}
+
+func TestPolicyCheck(t *testing.T) {
+	p := Policy{
+		AllowedContextKinds: []crd.ContextKind{crd.ContextKindGit},
+		AllowedRegistries:   []string{"registry.example.com/"},
+	}
+	testCases := []struct {
+		spec        crd.BuildJobSpec
+		expectedErr bool
+	}{
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "registry.example.com/foo:latest"},
+				Context:  crd.Context{Kind: crd.ContextKindGit},
+			},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "registry.example.com/foo:latest"},
+				Context:  crd.Context{Kind: "git"}, // non-canonical form (lower case) is allowed
+			},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "registry.example.com/foo:latest"},
+				Context:  crd.Context{Kind: crd.ContextKindHTTP},
+			},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "registry.example.com/foo:latest"},
+				Context:  crd.Context{Kind: crd.ContextKindGit},
+				Contexts: []crd.NamedContext{
+					{Name: "assets", Context: crd.Context{Kind: crd.ContextKindHTTP}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "example.com/foo:latest"},
+				Context:  crd.Context{Kind: crd.ContextKindGit},
+			},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		err := p.Check(&crd.BuildJob{Spec: tc.spec})
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil && tc.expectedErr {
+			t.Fatalf("%d: error is expected", i)
+		}
+	}
+}
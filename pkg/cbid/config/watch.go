@@ -0,0 +1,60 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
+	"github.com/golang/glog"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// Watch polls the configuration file every interval until stopCh is closed,
+// and calls onChange with the validated configuration when the content changes.
+// Invalid configurations are logged and ignored.
+//
+// Polling is used instead of inotify so that the updates of ConfigMap volumes,
+// which are done by swapping symlinks, are detected reliably.
+func Watch(path string, interval time.Duration, stopCh <-chan struct{}, onChange func(*CBIDConfiguration)) {
+	last, err := ioutil.ReadFile(path)
+	if err != nil {
+		glog.Warningf("failed to read %s: %v", path, err)
+	}
+	wait.Until(func() {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			glog.Warningf("failed to read %s: %v", path, err)
+			return
+		}
+		if bytes.Equal(b, last) {
+			return
+		}
+		last = b
+		cfg, err := Parse(b)
+		if err == nil {
+			err = Validate(cfg)
+		}
+		if err != nil {
+			glog.Errorf("ignoring invalid configuration %s: %v", path, err)
+			return
+		}
+		glog.Infof("Reloading configuration %s", path)
+		onChange(cfg)
+	}, interval, stopCh)
+}
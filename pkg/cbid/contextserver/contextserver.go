@@ -0,0 +1,314 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package contextserver provides the HTTP server for the build contexts
+// uploaded from the clients (ContextKindLocal).
+//
+// The archives are stored in a content-addressable directory, and can be
+// uploaded and fetched only with the UID of the BuildJob that refers to the
+// digest of the archive:
+//
+//	PUT /v1/contexts/<BuildJob UID>/<digest>
+//	GET /v1/contexts/<BuildJob UID>/<digest>
+//
+// Archives that are not referred by any unfinished BuildJob are garbage collected.
+package contextserver
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/containerbuilding/cbi/pkg/apis/cbi"
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	clientset "github.com/containerbuilding/cbi/pkg/client/clientset/versioned"
+	listers "github.com/containerbuilding/cbi/pkg/client/listers/cbi/v1alpha1"
+)
+
+const (
+	// AnnotationUploaded is set to the BuildJob with the digest of the uploaded archive.
+	AnnotationUploaded = cbi.GroupName + "/context-uploaded"
+
+	// DefaultMaxBytes is the default maximum size of an archive.
+	DefaultMaxBytes = 512 * 1024 * 1024
+
+	// PathPrefix is the prefix of the URL path.
+	PathPrefix = "/v1/contexts/"
+)
+
+var digestRegexp = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ValidDigest returns true if digest is a valid "sha256:<hex>" digest.
+func ValidDigest(digest string) bool {
+	return digestRegexp.MatchString(digest)
+}
+
+// URLPath returns the URL path for the archive of the BuildJob.
+func URLPath(bj *crd.BuildJob) string {
+	return PathPrefix + string(bj.UID) + "/" + bj.Spec.Context.Local.Digest
+}
+
+// Uploaded returns true if the archive of the Local context has been uploaded.
+func Uploaded(bj *crd.BuildJob) bool {
+	d := bj.Spec.Context.Local.Digest
+	return d != "" && bj.Annotations[AnnotationUploaded] == d
+}
+
+func isLocal(bj *crd.BuildJob) bool {
+	return strings.EqualFold(string(bj.Spec.Context.Kind), string(crd.ContextKindLocal))
+}
+
+func isFinished(bj *crd.BuildJob) bool {
+	switch bj.Status.Phase {
+	case crd.BuildJobPhaseSucceeded, crd.BuildJobPhaseFailed, crd.BuildJobPhaseCanceled:
+		return true
+	}
+	return false
+}
+
+// Server is the context server.
+type Server struct {
+	dir       string
+	lister    listers.BuildJobLister
+	cbiClient clientset.Interface
+	// MaxBytes is the maximum size of an archive.
+	MaxBytes int64
+}
+
+// New creates the server. The archives are stored under dir.
+func New(dir string, lister listers.BuildJobLister, cbiClient clientset.Interface) (*Server, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "sha256"), 0700); err != nil {
+		return nil, err
+	}
+	return &Server{
+		dir:       dir,
+		lister:    lister,
+		cbiClient: cbiClient,
+		MaxBytes:  DefaultMaxBytes,
+	}, nil
+}
+
+// blobPath returns the path of the archive. digest needs to be validated.
+func (s *Server) blobPath(digest string) string {
+	return filepath.Join(s.dir, "sha256", strings.TrimPrefix(digest, "sha256:"))
+}
+
+// Has returns true if the archive is stored on this server.
+// The archive may be missing even if the BuildJob has AnnotationUploaded,
+// e.g. when the archive was stored on the previous leader.
+func (s *Server) Has(digest string) bool {
+	if !ValidDigest(digest) {
+		return false
+	}
+	_, err := os.Stat(s.blobPath(digest))
+	return err == nil
+}
+
+// buildJobByUID finds the unfinished Local BuildJob with the UID.
+func (s *Server) buildJobByUID(uid string) (*crd.BuildJob, error) {
+	bjs, err := s.lister.List(labels.Everything())
+	if err != nil {
+		return nil, err
+	}
+	for _, bj := range bjs {
+		if string(bj.UID) == uid && isLocal(bj) && !isFinished(bj) {
+			return bj, nil
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/healthz" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, PathPrefix) {
+		http.NotFound(w, r)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, PathPrefix), "/")
+	if len(parts) != 2 || !ValidDigest(parts[1]) {
+		http.NotFound(w, r)
+		return
+	}
+	uid, digest := parts[0], parts[1]
+	bj, err := s.buildJobByUID(uid)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// unknown UIDs are not distinguished from unknown digests, so as not to leak the UIDs.
+	if bj == nil || bj.Spec.Context.Local.Digest != digest {
+		http.NotFound(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		s.put(w, r, bj, digest)
+	case http.MethodGet, http.MethodHead:
+		s.get(w, r, digest)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request, digest string) {
+	f, err := os.Open(s.blobPath(digest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	http.ServeContent(w, r, "", st.ModTime(), f)
+}
+
+func (s *Server) put(w http.ResponseWriter, r *http.Request, bj *crd.BuildJob, digest string) {
+	if err := s.store(r.Body, digest); err != nil {
+		glog.Warningf("failed to store the context of buildjob %s/%s: %v", bj.Namespace, bj.Name, err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.markUploaded(bj, digest); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	glog.V(2).Infof("stored the context %s of buildjob %s/%s", digest, bj.Namespace, bj.Name)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// store stores r to the blob path after verifying the digest and the size.
+func (s *Server) store(r io.Reader, digest string) error {
+	p := s.blobPath(digest)
+	if _, err := os.Stat(p); err == nil {
+		// already uploaded (possibly by another BuildJob)
+		now := time.Now()
+		return os.Chtimes(p, now, now)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(r, s.MaxBytes+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if n > s.MaxBytes {
+		return fmt.Errorf("the archive exceeds the maximum size (%d bytes)", s.MaxBytes)
+	}
+	if actual := "sha256:" + hex.EncodeToString(h.Sum(nil)); actual != digest {
+		return fmt.Errorf("digest mismatch: expected %s, got %s", digest, actual)
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+// markUploaded sets AnnotationUploaded to the BuildJob, so that the controller creates the job.
+func (s *Server) markUploaded(bj *crd.BuildJob, digest string) error {
+	return setUploaded(s.cbiClient, bj, digest)
+}
+
+// ClearUploaded removes AnnotationUploaded from the BuildJob, so that the client
+// uploads the archive again.
+func ClearUploaded(cbiClient clientset.Interface, bj *crd.BuildJob) error {
+	return setUploaded(cbiClient, bj, "")
+}
+
+// setUploaded sets AnnotationUploaded to digest, or removes it when digest is empty.
+func setUploaded(cbiClient clientset.Interface, bj *crd.BuildJob, digest string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		latest, err := cbiClient.CbiV1alpha1().BuildJobs(bj.Namespace).Get(bj.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		if latest.UID != bj.UID {
+			return fmt.Errorf("buildjob %s/%s was recreated", bj.Namespace, bj.Name)
+		}
+		if latest.Annotations[AnnotationUploaded] == digest {
+			return nil
+		}
+		if digest == "" {
+			delete(latest.Annotations, AnnotationUploaded)
+		} else {
+			if latest.Annotations == nil {
+				latest.Annotations = make(map[string]string)
+			}
+			latest.Annotations[AnnotationUploaded] = digest
+		}
+		_, err = cbiClient.CbiV1alpha1().BuildJobs(bj.Namespace).Update(latest)
+		return err
+	})
+}
+
+// GC removes the archives that are not referred by any unfinished BuildJob
+// and have not been modified for gracePeriod.
+func (s *Server) GC(gracePeriod time.Duration) error {
+	bjs, err := s.lister.List(labels.Everything())
+	if err != nil {
+		return err
+	}
+	inUse := make(map[string]bool)
+	for _, bj := range bjs {
+		if isLocal(bj) && !isFinished(bj) && ValidDigest(bj.Spec.Context.Local.Digest) {
+			inUse[filepath.Base(s.blobPath(bj.Spec.Context.Local.Digest))] = true
+		}
+	}
+	blobsDir := filepath.Join(s.dir, "sha256")
+	fis, err := ioutil.ReadDir(blobsDir)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(-gracePeriod)
+	for _, fi := range fis {
+		if inUse[fi.Name()] || fi.ModTime().After(deadline) {
+			continue
+		}
+		glog.V(2).Infof("removing the unused context %s", fi.Name())
+		if err := os.Remove(filepath.Join(blobsDir, fi.Name())); err != nil {
+			glog.Warningf("failed to remove the unused context %s: %v", fi.Name(), err)
+		}
+	}
+	return nil
+}
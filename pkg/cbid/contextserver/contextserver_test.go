@@ -0,0 +1,185 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package contextserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/cache"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/client/clientset/versioned/fake"
+	listers "github.com/containerbuilding/cbi/pkg/client/listers/cbi/v1alpha1"
+)
+
+func digestOf(b []byte) string {
+	h := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(h[:])
+}
+
+func newTestServer(t *testing.T, bjs ...*crd.BuildJob) (*Server, *fake.Clientset, func()) {
+	dir, err := ioutil.TempDir("", "contextserver-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	client := fake.NewSimpleClientset()
+	for _, bj := range bjs {
+		indexer.Add(bj)
+		if _, err := client.CbiV1alpha1().BuildJobs(bj.Namespace).Create(bj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := New(dir, listers.NewBuildJobLister(indexer), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, client, func() { os.RemoveAll(dir) }
+}
+
+func newLocalBuildJob(name, uid, digest string) *crd.BuildJob {
+	return &crd.BuildJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      name,
+			UID:       types.UID(uid),
+		},
+		Spec: crd.BuildJobSpec{
+			Context: crd.Context{
+				Kind: crd.ContextKindLocal,
+				Local: crd.Local{
+					Digest: digest,
+				},
+			},
+		},
+	}
+}
+
+func do(s *Server, method, path string, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPutGet(t *testing.T) {
+	blob := []byte("dummy archive")
+	digest := digestOf(blob)
+	bj := newLocalBuildJob("foo", "uid-foo", digest)
+	s, client, cleanup := newTestServer(t, bj)
+	defer cleanup()
+
+	if rec := do(s, http.MethodGet, "/v1/contexts/uid-foo/"+digest, nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 before uploading, got %d", rec.Code)
+	}
+	if rec := do(s, http.MethodPut, "/v1/contexts/uid-bar/"+digest, blob); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for unknown UID, got %d", rec.Code)
+	}
+	if rec := do(s, http.MethodPut, "/v1/contexts/uid-foo/"+digest, []byte("tampered")); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for digest mismatch, got %d", rec.Code)
+	}
+	if rec := do(s, http.MethodPut, "/v1/contexts/uid-foo/"+digest, blob); rec.Code != http.StatusCreated {
+		t.Fatalf("expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	rec := do(s, http.MethodGet, "/v1/contexts/uid-foo/"+digest, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), blob) {
+		t.Fatalf("unexpected response: %d %q", rec.Code, rec.Body.String())
+	}
+	updated, err := client.CbiV1alpha1().BuildJobs("default").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Uploaded(updated) {
+		t.Fatalf("annotation %q is not set: %v", AnnotationUploaded, updated.Annotations)
+	}
+	if !s.Has(digest) {
+		t.Fatalf("%s is not stored", digest)
+	}
+	// e.g. the leader has changed
+	if err := os.Remove(s.blobPath(digest)); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(digest) {
+		t.Fatalf("%s is unexpectedly stored", digest)
+	}
+	if err := ClearUploaded(client, updated); err != nil {
+		t.Fatal(err)
+	}
+	cleared, err := client.CbiV1alpha1().BuildJobs("default").Get("foo", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Uploaded(cleared) {
+		t.Fatalf("annotation %q is not cleared: %v", AnnotationUploaded, cleared.Annotations)
+	}
+}
+
+func TestPutTooLarge(t *testing.T) {
+	blob := []byte("dummy archive")
+	digest := digestOf(blob)
+	s, _, cleanup := newTestServer(t, newLocalBuildJob("foo", "uid-foo", digest))
+	defer cleanup()
+	s.MaxBytes = 4
+	if rec := do(s, http.MethodPut, "/v1/contexts/uid-foo/"+digest, blob); rec.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestGC(t *testing.T) {
+	used, unused := []byte("used"), []byte("unused")
+	finished := newLocalBuildJob("bar", "uid-bar", digestOf(unused))
+	finished.Status.Phase = crd.BuildJobPhaseSucceeded
+	s, _, cleanup := newTestServer(t, newLocalBuildJob("foo", "uid-foo", digestOf(used)), finished)
+	defer cleanup()
+	old := time.Now().Add(-time.Hour)
+	for _, b := range [][]byte{used, unused} {
+		p := s.blobPath(digestOf(b))
+		if err := ioutil.WriteFile(p, b, 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(p, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.GC(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(s.blobPath(digestOf(used))); err != nil {
+		t.Errorf("used context was removed: %v", err)
+	}
+	if _, err := os.Stat(s.blobPath(digestOf(unused))); !os.IsNotExist(err) {
+		t.Errorf("unused context was not removed: %v", err)
+	}
+	fis, err := ioutil.ReadDir(filepath.Join(s.dir, "sha256"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fis) != 1 {
+		t.Errorf("expected 1 file, got %d", len(fis))
+	}
+}
@@ -0,0 +1,117 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang/glog"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/runtime"
+
+	cbiv1alpha1 "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/cbid/config"
+	"github.com/containerbuilding/cbi/pkg/cbid/contextserver"
+)
+
+// SetContextServerURL sets the URL of the context server, used by the job pods
+// for fetching the Local contexts. e.g. "http://cbid.cbi-system.svc:8081".
+// The Local contexts are rejected when the URL is empty.
+// SetContextServerURL needs to be called before Run.
+func (c *Controller) SetContextServerURL(u string) {
+	c.contextServerURL = strings.TrimSuffix(u, "/")
+}
+
+// ContextStore is the store of the uploaded Local contexts, i.e. *contextserver.Server.
+type ContextStore interface {
+	// Has returns true if the archive is stored.
+	Has(digest string) bool
+}
+
+// SetContextStore sets the store of the context server running in the same process.
+// When the uploaded archive is missing in the store (e.g. it was stored on the
+// previous leader), the upload annotation is cleared so that the client uploads it again.
+// SetContextStore needs to be called before Run.
+func (c *Controller) SetContextStore(s ContextStore) {
+	c.contextStore = s
+}
+
+func isLocalContext(buildJob *cbiv1alpha1.BuildJob) bool {
+	return strings.EqualFold(string(buildJob.Spec.Context.Kind), string(cbiv1alpha1.ContextKindLocal))
+}
+
+// localContextReady returns true if the job can be created for the BuildJob
+// with regard to the Local context.
+// false is returned until the client uploads the archive.
+func (c *Controller) localContextReady(buildJob *cbiv1alpha1.BuildJob) bool {
+	if !isLocalContext(buildJob) {
+		return true
+	}
+	if c.contextServerURL == "" {
+		c.recorder.Event(buildJob, corev1.EventTypeWarning, ReasonLocalContextUnavailable,
+			"Local context is not available because the context server is disabled")
+		return false
+	}
+	if !contextserver.Uploaded(buildJob) {
+		glog.V(4).Infof("waiting for the context of %s/%s to be uploaded", buildJob.Namespace, buildJob.Name)
+		return false
+	}
+	if c.contextStore != nil && !c.contextStore.Has(buildJob.Spec.Context.Local.Digest) {
+		c.recorder.Event(buildJob, corev1.EventTypeWarning, ReasonLocalContextMissing,
+			"The uploaded context is missing on cbid (e.g. after the leader change), waiting for the context to be uploaded again")
+		if err := contextserver.ClearUploaded(c.cbiclientset, buildJob); err != nil {
+			runtime.HandleError(fmt.Errorf("%s/%s: failed to clear the upload annotation: %v", buildJob.Namespace, buildJob.Name, err))
+		}
+		return false
+	}
+	return true
+}
+
+// applyLocalContextURL sets the URL of the Local context.
+func (c *Controller) applyLocalContextURL(buildJob *cbiv1alpha1.BuildJob) {
+	if isLocalContext(buildJob) {
+		buildJob.Spec.Context.Local.URL = c.contextServerURL + contextserver.URLPath(buildJob)
+	}
+}
+
+// applyContextCache sets the context cache in cfg.
+// The value set by the client is always overwritten, so that the client cannot
+// mount an arbitrary claim.
+func applyContextCache(buildJob *cbiv1alpha1.BuildJob, cfg *config.CBIDConfiguration) {
+	buildJob.Spec.Context.Cache = cfg.ContextCache
+	for i := range buildJob.Spec.Contexts {
+		buildJob.Spec.Contexts[i].Context.Cache = cfg.ContextCache
+	}
+}
+
+// applyContextLimits applies the context limits in cfg.
+// The limits of the BuildJob are kept only when they are lower than cfg.
+func applyContextLimits(buildJob *cbiv1alpha1.BuildJob, cfg *config.CBIDConfiguration) {
+	apply := func(l *cbiv1alpha1.ContextLimits) {
+		if m := cfg.ContextLimits.MaxBytes; m > 0 && (l.MaxBytes <= 0 || l.MaxBytes > m) {
+			l.MaxBytes = m
+		}
+		if m := cfg.ContextLimits.MaxFiles; m > 0 && (l.MaxFiles <= 0 || l.MaxFiles > m) {
+			l.MaxFiles = m
+		}
+	}
+	apply(&buildJob.Spec.Context.Limits)
+	for i := range buildJob.Spec.Contexts {
+		apply(&buildJob.Spec.Contexts[i].Context.Limits)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+
+	cbiv1alpha1 "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/cbid/config"
+)
+
+func TestApplyContextLimits(t *testing.T) {
+	cfg := config.Default()
+	cfg.ContextLimits = cbiv1alpha1.ContextLimits{MaxBytes: 1000, MaxFiles: 10}
+	testCases := []struct {
+		limits   cbiv1alpha1.ContextLimits
+		expected cbiv1alpha1.ContextLimits
+	}{
+		{
+			expected: cbiv1alpha1.ContextLimits{MaxBytes: 1000, MaxFiles: 10},
+		},
+		{
+			limits:   cbiv1alpha1.ContextLimits{MaxBytes: 100},
+			expected: cbiv1alpha1.ContextLimits{MaxBytes: 100, MaxFiles: 10},
+		},
+		{
+			limits:   cbiv1alpha1.ContextLimits{MaxBytes: 2000, MaxFiles: 5},
+			expected: cbiv1alpha1.ContextLimits{MaxBytes: 1000, MaxFiles: 5},
+		},
+	}
+	for i, tc := range testCases {
+		bj := &cbiv1alpha1.BuildJob{}
+		bj.Spec.Context.Limits = tc.limits
+		bj.Spec.Contexts = []cbiv1alpha1.NamedContext{{Name: "foo"}}
+		applyContextLimits(bj, cfg)
+		if bj.Spec.Context.Limits != tc.expected {
+			t.Fatalf("%d: expected %+v, got %+v", i, tc.expected, bj.Spec.Context.Limits)
+		}
+		if expected := (cbiv1alpha1.ContextLimits{MaxBytes: 1000, MaxFiles: 10}); bj.Spec.Contexts[0].Context.Limits != expected {
+			t.Fatalf("%d: expected %+v, got %+v", i, expected, bj.Spec.Contexts[0].Context.Limits)
+		}
+	}
+}
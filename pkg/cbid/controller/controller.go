@@ -21,6 +21,8 @@ package controller
 import (
 	"context"
 	"fmt"
+	"reflect"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -28,6 +30,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	kubeinformers "k8s.io/client-go/informers"
@@ -35,16 +38,21 @@ import (
 	"k8s.io/client-go/kubernetes/scheme"
 	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	batchlisters "k8s.io/client-go/listers/batch/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/workqueue"
 
 	cbiv1alpha1 "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/cbid/config"
+	"github.com/containerbuilding/cbi/pkg/cbid/logsink"
+	"github.com/containerbuilding/cbi/pkg/cbid/metrics"
 	"github.com/containerbuilding/cbi/pkg/cbid/pluginselector"
 	clientset "github.com/containerbuilding/cbi/pkg/client/clientset/versioned"
 	cbischeme "github.com/containerbuilding/cbi/pkg/client/clientset/versioned/scheme"
 	informers "github.com/containerbuilding/cbi/pkg/client/informers/externalversions"
 	listers "github.com/containerbuilding/cbi/pkg/client/listers/cbi/v1alpha1"
+	api "github.com/containerbuilding/cbi/pkg/plugin/api"
 )
 
 const controllerAgentName = "cbid"
@@ -62,6 +70,9 @@ const (
 	// MessageResourceSynced is the message used for an Event fired when a BuildJob
 	// is synced successfully
 	MessageResourceSynced = "BuildJob synced successfully"
+	// ErrPolicyViolation is used as part of the Event 'reason' when a BuildJob
+	// violates the policy in the configuration
+	ErrPolicyViolation = "PolicyViolation"
 )
 
 // Controller is the controller implementation for BuildJob resources
@@ -72,6 +83,8 @@ type Controller struct {
 	cbiclientset    clientset.Interface
 	jobsLister      batchlisters.JobLister
 	jobsSynced      cache.InformerSynced
+	podsLister      corelisters.PodLister
+	podsSynced      cache.InformerSynced
 	buildJobsLister listers.BuildJobLister
 	buildJobsSynced cache.InformerSynced
 
@@ -87,19 +100,35 @@ type Controller struct {
 
 	// CBI plugin selector
 	pluginSelector *pluginselector.PluginSelector
+
+	// configMu protects config, which can be reloaded during running.
+	configMu sync.RWMutex
+	config   *config.CBIDConfiguration
+
+	// logSink is nil when the build logs are not persisted.
+	logSink logsink.Sink
+
+	// contextServerURL is empty when the context server is disabled.
+	contextServerURL string
+	// contextStore is nil when the context server is disabled.
+	contextStore ContextStore
 }
 
-// New returns a new CBI controller
+// New returns a new CBI controller.
+// podInformerFactory should be filtered with LabelBuildJob, so as to avoid
+// watching unrelated pods.
 func New(
 	kubeclientset kubernetes.Interface,
 	cbiclientset clientset.Interface,
 	kubeInformerFactory kubeinformers.SharedInformerFactory,
+	podInformerFactory kubeinformers.SharedInformerFactory,
 	cbiInformerFactory informers.SharedInformerFactory,
 	pluginSelector *pluginselector.PluginSelector) *Controller {
 
-	// obtain references to shared index informers for the Job and BuildJob
+	// obtain references to shared index informers for the Job, Pod, and BuildJob
 	// types.
 	jobInformer := kubeInformerFactory.Batch().V1().Jobs()
+	podInformer := podInformerFactory.Core().V1().Pods()
 	buildJobInformer := cbiInformerFactory.Cbi().V1alpha1().BuildJobs()
 
 	// Create event broadcaster
@@ -117,11 +146,18 @@ func New(
 		cbiclientset:    cbiclientset,
 		jobsLister:      jobInformer.Lister(),
 		jobsSynced:      jobInformer.Informer().HasSynced,
+		podsLister:      podInformer.Lister(),
+		podsSynced:      podInformer.Informer().HasSynced,
 		buildJobsLister: buildJobInformer.Lister(),
 		buildJobsSynced: buildJobInformer.Informer().HasSynced,
 		workqueue:       workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "BuildJobs"),
 		recorder:        recorder,
 		pluginSelector:  pluginSelector,
+		config:          config.Default(),
+	}
+
+	if err := metrics.RegisterBuildJobCollector(controller.buildJobsLister); err != nil {
+		glog.Warningf("failed to register BuildJob metrics collector: %v", err)
 	}
 
 	glog.Info("Setting up event handlers")
@@ -152,10 +188,36 @@ func New(
 		},
 		DeleteFunc: controller.handleObject,
 	})
+	// Pods are watched for recording the events of the build lifecycle.
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: controller.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newPod := new.(*corev1.Pod)
+			oldPod := old.(*corev1.Pod)
+			if newPod.ResourceVersion == oldPod.ResourceVersion {
+				return
+			}
+			controller.handleObject(new)
+		},
+	})
 
 	return controller
 }
 
+// SetConfig replaces the configuration.
+// Only the reloadable settings (defaults and policy) are used by the controller.
+func (c *Controller) SetConfig(cfg *config.CBIDConfiguration) {
+	c.configMu.Lock()
+	c.config = cfg
+	c.configMu.Unlock()
+}
+
+func (c *Controller) getConfig() *config.CBIDConfiguration {
+	c.configMu.RLock()
+	defer c.configMu.RUnlock()
+	return c.config
+}
+
 // Run will set up the event handlers for types we are interested in, as well
 // as syncing informer caches and starting workers. It will block until stopCh
 // is closed, at which point it will shutdown the workqueue and wait for
@@ -169,7 +231,7 @@ func (c *Controller) Run(threadiness int, stopCh <-chan struct{}) error {
 
 	// Wait for the caches to be synced before starting workers
 	glog.Info("Waiting for informer caches to sync")
-	if ok := cache.WaitForCacheSync(stopCh, c.jobsSynced, c.buildJobsSynced); !ok {
+	if ok := cache.WaitForCacheSync(stopCh, c.jobsSynced, c.podsSynced, c.buildJobsSynced); !ok {
 		return fmt.Errorf("failed to wait for caches to sync")
 	}
 
@@ -240,6 +302,7 @@ func (c *Controller) processNextWorkItem() bool {
 	}(obj)
 
 	if err != nil {
+		metrics.SyncErrors.Inc()
 		runtime.HandleError(err)
 		return true
 	}
@@ -278,23 +341,33 @@ func (c *Controller) syncHandler(key string) error {
 		runtime.HandleError(fmt.Errorf("%s: invalid BuildJob spec", key))
 		return nil
 	}
-	pluginClient := c.pluginSelector.Select(*buildJob)
-	if pluginClient == nil {
-		runtime.HandleError(fmt.Errorf("%s: no plugin support this spec", key))
-		return nil
-	}
-
-	jobManifest, err := newJob(context.TODO(), pluginClient, buildJob)
-	if err != nil {
-		runtime.HandleError(fmt.Errorf("%s: invalid BuildJob spec: %v", key, err))
-		return nil
+	cfg := c.getConfig()
+
+	// Get the job for the BuildJob
+	job, err := c.jobsLister.Jobs(buildJob.Namespace).Get(jobName(buildJob))
+	if buildJob.Spec.Canceled {
+		switch {
+		case err == nil && jobFinished(job):
+			// too late to cancel; the status is updated as usual
+		case err == nil:
+			return c.cancelBuildJob(buildJob, job)
+		case errors.IsNotFound(err):
+			return c.cancelBuildJob(buildJob, nil)
+		default:
+			return err
+		}
 	}
-
-	// Get the job with the name specified in BuildJob.spec
-	job, err := c.jobsLister.Jobs(buildJob.Namespace).Get(jobManifest.ObjectMeta.Name)
 	// If the resource doesn't exist, we'll create it
 	if errors.IsNotFound(err) {
-		job, err = c.kubeclientset.BatchV1().Jobs(buildJob.Namespace).Create(jobManifest)
+		if buildJob.Status.Phase == cbiv1alpha1.BuildJobPhaseFailed {
+			// the job could not be created, or has been deleted after the failure
+			return nil
+		}
+		job, err = c.createJob(key, buildJob, cfg)
+		if job == nil && err == nil {
+			// the failure is already recorded
+			return nil
+		}
 	}
 
 	// If an error occurs during Get/Create, we'll requeue the item so we can
@@ -309,44 +382,184 @@ func (c *Controller) syncHandler(key string) error {
 	if !metav1.IsControlledBy(job, buildJob) {
 		msg := fmt.Sprintf(MessageResourceExists, job.Name)
 		c.recorder.Event(buildJob, corev1.EventTypeWarning, ErrResourceExists, msg)
-		return fmt.Errorf(msg)
+		return fmt.Errorf("%s", msg)
 	}
 
-	updateJob := false // TODO
-	if updateJob {
-		job, err = c.kubeclientset.BatchV1().Jobs(buildJob.Namespace).Update(jobManifest)
-	}
-
-	// If an error occurs during Update, we'll requeue the item so we can
-	// attempt processing again later. THis could have been caused by a
-	// temporary network failure, or any other transient reason.
+	pods, err := c.podsLister.Pods(job.Namespace).List(labels.SelectorFromSet(labels.Set{"controller-uid": string(job.UID)}))
 	if err != nil {
 		return err
 	}
 
 	// Finally, we update the status block of the BuildJob resource to reflect the
 	// current state of the world
-	err = c.updateBuildJobStatus(buildJob, job)
+	err = c.updateBuildJobStatus(buildJob, job, pods)
 	if err != nil {
 		return err
 	}
 
 	c.recorder.Event(buildJob, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	if ttl := cfg.Defaults.TTL.Duration; ttl > 0 {
+		return c.cleanupFinishedBuildJob(key, buildJob, job, ttl)
+	}
 	return nil
 }
 
-func (c *Controller) updateBuildJobStatus(buildJob *cbiv1alpha1.BuildJob, job *batchv1.Job) error {
+// cleanupFinishedBuildJob deletes the BuildJob when ttl has passed since the
+// job has finished. Otherwise the BuildJob is requeued for the expiration.
+// The job is deleted by the garbage collector.
+func (c *Controller) cleanupFinishedBuildJob(key string, buildJob *cbiv1alpha1.BuildJob, job *batchv1.Job, ttl time.Duration) error {
+	finished, ok := jobFinishTime(job)
+	if !ok {
+		return nil
+	}
+	if remaining := finished.Add(ttl).Sub(time.Now()); remaining > 0 {
+		c.workqueue.AddAfter(key, remaining)
+		return nil
+	}
+	glog.Infof("Deleting BuildJob '%s' finished at %v (TTL: %v)", key, finished, ttl)
+	propagation := metav1.DeletePropagationBackground
+	err := c.cbiclientset.CbiV1alpha1().BuildJobs(buildJob.Namespace).Delete(buildJob.Name,
+		&metav1.DeleteOptions{PropagationPolicy: &propagation})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// cancelBuildJob deletes the unfinished job (if any) of the canceled BuildJob,
+// and sets the phase to Canceled.
+func (c *Controller) cancelBuildJob(buildJob *cbiv1alpha1.BuildJob, job *batchv1.Job) error {
+	if job != nil && metav1.IsControlledBy(job, buildJob) {
+		propagation := metav1.DeletePropagationBackground
+		err := c.kubeclientset.BatchV1().Jobs(job.Namespace).Delete(job.Name,
+			&metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	if buildJob.Status.Phase == cbiv1alpha1.BuildJobPhaseCanceled {
+		return nil
+	}
+	buildJobCopy := buildJob.DeepCopy()
+	buildJobCopy.Status.Phase = cbiv1alpha1.BuildJobPhaseCanceled
+	if _, err := c.cbiclientset.CbiV1alpha1().BuildJobs(buildJob.Namespace).Update(buildJobCopy); err != nil {
+		return err
+	}
+	c.recorder.Event(buildJob, corev1.EventTypeNormal, ReasonBuildCanceled, "Build canceled")
+	return nil
+}
+
+// failBuildJob records the warning event, and sets the phase to Failed with
+// the reason and the message, so that the clients waiting for the BuildJob
+// can exit.
+func (c *Controller) failBuildJob(buildJob *cbiv1alpha1.BuildJob, reason, message string) error {
+	c.recorder.Event(buildJob, corev1.EventTypeWarning, reason, message)
+	buildJobCopy := buildJob.DeepCopy()
+	buildJobCopy.Status.Phase = cbiv1alpha1.BuildJobPhaseFailed
+	buildJobCopy.Status.Message = reason + ": " + message
+	_, err := c.cbiclientset.CbiV1alpha1().BuildJobs(buildJob.Namespace).Update(buildJobCopy)
+	return err
+}
+
+// createJob selects the plugin and creates the job for buildJob.
+// When the job cannot be created due to the BuildJob spec, the BuildJob is
+// marked as failed and nil is returned without an error.
+func (c *Controller) createJob(key string, buildJob *cbiv1alpha1.BuildJob, cfg *config.CBIDConfiguration) (*batchv1.Job, error) {
+	// The policy is checked only before creating the job, so that the
+	// existing jobs are not affected by reloading the policy.
+	if err := cfg.Policy.Check(buildJob); err != nil {
+		runtime.HandleError(fmt.Errorf("%s: %v", key, err))
+		return nil, c.failBuildJob(buildJob, ErrPolicyViolation, err.Error())
+	}
+	if !c.localContextReady(buildJob) {
+		return nil, nil
+	}
+	pluginClient, pluginInfo, err := c.pluginSelector.Select(*buildJob)
+	if err != nil {
+		// requeue, keeping the BuildJob pending until the plugin gets healthy again
+		return nil, err
+	}
+	if pluginClient == nil {
+		runtime.HandleError(fmt.Errorf("%s: no plugin support this spec", key))
+		return nil, c.failBuildJob(buildJob, ReasonNoPluginMatched, noPluginMatchedMessage(buildJob))
+	}
+	pluginName := pluginInfo.Labels[api.LPluginName]
+	c.recordPluginSelected(buildJob, pluginInfo)
+
+	pluginBuildJob := applyDefaults(buildJob, cfg)
+	c.applyLocalContextURL(pluginBuildJob)
+	applyContextCache(pluginBuildJob, cfg)
+	applyContextLimits(pluginBuildJob, cfg)
+	jobManifest, err := newJob(context.TODO(), pluginClient, pluginBuildJob)
+	if err != nil {
+		if isTransientError(err) {
+			return nil, err
+		}
+		runtime.HandleError(fmt.Errorf("%s: invalid BuildJob spec: %v", key, err))
+		return nil, c.failBuildJob(buildJob, ReasonPluginRejectedSpec, pluginRejectedSpecMessage(pluginName, err))
+	}
+	applyJobDefaults(jobManifest, cfg)
+	setJobPlugin(jobManifest, pluginName)
+
+	job, err := c.kubeclientset.BatchV1().Jobs(buildJob.Namespace).Create(jobManifest)
+	if err != nil {
+		return nil, err
+	}
+	c.recordJobCreated(buildJob, job)
+	return job, nil
+}
+
+func (c *Controller) updateBuildJobStatus(buildJob *cbiv1alpha1.BuildJob, job *batchv1.Job, pods []*corev1.Pod) error {
+	pluginName := jobPlugin(job)
+	if pluginName == "" {
+		// the job was created without the annotation
+		pluginName = buildJob.Status.Plugin
+	}
+	status := cbiv1alpha1.BuildJobStatus{
+		Job:    job.Name,
+		Phase:  jobPhase(job, pods),
+		Plugin: pluginName,
+		Log:    buildJob.Status.Log,
+	}
+	if status.Phase == cbiv1alpha1.BuildJobPhaseFailed {
+		status.Message = buildFailedMessage(job, pods)
+	}
+	// logErr is returned after updating the status, so that the log is
+	// persisted on retrying.
+	var logErr error
+	if c.logSink != nil && status.Log.Kind == "" {
+		switch status.Phase {
+		case cbiv1alpha1.BuildJobPhaseSucceeded, cbiv1alpha1.BuildJobPhaseFailed:
+			status.Log, logErr = c.persistLog(buildJob, job, pods)
+		}
+	}
+	if reflect.DeepEqual(buildJob.Status, status) {
+		return logErr
+	}
 	// NEVER modify objects from the store. It's a read-only, local cache.
 	// You can use DeepCopy() to make a deep copy of original object and modify this copy
 	// Or create a copy manually for better performance
 	buildJobCopy := buildJob.DeepCopy()
-	buildJobCopy.Status.Job = job.Name
+	buildJobCopy.Status = status
 	// Until #38113 is merged, we must use Update instead of UpdateStatus to
 	// update the Status block of the BuildJob resource. UpdateStatus will not
 	// allow changes to the Spec of the resource, which is ideal for ensuring
 	// nothing other than resource status has been updated.
-	_, err := c.cbiclientset.CbiV1alpha1().BuildJobs(buildJob.Namespace).Update(buildJobCopy)
-	return err
+	if _, err := c.cbiclientset.CbiV1alpha1().BuildJobs(buildJob.Namespace).Update(buildJobCopy); err != nil {
+		return err
+	}
+	if status.Phase != buildJob.Status.Phase {
+		c.recordPhaseTransition(buildJob, job, pods, status.Phase)
+		// observed only after the update succeeds, so that the retries do not observe the build again
+		switch status.Phase {
+		case cbiv1alpha1.BuildJobPhaseSucceeded, cbiv1alpha1.BuildJobPhaseFailed:
+			if d, ok := jobDuration(job); ok {
+				metrics.BuildDuration.WithLabelValues(pluginName, string(status.Phase)).Observe(d.Seconds())
+			}
+		}
+	}
+	return logErr
 }
 
 // enqueueBuildJob takes a BuildJob resource and converts it into a namespace/name
@@ -385,6 +598,16 @@ func (c *Controller) handleObject(obj interface{}) {
 	}
 	glog.V(4).Infof("Processing object: %s", object.GetName())
 	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
+		// Pods are owned by Jobs, which are owned by BuildJobs.
+		if ownerRef.Kind == "Job" {
+			job, err := c.jobsLister.Jobs(object.GetNamespace()).Get(ownerRef.Name)
+			if err != nil {
+				glog.V(4).Infof("ignoring orphaned object '%s' of Job '%s'", object.GetSelfLink(), ownerRef.Name)
+				return
+			}
+			c.handleObject(job)
+			return
+		}
 		// If this object is not owned by a BuildJob, we should not do anything more
 		// with it.
 		if ownerRef.Kind != "BuildJob" {
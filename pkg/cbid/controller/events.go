@@ -0,0 +1,127 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+
+	cbiv1alpha1 "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	api "github.com/containerbuilding/cbi/pkg/plugin/api"
+)
+
+// Event reasons recorded on BuildJobs across the build lifecycle.
+const (
+	// ReasonPluginSelected is used when a plugin is selected for the BuildJob.
+	ReasonPluginSelected = "PluginSelected"
+	// ReasonNoPluginMatched is used when no plugin can handle the BuildJob.
+	ReasonNoPluginMatched = "NoPluginMatched"
+	// ReasonPluginRejectedSpec is used when the plugin failed to generate the job for the BuildJob.
+	ReasonPluginRejectedSpec = "PluginRejectedSpec"
+	// ReasonJobCreated is used when the job is created.
+	ReasonJobCreated = "JobCreated"
+	// ReasonPodStarted is used when the containers of the job pod are started.
+	ReasonPodStarted = "PodStarted"
+	// ReasonBuildSucceeded is used when the job has completed successfully.
+	ReasonBuildSucceeded = "BuildSucceeded"
+	// ReasonBuildFailed is used when the job has failed.
+	ReasonBuildFailed = "BuildFailed"
+	// ReasonBuildCanceled is used when the build is canceled.
+	ReasonBuildCanceled = "BuildCanceled"
+	// ReasonImagePushed is used when the job that pushes the image has completed successfully.
+	ReasonImagePushed = "ImagePushed"
+	// ReasonLocalContextUnavailable is used when the BuildJob has the Local context
+	// but the context server is disabled.
+	ReasonLocalContextUnavailable = "LocalContextUnavailable"
+	// ReasonLocalContextMissing is used when the uploaded Local context is missing
+	// on the context server, and needs to be uploaded again.
+	ReasonLocalContextMissing = "LocalContextMissing"
+)
+
+func (c *Controller) recordPluginSelected(buildJob *cbiv1alpha1.BuildJob, info *api.InfoResponse) {
+	c.recorder.Eventf(buildJob, corev1.EventTypeNormal, ReasonPluginSelected,
+		"Selected plugin %q (labels: %s)", info.Labels[api.LPluginName], labels.Set(info.Labels).String())
+}
+
+func noPluginMatchedMessage(buildJob *cbiv1alpha1.BuildJob) string {
+	return fmt.Sprintf("No plugin can handle language %q, context %q, and plugin selector %q",
+		buildJob.Spec.Language.Kind, buildJob.Spec.Context.Kind, buildJob.Spec.PluginSelector)
+}
+
+func pluginRejectedSpecMessage(pluginName string, err error) string {
+	return fmt.Sprintf("Plugin %q rejected the spec: %v", pluginName, err)
+}
+
+func (c *Controller) recordJobCreated(buildJob *cbiv1alpha1.BuildJob, job *batchv1.Job) {
+	c.recorder.Eventf(buildJob, corev1.EventTypeNormal, ReasonJobCreated, "Created job %q", job.Name)
+}
+
+// recordPhaseTransition records the events corresponding to the transition of
+// the BuildJob phase to newPhase.
+func (c *Controller) recordPhaseTransition(buildJob *cbiv1alpha1.BuildJob, job *batchv1.Job, pods []*corev1.Pod, newPhase cbiv1alpha1.BuildJobPhase) {
+	switch newPhase {
+	case cbiv1alpha1.BuildJobPhaseRunning:
+		if pod := startedPod(pods); pod != nil {
+			c.recorder.Eventf(buildJob, corev1.EventTypeNormal, ReasonPodStarted,
+				"Started pod %q on node %q", pod.Name, pod.Spec.NodeName)
+		}
+	case cbiv1alpha1.BuildJobPhaseSucceeded:
+		msg := "Build succeeded"
+		if t := lastTermination(pods, false); t != nil {
+			msg += fmt.Sprintf(": container %q of pod %q exited with code %d", t.container, t.pod, t.state.ExitCode)
+		}
+		c.recorder.Event(buildJob, corev1.EventTypeNormal, ReasonBuildSucceeded, msg)
+		if buildJob.Spec.Registry.Push {
+			c.recorder.Eventf(buildJob, corev1.EventTypeNormal, ReasonImagePushed,
+				"Pushed image %q", buildJob.Spec.Registry.Target)
+		}
+	case cbiv1alpha1.BuildJobPhaseFailed:
+		c.recorder.Event(buildJob, corev1.EventTypeWarning, ReasonBuildFailed, buildFailedMessage(job, pods))
+	}
+}
+
+// buildFailedMessage returns the message containing the exit code, the reason and
+// the termination message of the failed container, and the reason of the job failure.
+func buildFailedMessage(job *batchv1.Job, pods []*corev1.Pod) string {
+	var details []string
+	if t := lastTermination(pods, true); t != nil {
+		s := fmt.Sprintf("container %q of pod %q exited with code %d", t.container, t.pod, t.state.ExitCode)
+		if t.state.Reason != "" {
+			s += fmt.Sprintf(" (reason: %s)", t.state.Reason)
+		}
+		// e.g. the error of cbipluginhelper, such as the signature verification failure
+		if m := strings.TrimSpace(t.state.Message); m != "" {
+			s += ": " + m
+		}
+		details = append(details, s)
+	}
+	if reason, message := jobFailure(job); reason != "" {
+		s := "job failed (reason: " + reason + ")"
+		if message != "" {
+			s += ": " + message
+		}
+		details = append(details, s)
+	}
+	if len(details) == 0 {
+		return "Build failed"
+	}
+	return "Build failed: " + strings.Join(details, "; ")
+}
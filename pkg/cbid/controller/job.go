@@ -19,17 +19,32 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"sort"
+	"time"
 
 	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 
+	"github.com/containerbuilding/cbi/pkg/apis/cbi"
 	cbiv1alpha1 "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/cbid/config"
 	api "github.com/containerbuilding/cbi/pkg/plugin/api"
 )
 
+const (
+	// LabelBuildJob is the label set to the jobs and the pods created by the controller.
+	// The value is the name of the BuildJob.
+	LabelBuildJob = cbi.GroupName + "/buildjob"
+	// annotationPlugin is the annotation set to the jobs.
+	// The value is the name of the plugin that generated the job.
+	annotationPlugin = cbi.GroupName + "/plugin"
+)
+
 func jobName(buildJob *cbiv1alpha1.BuildJob) string {
 	return buildJob.Name + "-job"
 }
@@ -38,6 +53,9 @@ func objectMeta(buildJob *cbiv1alpha1.BuildJob) metav1.ObjectMeta {
 	return metav1.ObjectMeta{
 		Name:      jobName(buildJob),
 		Namespace: buildJob.Namespace,
+		Labels: map[string]string{
+			LabelBuildJob: buildJob.Name,
+		},
 		OwnerReferences: []metav1.OwnerReference{
 			*metav1.NewControllerRef(buildJob, schema.GroupVersionKind{
 				Group:   cbiv1alpha1.SchemeGroupVersion.Group,
@@ -65,6 +83,10 @@ func newJob(ctx context.Context, pluginClient api.PluginClient, buildJob *cbiv1a
 	if err := json.Unmarshal(specRes.PodTemplateSpecJson, &pts); err != nil {
 		return nil, err
 	}
+	if pts.ObjectMeta.Labels == nil {
+		pts.ObjectMeta.Labels = make(map[string]string)
+	}
+	pts.ObjectMeta.Labels[LabelBuildJob] = buildJob.Name
 	j := &batchv1.Job{
 		ObjectMeta: objectMeta(buildJob),
 		Spec: batchv1.JobSpec{
@@ -73,3 +95,160 @@ func newJob(ctx context.Context, pluginClient api.PluginClient, buildJob *cbiv1a
 	}
 	return j, nil
 }
+
+// isTransientError returns true if err returned from newJob is likely to be
+// caused by the plugin connection rather than the BuildJob spec.
+func isTransientError(err error) bool {
+	switch status.Code(errors.Cause(err)) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		return true
+	}
+	return false
+}
+
+// jobPlugin returns the name of the plugin that generated the job.
+func jobPlugin(job *batchv1.Job) string {
+	return job.ObjectMeta.Annotations[annotationPlugin]
+}
+
+func setJobPlugin(job *batchv1.Job, pluginName string) {
+	if job.ObjectMeta.Annotations == nil {
+		job.ObjectMeta.Annotations = make(map[string]string)
+	}
+	job.ObjectMeta.Annotations[annotationPlugin] = pluginName
+}
+
+// jobPhase returns the BuildJob phase corresponding to the job status and its pods.
+// The phase is Running only when the containers of a pod have been started.
+func jobPhase(job *batchv1.Job, pods []*corev1.Pod) cbiv1alpha1.BuildJobPhase {
+	for _, c := range job.Status.Conditions {
+		if c.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch c.Type {
+		case batchv1.JobComplete:
+			return cbiv1alpha1.BuildJobPhaseSucceeded
+		case batchv1.JobFailed:
+			return cbiv1alpha1.BuildJobPhaseFailed
+		}
+	}
+	if job.Status.Active > 0 {
+		if startedPod(pods) != nil {
+			return cbiv1alpha1.BuildJobPhaseRunning
+		}
+	}
+	return cbiv1alpha1.BuildJobPhasePending
+}
+
+// jobFinished returns true if the job has completed or failed.
+func jobFinished(job *batchv1.Job) bool {
+	switch jobPhase(job, nil) {
+	case cbiv1alpha1.BuildJobPhaseSucceeded, cbiv1alpha1.BuildJobPhaseFailed:
+		return true
+	}
+	return false
+}
+
+// startedPod returns the latest pod whose containers have been started.
+func startedPod(pods []*corev1.Pod) *corev1.Pod {
+	for _, pod := range sortedPods(pods) {
+		switch pod.Status.Phase {
+		case corev1.PodRunning, corev1.PodSucceeded, corev1.PodFailed:
+			return pod
+		}
+	}
+	return nil
+}
+
+// sortedPods returns the copy of pods sorted by the creation time, the latest first.
+func sortedPods(pods []*corev1.Pod) []*corev1.Pod {
+	res := make([]*corev1.Pod, len(pods))
+	copy(res, pods)
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[j].CreationTimestamp.Before(&res[i].CreationTimestamp)
+	})
+	return res
+}
+
+// containerTermination is the termination state of a container of the job.
+type containerTermination struct {
+	pod       string
+	container string
+	state     *corev1.ContainerStateTerminated
+}
+
+// lastTermination returns the termination state of the container of the latest pod.
+// When failed is true, the state of the failed container (including the init
+// containers) is returned.
+// Otherwise the state of the succeeded container is returned.
+func lastTermination(pods []*corev1.Pod, failed bool) *containerTermination {
+	for _, pod := range sortedPods(pods) {
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for i := len(statuses) - 1; i >= 0; i-- {
+			st := statuses[i].State.Terminated
+			if st == nil {
+				continue
+			}
+			if (st.ExitCode != 0) == failed {
+				return &containerTermination{
+					pod:       pod.Name,
+					container: statuses[i].Name,
+					state:     st,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jobFailure returns the reason and the message of the JobFailed condition.
+func jobFailure(job *batchv1.Job) (string, string) {
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return c.Reason, c.Message
+		}
+	}
+	return "", ""
+}
+
+// jobDuration returns the duration of the finished job.
+func jobDuration(job *batchv1.Job) (time.Duration, bool) {
+	if job.Status.StartTime == nil {
+		return 0, false
+	}
+	finished, ok := jobFinishTime(job)
+	if !ok {
+		return 0, false
+	}
+	return finished.Sub(job.Status.StartTime.Time), true
+}
+
+// jobFinishTime returns the time when the job has completed or failed.
+func jobFinishTime(job *batchv1.Job) (time.Time, bool) {
+	if t := job.Status.CompletionTime; t != nil {
+		return t.Time, true
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return c.LastTransitionTime.Time, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// applyDefaults returns a copy of buildJob with the default values in cfg.
+func applyDefaults(buildJob *cbiv1alpha1.BuildJob, cfg *config.CBIDConfiguration) *cbiv1alpha1.BuildJob {
+	bj := buildJob.DeepCopy()
+	if bj.Spec.Registry.SecretRef.Name == "" {
+		bj.Spec.Registry.SecretRef = cfg.Defaults.RegistrySecretRef
+	}
+	return bj
+}
+
+// applyJobDefaults sets the default values in cfg to the job manifest.
+func applyJobDefaults(job *batchv1.Job, cfg *config.CBIDConfiguration) {
+	if d := cfg.Defaults.Timeout.Duration; d > 0 && job.Spec.ActiveDeadlineSeconds == nil {
+		secs := int64(d / time.Second)
+		job.Spec.ActiveDeadlineSeconds = &secs
+	}
+}
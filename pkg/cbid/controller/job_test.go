@@ -0,0 +1,167 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"testing"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	cbiv1alpha1 "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+func TestJobPhase(t *testing.T) {
+	start := metav1.NewTime(time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC))
+	end := metav1.NewTime(start.Add(42 * time.Second))
+	testCases := []struct {
+		name             string
+		status           batchv1.JobStatus
+		pods             []*corev1.Pod
+		expectedPhase    cbiv1alpha1.BuildJobPhase
+		expectedDuration time.Duration
+	}{
+		{
+			name:          "pending",
+			status:        batchv1.JobStatus{},
+			expectedPhase: cbiv1alpha1.BuildJobPhasePending,
+		},
+		{
+			name: "pod pending",
+			status: batchv1.JobStatus{
+				StartTime: &start,
+				Active:    1,
+			},
+			pods: []*corev1.Pod{
+				{Status: corev1.PodStatus{Phase: corev1.PodPending}},
+			},
+			expectedPhase: cbiv1alpha1.BuildJobPhasePending,
+		},
+		{
+			name: "running",
+			status: batchv1.JobStatus{
+				StartTime: &start,
+				Active:    1,
+			},
+			pods: []*corev1.Pod{
+				{Status: corev1.PodStatus{Phase: corev1.PodRunning}},
+			},
+			expectedPhase: cbiv1alpha1.BuildJobPhaseRunning,
+		},
+		{
+			name: "succeeded",
+			status: batchv1.JobStatus{
+				StartTime:      &start,
+				CompletionTime: &end,
+				Succeeded:      1,
+				Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobComplete, Status: corev1.ConditionTrue, LastTransitionTime: end},
+				},
+			},
+			expectedPhase:    cbiv1alpha1.BuildJobPhaseSucceeded,
+			expectedDuration: 42 * time.Second,
+		},
+		{
+			name: "failed",
+			status: batchv1.JobStatus{
+				StartTime: &start,
+				Failed:    1,
+				Conditions: []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, LastTransitionTime: end},
+				},
+			},
+			expectedPhase:    cbiv1alpha1.BuildJobPhaseFailed,
+			expectedDuration: 42 * time.Second,
+		},
+	}
+	for _, tc := range testCases {
+		job := &batchv1.Job{Status: tc.status}
+		if phase := jobPhase(job, tc.pods); phase != tc.expectedPhase {
+			t.Fatalf("%s: expected phase %q, got %q", tc.name, tc.expectedPhase, phase)
+		}
+		d, ok := jobDuration(job)
+		if ok != (tc.expectedDuration != 0) {
+			t.Fatalf("%s: unexpected ok=%v", tc.name, ok)
+		}
+		if d != tc.expectedDuration {
+			t.Fatalf("%s: expected duration %v, got %v", tc.name, tc.expectedDuration, d)
+		}
+	}
+}
+
+func TestBuildFailedMessage(t *testing.T) {
+	older := metav1.NewTime(time.Date(2018, 4, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(older.Add(time.Minute))
+	terminated := func(exitCode int32, reason, message string) corev1.ContainerState {
+		return corev1.ContainerState{
+			Terminated: &corev1.ContainerStateTerminated{ExitCode: exitCode, Reason: reason, Message: message},
+		}
+	}
+	job := &batchv1.Job{
+		Status: batchv1.JobStatus{
+			Conditions: []batchv1.JobCondition{
+				{
+					Type:    batchv1.JobFailed,
+					Status:  corev1.ConditionTrue,
+					Reason:  "BackoffLimitExceeded",
+					Message: "Job has reach the specified backoff limit",
+				},
+			},
+		},
+	}
+	pods := []*corev1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod0", CreationTimestamp: older},
+			Status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{Name: "init", State: terminated(128, "ContainerCannotRun", "")},
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod1", CreationTimestamp: newer},
+			Status: corev1.PodStatus{
+				InitContainerStatuses: []corev1.ContainerStatus{
+					{Name: "init", State: terminated(0, "Completed", "")},
+				},
+				ContainerStatuses: []corev1.ContainerStatus{
+					{Name: "build", State: terminated(1, "Error", "")},
+				},
+			},
+		},
+	}
+	expected := `Build failed: container "build" of pod "pod1" exited with code 1 (reason: Error); ` +
+		`job failed (reason: BackoffLimitExceeded): Job has reach the specified backoff limit`
+	if msg := buildFailedMessage(job, pods); msg != expected {
+		t.Fatalf("expected %q, got %q", expected, msg)
+	}
+	expected = `Build failed: container "init" of pod "pod0" exited with code 128 (reason: ContainerCannotRun); ` +
+		`job failed (reason: BackoffLimitExceeded): Job has reach the specified backoff limit`
+	if msg := buildFailedMessage(job, pods[:1]); msg != expected {
+		t.Fatalf("expected %q, got %q", expected, msg)
+	}
+	// the termination message is appended
+	pods[0].Status.InitContainerStatuses[0].State = terminated(1, "Error", "signature verification of commit HEAD failed: error: no signature found\n")
+	expected = `Build failed: container "init" of pod "pod0" exited with code 1 (reason: Error): ` +
+		`signature verification of commit HEAD failed: error: no signature found; ` +
+		`job failed (reason: BackoffLimitExceeded): Job has reach the specified backoff limit`
+	if msg := buildFailedMessage(job, pods[:1]); msg != expected {
+		t.Fatalf("expected %q, got %q", expected, msg)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/golang/glog"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+
+	cbiv1alpha1 "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/cbid/logsink"
+)
+
+// ReasonLogPersisted is used when the build log is persisted to the log sink.
+const ReasonLogPersisted = "LogPersisted"
+
+// maxCollectedLogBytes limits the log collected for the sinks without the size
+// limit, so that a chatty build does not exhaust the memory of cbid.
+// The head of the log is truncated.
+const maxCollectedLogBytes = 64 * 1024 * 1024
+
+// SetLogSink sets the sink for persisting the build logs.
+// SetLogSink needs to be called before Run.
+func (c *Controller) SetLogSink(sink logsink.Sink) {
+	c.logSink = sink
+}
+
+// persistLog collects the logs of the pods and stores them to the log sink.
+// An empty location is returned when there is no pod.
+func (c *Controller) persistLog(buildJob *cbiv1alpha1.BuildJob, job *batchv1.Job, pods []*corev1.Pod) (cbiv1alpha1.BuildJobLog, error) {
+	if len(pods) == 0 {
+		glog.Warningf("no pod found for job %s/%s, not persisting the log", job.Namespace, job.Name)
+		return cbiv1alpha1.BuildJobLog{}, nil
+	}
+	maxBytes := logsink.MaxBytes(c.logSink)
+	if maxBytes <= 0 || maxBytes > maxCollectedLogBytes {
+		maxBytes = maxCollectedLogBytes
+	}
+	log := c.collectLog(pods, maxBytes)
+	loc, err := c.logSink.Store(context.TODO(), buildJob, log)
+	if err != nil {
+		return cbiv1alpha1.BuildJobLog{}, err
+	}
+	c.recorder.Eventf(buildJob, corev1.EventTypeNormal, ReasonLogPersisted, "Persisted the build log (%d bytes) to %s", len(log), loc.Kind)
+	return loc, nil
+}
+
+// collectLog returns the concatenated logs of the init containers and the
+// containers of the pods, the oldest pod first.
+// The logs are streamed, and only the last max bytes are kept in the memory.
+// The failures of getting the logs are written to the result instead of being returned.
+func (c *Controller) collectLog(pods []*corev1.Pod, max int) []byte {
+	sorted := sortedPods(pods)
+	b := logsink.NewTailBuffer(max)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		pod := sorted[i]
+		containers := append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+		for _, container := range containers {
+			fmt.Fprintf(b, "==> pod/%s container/%s <==\n", pod.Name, container.Name)
+			if err := c.copyLog(b, pod, container.Name); err != nil {
+				b.EnsureNewline()
+				fmt.Fprintf(b, "[cbid: failed to get the log: %v]\n", err)
+				continue
+			}
+			b.EnsureNewline()
+		}
+	}
+	return b.Bytes()
+}
+
+func (c *Controller) copyLog(w io.Writer, pod *corev1.Pod, container string) error {
+	rc, err := c.kubeclientset.CoreV1().Pods(pod.Namespace).GetLogs(pod.Name,
+		&corev1.PodLogOptions{Container: container}).Stream()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	_, err = io.Copy(w, rc)
+	return err
+}
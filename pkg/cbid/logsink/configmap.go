@@ -0,0 +1,84 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logsink
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+// ConfigMapKey is the key of the log in the ConfigMap.
+const ConfigMapKey = "build.log"
+
+// ConfigMap stores the log in the ConfigMap "<name>-log" owned by the BuildJob,
+// so that the ConfigMap is garbage-collected with the BuildJob.
+type ConfigMap struct {
+	Client kubernetes.Interface
+	// MaxBytes is the size limit. The head of the log is truncated.
+	MaxBytes int
+}
+
+func (s *ConfigMap) Store(ctx context.Context, buildJob *crd.BuildJob, log []byte) (crd.BuildJobLog, error) {
+	log, truncated := truncateHead(log, s.MaxBytes)
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      buildJob.Name + "-log",
+			Namespace: buildJob.Namespace,
+			OwnerReferences: []metav1.OwnerReference{
+				*metav1.NewControllerRef(buildJob, schema.GroupVersionKind{
+					Group:   crd.SchemeGroupVersion.Group,
+					Version: crd.SchemeGroupVersion.Version,
+					Kind:    "BuildJob",
+				}),
+			},
+		},
+		Data: map[string]string{
+			ConfigMapKey: string(log),
+		},
+	}
+	configMaps := s.Client.CoreV1().ConfigMaps(buildJob.Namespace)
+	_, err := configMaps.Create(cm)
+	if errors.IsAlreadyExists(err) {
+		var existing *corev1.ConfigMap
+		existing, err = configMaps.Get(cm.Name, metav1.GetOptions{})
+		if err == nil {
+			if !metav1.IsControlledBy(existing, buildJob) {
+				return crd.BuildJobLog{}, errors.NewAlreadyExists(corev1.Resource("configmaps"), cm.Name)
+			}
+			existing.Data = cm.Data
+			_, err = configMaps.Update(existing)
+		}
+	}
+	if err != nil {
+		return crd.BuildJobLog{}, err
+	}
+	return crd.BuildJobLog{
+		Kind: crd.BuildJobLogKindConfigMap,
+		ConfigMap: crd.BuildJobLogConfigMap{
+			Name: cm.Name,
+			Key:  ConfigMapKey,
+		},
+		Truncated: truncated,
+	}, nil
+}
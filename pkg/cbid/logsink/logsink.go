@@ -0,0 +1,163 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logsink provides the sinks for persisting the build logs after the
+// job pods are gone.
+package logsink
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"k8s.io/client-go/kubernetes"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/cbid/config"
+	"github.com/containerbuilding/cbi/pkg/s3"
+)
+
+// Sink stores the build logs.
+type Sink interface {
+	// Store stores the log of the BuildJob, and returns the location.
+	// Store may be called multiple times for a BuildJob, e.g. on retrying.
+	Store(ctx context.Context, buildJob *crd.BuildJob, log []byte) (crd.BuildJobLog, error)
+}
+
+// New creates the sink. nil is returned when cfg.Kind is empty.
+// defaultNamespace is used for the PersistentVolumeClaim without namespace.
+func New(cfg config.LogSink, kubeClient kubernetes.Interface, defaultNamespace string) (Sink, error) {
+	switch cfg.Kind {
+	case "":
+		return nil, nil
+	case crd.BuildJobLogKindConfigMap:
+		return &ConfigMap{
+			Client:   kubeClient,
+			MaxBytes: cfg.ConfigMap.MaxBytes,
+		}, nil
+	case crd.BuildJobLogKindPersistentVolumeClaim:
+		ns := cfg.PersistentVolumeClaim.Namespace
+		if ns == "" {
+			ns = defaultNamespace
+		}
+		return &PersistentVolumeClaim{
+			Namespace: ns,
+			ClaimName: cfg.PersistentVolumeClaim.ClaimName,
+			MountPath: cfg.PersistentVolumeClaim.MountPath,
+		}, nil
+	case crd.BuildJobLogKindS3:
+		client, err := s3.New(s3.Config{
+			Endpoint:    cfg.S3.Endpoint,
+			Region:      cfg.S3.Region,
+			Credentials: s3.CredentialsFromEnv(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		return &S3{
+			Client:   client,
+			Endpoint: cfg.S3.Endpoint,
+			Bucket:   cfg.S3.Bucket,
+			Prefix:   cfg.S3.Prefix,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown log sink: %q", cfg.Kind)
+}
+
+// objectPath returns the path like "namespace/name-uid.log".
+// The UID is included so that the logs of the recreated BuildJobs do not conflict.
+func objectPath(buildJob *crd.BuildJob) string {
+	return path.Join(buildJob.Namespace, fmt.Sprintf("%s-%s.log", buildJob.Name, buildJob.UID))
+}
+
+// truncateHead truncates the head of log so that the size does not exceed max.
+// The tail is kept because it usually contains the error.
+func truncateHead(log []byte, max int) ([]byte, bool) {
+	return truncateHeadDropped(log, max, 0)
+}
+
+// truncateHeadDropped is similar to truncateHead, but dropped bytes have been
+// already truncated from the head of log.
+func truncateHeadDropped(log []byte, max, dropped int) ([]byte, bool) {
+	if dropped == 0 && len(log) <= max {
+		return log, false
+	}
+	if len(log) > max {
+		dropped += len(log) - max
+		log = log[len(log)-max:]
+	}
+	keep := len(log)
+	var header []byte
+	// the header length depends on the number of the truncated bytes
+	for i := 0; i < 3; i++ {
+		header = []byte(fmt.Sprintf("[cbid: truncated %d bytes]\n", dropped+len(log)-keep))
+		keep = max - len(header)
+		if keep > len(log) {
+			keep = len(log)
+		}
+	}
+	if keep <= 0 {
+		return log, true
+	}
+	header = []byte(fmt.Sprintf("[cbid: truncated %d bytes]\n", dropped+len(log)-keep))
+	return append(header, log[len(log)-keep:]...), true
+}
+
+// MaxBytes returns the size limit of the log stored in sink.
+// Zero is returned when the sink has no limit.
+func MaxBytes(sink Sink) int {
+	if s, ok := sink.(*ConfigMap); ok {
+		return s.MaxBytes
+	}
+	return 0
+}
+
+// TailBuffer keeps only the tail of the written log, so that the memory usage
+// does not depend on the size of the log.
+type TailBuffer struct {
+	max     int
+	buf     []byte
+	dropped int
+}
+
+// NewTailBuffer returns the buffer that keeps up to max bytes.
+func NewTailBuffer(max int) *TailBuffer {
+	return &TailBuffer{max: max}
+}
+
+func (b *TailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	// compacted lazily, so that the head is not copied on every write
+	if len(b.buf) > 2*b.max {
+		b.dropped += len(b.buf) - b.max
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+// EnsureNewline writes a newline unless the log is empty or ends with a newline.
+func (b *TailBuffer) EnsureNewline() {
+	if len(b.buf) > 0 && b.buf[len(b.buf)-1] != '\n' {
+		b.Write([]byte{'\n'})
+	}
+}
+
+// Bytes returns the tail of the log, with the header like truncateHead
+// when the head has been truncated.
+func (b *TailBuffer) Bytes() []byte {
+	log, _ := truncateHeadDropped(b.buf, b.max, b.dropped)
+	return log
+}
@@ -0,0 +1,159 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logsink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/s3"
+	"github.com/containerbuilding/cbi/pkg/s3/s3test"
+)
+
+var testBuildJob = &crd.BuildJob{
+	ObjectMeta: metav1.ObjectMeta{
+		Namespace: "default",
+		Name:      "foo",
+		UID:       "42",
+	},
+}
+
+func TestTruncateHead(t *testing.T) {
+	testCases := []struct {
+		log               string
+		max               int
+		expected          string
+		expectedTruncated bool
+	}{
+		{
+			log:      "hello",
+			max:      5,
+			expected: "hello",
+		},
+		{
+			log:               "0123456789abcdefghijklmnopqrstuvwxyz0123456789",
+			max:               40,
+			expected:          "[cbid: truncated 33 bytes]\nxyz0123456789",
+			expectedTruncated: true,
+		},
+		{
+			log:               "0123456789",
+			max:               4,
+			expected:          "6789",
+			expectedTruncated: true,
+		},
+	}
+	for _, tc := range testCases {
+		actual, truncated := truncateHead([]byte(tc.log), tc.max)
+		if string(actual) != tc.expected || truncated != tc.expectedTruncated {
+			t.Fatalf("expected (%q, %v), got (%q, %v)", tc.expected, tc.expectedTruncated, string(actual), truncated)
+		}
+		if len(actual) > tc.max {
+			t.Fatalf("exceeded %d bytes: %q", tc.max, string(actual))
+		}
+	}
+}
+
+func TestTailBuffer(t *testing.T) {
+	testCases := []struct {
+		writes   []string
+		max      int
+		expected string
+	}{
+		{
+			writes:   []string{"hel", "lo"},
+			max:      5,
+			expected: "hello",
+		},
+		{
+			// same as truncateHead
+			writes:   []string{"0123456789", "abcdefghijklmnopqrstuvwxyz", "0123456789"},
+			max:      40,
+			expected: "[cbid: truncated 33 bytes]\nxyz0123456789",
+		},
+		{
+			// compacted on the writes
+			writes:   []string{"0123456789", "0123456789", "0123456789", "0123456789", "0123456789"},
+			max:      4,
+			expected: "6789",
+		},
+	}
+	for i, tc := range testCases {
+		b := NewTailBuffer(tc.max)
+		for _, w := range tc.writes {
+			if _, err := b.Write([]byte(w)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if actual := string(b.Bytes()); actual != tc.expected {
+			t.Fatalf("%d: expected %q, got %q", i, tc.expected, actual)
+		}
+		if len(b.buf) > 2*tc.max {
+			t.Fatalf("%d: buffered %d bytes", i, len(b.buf))
+		}
+	}
+}
+
+func TestPersistentVolumeClaim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logsink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	s := &PersistentVolumeClaim{Namespace: "cbi-system", ClaimName: "cbi-logs", MountPath: dir}
+	loc, err := s.Store(context.TODO(), testBuildJob, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Kind != crd.BuildJobLogKindPersistentVolumeClaim || loc.PersistentVolumeClaim.Path != "default/foo-42.log" {
+		t.Fatalf("unexpected location: %+v", loc)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "default", "foo-42.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "hello" {
+		t.Fatalf("unexpected log: %q", string(b))
+	}
+}
+
+func TestS3(t *testing.T) {
+	creds := s3.Credentials{AccessKeyID: "minio", SecretAccessKey: "minio123"}
+	srv := s3test.NewServer(creds, "cbi-logs")
+	defer srv.Close()
+	client, err := s3.New(s3.Config{Endpoint: srv.URL, Credentials: creds})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &S3{Client: client, Endpoint: srv.URL, Bucket: "cbi-logs", Prefix: "logs/"}
+	loc, err := s.Store(context.TODO(), testBuildJob, []byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loc.Kind != crd.BuildJobLogKindS3 || loc.S3.Bucket != "cbi-logs" || loc.S3.Key != "logs/default/foo-42.log" {
+		t.Fatalf("unexpected location: %+v", loc)
+	}
+	if b, _ := srv.Object("cbi-logs", "logs/default/foo-42.log"); string(b) != "hello" {
+		t.Fatalf("unexpected log: %q", string(b))
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logsink
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+// PersistentVolumeClaim stores the log as "<MountPath>/<namespace>/<name>-<uid>.log".
+// The claim needs to be mounted on MountPath of the cbid container.
+type PersistentVolumeClaim struct {
+	Namespace string
+	ClaimName string
+	MountPath string
+}
+
+func (s *PersistentVolumeClaim) Store(ctx context.Context, buildJob *crd.BuildJob, log []byte) (crd.BuildJobLog, error) {
+	p := objectPath(buildJob)
+	f := filepath.Join(s.MountPath, filepath.FromSlash(p))
+	if err := os.MkdirAll(filepath.Dir(f), 0755); err != nil {
+		return crd.BuildJobLog{}, err
+	}
+	// write to a temporary file first, so that readers never see a partial log
+	tmp := f + ".tmp"
+	if err := ioutil.WriteFile(tmp, log, 0644); err != nil {
+		return crd.BuildJobLog{}, err
+	}
+	if err := os.Rename(tmp, f); err != nil {
+		os.Remove(tmp)
+		return crd.BuildJobLog{}, err
+	}
+	return crd.BuildJobLog{
+		Kind: crd.BuildJobLogKindPersistentVolumeClaim,
+		PersistentVolumeClaim: crd.BuildJobLogPersistentVolumeClaim{
+			Namespace: s.Namespace,
+			ClaimName: s.ClaimName,
+			Path:      p,
+		},
+	}, nil
+}
@@ -0,0 +1,49 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package logsink
+
+import (
+	"context"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/s3"
+)
+
+// S3 stores the log as the object "<Prefix><namespace>/<name>-<uid>.log"
+// in the S3-compatible bucket.
+type S3 struct {
+	Client *s3.Client
+	// Endpoint is recorded in the BuildJob status.
+	Endpoint string
+	Bucket   string
+	Prefix   string
+}
+
+func (s *S3) Store(ctx context.Context, buildJob *crd.BuildJob, log []byte) (crd.BuildJobLog, error) {
+	key := s.Prefix + objectPath(buildJob)
+	if err := s.Client.PutObject(ctx, s.Bucket, key, log, "text/plain; charset=utf-8"); err != nil {
+		return crd.BuildJobLog{}, err
+	}
+	return crd.BuildJobLog{
+		Kind: crd.BuildJobLogKindS3,
+		S3: crd.BuildJobLogS3{
+			Endpoint: s.Endpoint,
+			Bucket:   s.Bucket,
+			Key:      key,
+		},
+	}, nil
+}
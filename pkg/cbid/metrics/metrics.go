@@ -0,0 +1,206 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics provides the Prometheus metrics of cbid.
+package metrics
+
+import (
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/workqueue"
+
+	listers "github.com/containerbuilding/cbi/pkg/client/listers/cbi/v1alpha1"
+)
+
+const namespace = "cbid"
+
+var (
+	// BuildDuration is the duration of the finished builds, observed by the controller.
+	BuildDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "build_duration_seconds",
+		Help:      "Duration of the finished builds, partitioned by plugin and phase (Succeeded or Failed).",
+		Buckets:   prometheus.ExponentialBuckets(5, 2, 10),
+	}, []string{"plugin", "phase"})
+
+	// SyncErrors is the number of the errors returned from the sync handler.
+	SyncErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sync_errors_total",
+		Help:      "Number of errors during syncing BuildJobs.",
+	})
+
+	// PluginRPCDuration is the latency of the plugin RPCs.
+	PluginRPCDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "plugin_rpc_duration_seconds",
+		Help:      "Latency of the plugin RPCs, partitioned by plugin and method (Info or Spec).",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"plugin", "method"})
+
+	// PluginRPCErrors is the number of the failed plugin RPCs.
+	PluginRPCErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_rpc_errors_total",
+		Help:      "Number of failed plugin RPCs, partitioned by plugin and method (Info or Spec).",
+	}, []string{"plugin", "method"})
+
+	// PluginUp is 1 when the last Info RPC to the plugin succeeded, 0 otherwise.
+	PluginUp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "plugin_up",
+		Help:      "Whether the last Info RPC to the plugin succeeded (1) or not (0).",
+	}, []string{"plugin"})
+)
+
+func init() {
+	prometheus.MustRegister(BuildDuration, SyncErrors, PluginRPCDuration, PluginRPCErrors, PluginUp)
+	workqueue.SetProvider(workqueueMetricsProvider{})
+}
+
+// RegisterBuildJobCollector registers the collector for the number of BuildJobs by phase and plugin.
+func RegisterBuildJobCollector(lister listers.BuildJobLister) error {
+	return prometheus.Register(&buildJobCollector{lister: lister})
+}
+
+// Handler returns the HTTP handler for serving the metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+var buildJobsDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "", "buildjobs"),
+	"Number of BuildJobs, partitioned by namespace, phase and plugin.",
+	[]string{"namespace", "phase", "plugin"}, nil)
+
+// buildJobCollector collects the number of BuildJobs from the lister on scraping.
+type buildJobCollector struct {
+	lister listers.BuildJobLister
+}
+
+func (c *buildJobCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- buildJobsDesc
+}
+
+func (c *buildJobCollector) Collect(ch chan<- prometheus.Metric) {
+	buildJobs, err := c.lister.List(labels.Everything())
+	if err != nil {
+		return
+	}
+	type key struct {
+		namespace, phase, plugin string
+	}
+	counts := make(map[key]int)
+	for _, bj := range buildJobs {
+		counts[key{bj.Namespace, string(bj.Status.Phase), bj.Status.Plugin}]++
+	}
+	for k, v := range counts {
+		ch <- prometheus.MustNewConstMetric(buildJobsDesc, prometheus.GaugeValue, float64(v), k.namespace, k.phase, k.plugin)
+	}
+}
+
+// workqueueMetricsProvider implements workqueue.MetricsProvider.
+// Adapted from k8s.io/kubernetes/pkg/util/workqueue/prometheus.
+type workqueueMetricsProvider struct{}
+
+func (workqueueMetricsProvider) constLabels(name string) prometheus.Labels {
+	return prometheus.Labels{"name": name}
+}
+
+func (p workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	depth := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Subsystem:   "workqueue",
+		Name:        "depth",
+		Help:        "Current depth of workqueue.",
+		ConstLabels: p.constLabels(name),
+	})
+	prometheus.Register(depth)
+	return depth
+}
+
+func (p workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	adds := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "workqueue",
+		Name:        "adds_total",
+		Help:        "Total number of adds handled by workqueue.",
+		ConstLabels: p.constLabels(name),
+	})
+	prometheus.Register(adds)
+	return adds
+}
+
+func (p workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.SummaryMetric {
+	latency := prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace:   namespace,
+		Subsystem:   "workqueue",
+		Name:        "queue_latency_microseconds",
+		Help:        "How long an item stays in workqueue before being requested.",
+		ConstLabels: p.constLabels(name),
+	})
+	prometheus.Register(latency)
+	return latency
+}
+
+func (p workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.SummaryMetric {
+	workDuration := prometheus.NewSummary(prometheus.SummaryOpts{
+		Namespace:   namespace,
+		Subsystem:   "workqueue",
+		Name:        "work_duration_microseconds",
+		Help:        "How long processing an item from workqueue takes.",
+		ConstLabels: p.constLabels(name),
+	})
+	prometheus.Register(workDuration)
+	return workDuration
+}
+
+func (p workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	retries := prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Subsystem:   "workqueue",
+		Name:        "retries_total",
+		Help:        "Total number of retries handled by workqueue.",
+		ConstLabels: p.constLabels(name),
+	})
+	prometheus.Register(retries)
+	return retries
+}
+
+// ObservePluginRPC observes the latency and the error of the plugin RPC f.
+// method is the full gRPC method name, e.g. "/cbi.plugin.v1.Plugin/Info".
+func ObservePluginRPC(plugin, method string, f func() error) error {
+	method = path.Base(method)
+	begin := time.Now()
+	err := f()
+	PluginRPCDuration.WithLabelValues(plugin, method).Observe(time.Since(begin).Seconds())
+	if err != nil {
+		PluginRPCErrors.WithLabelValues(plugin, method).Inc()
+	}
+	if method == "Info" {
+		up := 1.0
+		if err != nil {
+			up = 0.0
+		}
+		PluginUp.WithLabelValues(plugin).Set(up)
+	}
+	return err
+}
@@ -18,6 +18,8 @@ package generic
 
 import (
 	"fmt"
+	"strings"
+	"sync"
 
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/selection"
@@ -38,6 +40,55 @@ func defaultRequirements(bj crd.BuildJob) ([]labels.Requirement, error) {
 		return nil, err
 	}
 	requirements = append(requirements, *r)
+	if len(bj.Spec.Contexts) > 0 {
+		r, err = labels.NewRequirement(api.LNamedContexts, selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, *r)
+		for _, nc := range bj.Spec.Contexts {
+			r, err = labels.NewRequirement(api.LContext(nc.Context.Kind), selection.Exists, nil)
+			if err != nil {
+				return nil, err
+			}
+			requirements = append(requirements, *r)
+		}
+	}
+	if len(bj.Spec.BuildSecrets) > 0 {
+		r, err = labels.NewRequirement(api.LBuildSecrets, selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, *r)
+	}
+	if bj.Spec.SSH.SecretRef.Name != "" {
+		r, err = labels.NewRequirement(api.LSSH, selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, *r)
+	}
+	if bj.Spec.Cache.Ref != "" {
+		r, err = labels.NewRequirement(api.LBuildCache, selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, *r)
+	}
+	if len(bj.Spec.Platforms) > 0 {
+		r, err = labels.NewRequirement(api.LMultiPlatform, selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, *r)
+	}
+	if k := bj.Spec.Output.Kind; k != "" && !strings.EqualFold(string(k), string(crd.OutputKindRegistry)) {
+		r, err = labels.NewRequirement(api.LOutput(k), selection.Exists, nil)
+		if err != nil {
+			return nil, err
+		}
+		requirements = append(requirements, *r)
+	}
 	return requirements, nil
 }
 
@@ -58,16 +109,50 @@ func labelsSelector(bj crd.BuildJob) (labels.Selector, error) {
 	return sel, nil
 }
 
+// SelectPlugin selects the first plugin that matches bj.
 func SelectPlugin(plugins []api.InfoResponse, bj crd.BuildJob) (int, error) {
-	sel, err := labelsSelector(bj)
+	matched, err := matchingPlugins(plugins, bj)
 	if err != nil {
 		return -1, err
 	}
+	return matched[0], nil
+}
+
+// NewRoundRobinSelectPlugin returns a function that selects the plugins that
+// match bj in round-robin order.
+func NewRoundRobinSelectPlugin() func(plugins []api.InfoResponse, bj crd.BuildJob) (int, error) {
+	var (
+		mu   sync.Mutex
+		next int
+	)
+	return func(plugins []api.InfoResponse, bj crd.BuildJob) (int, error) {
+		matched, err := matchingPlugins(plugins, bj)
+		if err != nil {
+			return -1, err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		idx := matched[next%len(matched)]
+		next++
+		return idx, nil
+	}
+}
+
+// matchingPlugins returns the non-empty indices of the plugins that match bj.
+func matchingPlugins(plugins []api.InfoResponse, bj crd.BuildJob) ([]int, error) {
+	sel, err := labelsSelector(bj)
+	if err != nil {
+		return nil, err
+	}
+	var matched []int
 	for idx, info := range plugins {
 		lbls := labels.Set(info.Labels)
 		if sel.Matches(lbls) {
-			return idx, nil
+			matched = append(matched, idx)
 		}
 	}
-	return -1, fmt.Errorf("no plugin can handle %s", bj.Name)
+	if len(matched) == 0 {
+		return nil, fmt.Errorf("no plugin can handle %s", bj.Name)
+	}
+	return matched, nil
 }
@@ -19,6 +19,7 @@ package generic
 import (
 	"testing"
 
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
@@ -50,6 +51,15 @@ func TestSelectPlugin(t *testing.T) {
 				api.LContext(crd.ContextKindGit):          "",
 			},
 		},
+		{
+			// 3
+			Labels: map[string]string{
+				api.LPluginName:                           "baz",
+				api.LLanguage(crd.LanguageKindDockerfile): "",
+				api.LContext(crd.ContextKindGit):          "",
+				api.LNamedContexts:                        "",
+			},
+		},
 	}
 
 	testCases := []struct {
@@ -123,6 +133,156 @@ func TestSelectPlugin(t *testing.T) {
 			},
 			expectedErr: true,
 		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy3",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					Contexts: []crd.NamedContext{
+						{Name: "assets", Context: crd.Context{Kind: crd.ContextKindGit}},
+					},
+				},
+			},
+			expected: 3,
+		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy4",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					Contexts: []crd.NamedContext{
+						{Name: "assets", Context: crd.Context{Kind: crd.ContextKindHTTP}},
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy5",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					BuildSecrets: []crd.BuildSecret{
+						{ID: "npmrc", SecretRef: corev1.LocalObjectReference{Name: "npmrc"}},
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy6",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					SSH: crd.SSH{
+						SecretRef: corev1.LocalObjectReference{Name: "ssh"},
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy7",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					Cache: crd.BuildCache{
+						Ref: "example.com/foo:buildcache",
+					},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy8",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					Platforms: []string{"linux/amd64", "linux/arm64"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy9",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					Output: crd.Output{
+						Kind: crd.OutputKindRegistry,
+					},
+				},
+			},
+			expected: 1,
+		},
+		{
+			bj: crd.BuildJob{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "dummy10",
+				},
+				Spec: crd.BuildJobSpec{
+					Language: crd.Language{
+						Kind: crd.LanguageKindDockerfile,
+					},
+					Context: crd.Context{
+						Kind: crd.ContextKindGit,
+					},
+					Output: crd.Output{
+						Kind: crd.OutputKindOCITarball,
+					},
+				},
+			},
+			expectedErr: true,
+		},
 	}
 	for _, tc := range testCases {
 		actual, err := SelectPlugin(plugins, tc.bj)
@@ -138,3 +298,55 @@ func TestSelectPlugin(t *testing.T) {
 		}
 	}
 }
+
+func TestRoundRobinSelectPlugin(t *testing.T) {
+	plugins := []api.InfoResponse{
+		{
+			Labels: map[string]string{
+				api.LPluginName:                           "foo",
+				api.LLanguage(crd.LanguageKindDockerfile): "",
+				api.LContext(crd.ContextKindGit):          "",
+			},
+		},
+		{
+			Labels: map[string]string{
+				api.LPluginName:                           "bar",
+				api.LLanguage(crd.LanguageKindDockerfile): "",
+			},
+		},
+		{
+			Labels: map[string]string{
+				api.LPluginName:                           "baz",
+				api.LLanguage(crd.LanguageKindDockerfile): "",
+				api.LContext(crd.ContextKindGit):          "",
+			},
+		},
+	}
+	bj := crd.BuildJob{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "dummy0",
+		},
+		Spec: crd.BuildJobSpec{
+			Language: crd.Language{
+				Kind: crd.LanguageKindDockerfile,
+			},
+			Context: crd.Context{
+				Kind: crd.ContextKindGit,
+			},
+		},
+	}
+	fn := NewRoundRobinSelectPlugin()
+	for i, expected := range []int{0, 2, 0, 2} {
+		actual, err := fn(plugins, bj)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != actual {
+			t.Fatalf("%d: expected %d, got %d", i, expected, actual)
+		}
+	}
+	bj.Spec.Language.Kind = crd.LanguageKindCloudbuild
+	if _, err := fn(plugins, bj); err == nil {
+		t.Fatal("error is expected")
+	}
+}
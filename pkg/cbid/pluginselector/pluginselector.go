@@ -18,7 +18,10 @@ package pluginselector
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
@@ -42,32 +45,83 @@ func NewPluginSelector(fn PluginSelectorFunc, conns ...*grpc.ClientConn) *Plugin
 
 type cachedInfo struct {
 	conn *grpc.ClientConn
+	// info is nil when the plugin failed to respond to the last Info RPC.
 	info *api.InfoResponse
+	// lastInfo is the info of the last successful Info RPC.
+	lastInfo *api.InfoResponse
 }
 
+// ErrPluginUnavailable is returned by Select when no healthy plugin supports
+// the BuildJob, but an unhealthy plugin may support it.
+var ErrPluginUnavailable = errors.New("no healthy plugin supports the spec, but an unhealthy plugin may support it")
+
 type PluginSelector struct {
 	fn         PluginSelectorFunc
+	mu         sync.RWMutex
 	cachedInfo []*cachedInfo
 }
 
+// SetSelectorFunc replaces the function used for selecting the plugin.
+func (ps *PluginSelector) SetSelectorFunc(fn PluginSelectorFunc) {
+	ps.mu.Lock()
+	ps.fn = fn
+	ps.mu.Unlock()
+}
+
+// infoTimeout is the timeout of each Info RPC, so that a hung plugin does not
+// block updating the other plugins.
+const infoTimeout = 10 * time.Second
+
+// UpdateCachedInfo calls Info RPC for all the plugins in parallel.
+// Plugins that failed to respond are excluded from selection until the next call.
+// The lock is held only for swapping the results, so that Select is not blocked
+// by the RPCs.
 func (ps *PluginSelector) UpdateCachedInfo(ctx context.Context) error {
-	var errors []error
-	for _, x := range ps.cachedInfo {
-		client := api.NewPluginClient(x.conn)
-		info, err := client.Info(ctx, &api.InfoRequest{})
-		if err != nil {
-			errors = append(errors, err)
-			info = nil
+	ps.mu.RLock()
+	conns := make([]*grpc.ClientConn, len(ps.cachedInfo))
+	for i, x := range ps.cachedInfo {
+		conns[i] = x.conn
+	}
+	ps.mu.RUnlock()
+
+	infos := make([]*api.InfoResponse, len(conns))
+	errs := make([]error, len(conns))
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		wg.Add(1)
+		go func(i int, conn *grpc.ClientConn) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, infoTimeout)
+			defer cancel()
+			infos[i], errs[i] = api.NewPluginClient(conn).Info(ctx, &api.InfoRequest{})
+		}(i, conn)
+	}
+	wg.Wait()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var failed []error
+	for i, x := range ps.cachedInfo {
+		if errs[i] != nil {
+			failed = append(failed, errs[i])
+			x.info = nil
+			continue
 		}
-		x.info = info
+		x.info = infos[i]
+		x.lastInfo = infos[i]
 	}
-	if len(errors) > 0 {
-		return fmt.Errorf("%v", errors)
+	if len(failed) > 0 {
+		return fmt.Errorf("%v", failed)
 	}
 	return nil
 }
 
-func (ps *PluginSelector) Select(bj crd.BuildJob) api.PluginClient {
+// Select selects the plugin for bj, and returns the client and the cached info.
+// nil is returned when no plugin supports bj.
+// ErrPluginUnavailable is returned when only the unhealthy plugins may support bj.
+func (ps *PluginSelector) Select(bj crd.BuildJob) (api.PluginClient, *api.InfoResponse, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
 	var (
 		conns []*grpc.ClientConn
 		info  []api.InfoResponse
@@ -85,7 +139,27 @@ func (ps *PluginSelector) Select(bj crd.BuildJob) api.PluginClient {
 	}
 	if idx >= 0 {
 		conn := conns[idx]
-		return api.NewPluginClient(conn)
+		return api.NewPluginClient(conn), &info[idx], nil
 	}
-	return nil
+	if ps.unhealthyMayMatch(bj) {
+		return nil, nil, ErrPluginUnavailable
+	}
+	return nil, nil, nil
+}
+
+// unhealthyMayMatch returns true if an unhealthy plugin supported bj when it was healthy.
+// The plugin that has never responded is assumed to support bj.
+func (ps *PluginSelector) unhealthyMayMatch(bj crd.BuildJob) bool {
+	for _, x := range ps.cachedInfo {
+		if x.info != nil {
+			continue
+		}
+		if x.lastInfo == nil {
+			return true
+		}
+		if idx, _ := ps.fn([]api.InfoResponse{*x.lastInfo}, bj); idx >= 0 {
+			return true
+		}
+	}
+	return false
 }
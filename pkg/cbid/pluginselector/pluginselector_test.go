@@ -0,0 +1,104 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pluginselector
+
+import (
+	"testing"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	api "github.com/containerbuilding/cbi/pkg/plugin/api"
+)
+
+// selectByLanguage selects the first plugin that supports the language.
+func selectByLanguage(plugins []api.InfoResponse, bj crd.BuildJob) (int, error) {
+	for i, p := range plugins {
+		if _, ok := p.Labels[api.LLanguage(bj.Spec.Language.Kind)]; ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+func TestSelect(t *testing.T) {
+	dockerfile := &api.InfoResponse{
+		Labels: map[string]string{
+			api.LPluginName:                           "foo",
+			api.LLanguage(crd.LanguageKindDockerfile): "",
+		},
+	}
+	s2i := &api.InfoResponse{
+		Labels: map[string]string{
+			api.LPluginName:                    "bar",
+			api.LLanguage(crd.LanguageKindS2I): "",
+		},
+	}
+	testCases := []struct {
+		cachedInfo  []*cachedInfo
+		language    crd.LanguageKind
+		expected    string
+		expectedErr error
+	}{
+		{
+			cachedInfo: []*cachedInfo{{info: dockerfile, lastInfo: dockerfile}, {info: s2i, lastInfo: s2i}},
+			language:   crd.LanguageKindS2I,
+			expected:   "bar",
+		},
+		{
+			// no plugin supports the language
+			cachedInfo: []*cachedInfo{{info: dockerfile, lastInfo: dockerfile}, {info: s2i, lastInfo: s2i}},
+			language:   crd.LanguageKindCloudbuild,
+		},
+		{
+			// the plugin supporting the language is unhealthy
+			cachedInfo:  []*cachedInfo{{info: dockerfile, lastInfo: dockerfile}, {lastInfo: s2i}},
+			language:    crd.LanguageKindS2I,
+			expectedErr: ErrPluginUnavailable,
+		},
+		{
+			// the unhealthy plugin did not support the language
+			cachedInfo: []*cachedInfo{{info: dockerfile, lastInfo: dockerfile}, {lastInfo: s2i}},
+			language:   crd.LanguageKindCloudbuild,
+		},
+		{
+			// the plugin has never responded
+			cachedInfo:  []*cachedInfo{{info: dockerfile, lastInfo: dockerfile}, {}},
+			language:    crd.LanguageKindCloudbuild,
+			expectedErr: ErrPluginUnavailable,
+		},
+	}
+	for i, tc := range testCases {
+		ps := &PluginSelector{fn: selectByLanguage, cachedInfo: tc.cachedInfo}
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Language: crd.Language{Kind: tc.language},
+			},
+		}
+		client, info, err := ps.Select(bj)
+		if err != tc.expectedErr {
+			t.Fatalf("%d: expected error %v, got %v", i, tc.expectedErr, err)
+		}
+		if tc.expected == "" {
+			if client != nil || info != nil {
+				t.Fatalf("%d: unexpected plugin %v", i, info)
+			}
+			continue
+		}
+		if client == nil || info == nil || info.Labels[api.LPluginName] != tc.expected {
+			t.Fatalf("%d: expected plugin %q, got %v", i, tc.expected, info)
+		}
+	}
+}
@@ -17,6 +17,8 @@ var (
 		"plugin.",
 		"language.",
 		"context.",
+		"feature.",
+		"output.",
 	}
 )
 
@@ -27,6 +29,35 @@ const (
 	// Example values: "buildkit", "buildah", ...
 	LPluginName = "plugin.name"
 	// TODO: add LPluginVersion = "v1alpha1"?
+
+	// LNamedContexts is present when the plugin supports BuildJobSpec.Contexts.
+	LNamedContexts = "context.named"
+
+	// LBuildSecrets is present when the plugin supports BuildJobSpec.BuildSecrets.
+	LBuildSecrets = "feature.buildsecrets"
+
+	// LSSH is present when the plugin supports BuildJobSpec.SSH.
+	LSSH = "feature.ssh"
+
+	// LBuildCache is present when the plugin supports BuildJobSpec.Cache.
+	LBuildCache = "feature.buildcache"
+
+	// LMultiPlatform is present when the plugin supports BuildJobSpec.Platforms.
+	LMultiPlatform = "feature.multiplatform"
+
+	// LOutputFormat is the format of the tarball written for the outputs other than
+	// crd.OutputKindRegistry, e.g. "output.format=oci".
+	// LOutput(crd.OutputKindOCITarball) MUST be present only for OutputFormatOCI,
+	// and LOutput(crd.OutputKindDockerTarball) only for OutputFormatDocker.
+	LOutputFormat = "output.format"
+)
+
+// Values of LOutputFormat.
+const (
+	// OutputFormatOCI is the OCI image layout archive.
+	OutputFormatOCI = "oci"
+	// OutputFormatDocker is the `docker save` archive.
+	OutputFormatDocker = "docker"
 )
 
 func LLanguage(k crd.LanguageKind) string {
@@ -36,3 +67,8 @@ func LLanguage(k crd.LanguageKind) string {
 func LContext(k crd.ContextKind) string {
 	return "context." + strings.ToLower(string(k))
 }
+
+// LOutput is present when the plugin supports the output kind other than crd.OutputKindRegistry.
+func LOutput(k crd.OutputKind) string {
+	return "output." + strings.ToLower(string(k))
+}
@@ -26,13 +26,19 @@ import (
 	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
 	pluginapi "github.com/containerbuilding/cbi/pkg/plugin/api"
 	"github.com/containerbuilding/cbi/pkg/plugin/base"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cacheutil"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/outpututil"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/platformutil"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/registryutil"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/storageutil"
 )
 
 type Buildah struct {
 	Image  string
 	Helper cbipluginhelper.Helper
+	// Storage is mounted on /var/lib/containers/storage.
+	Storage storageutil.Storage
 }
 
 var _ base.Backend = &Buildah{}
@@ -42,11 +48,16 @@ func (b *Buildah) Info(ctx context.Context, req *pluginapi.InfoRequest) (*plugin
 		Labels: map[string]string{
 			pluginapi.LPluginName:                           "buildah",
 			pluginapi.LLanguage(crd.LanguageKindDockerfile): "",
+			pluginapi.LBuildCache:                           "",
+			pluginapi.LMultiPlatform:                        "",
 		},
 	}
 	for k, v := range cbipluginhelper.Labels {
 		res.Labels[k] = v
 	}
+	for k, v := range outpututil.Labels(pluginapi.OutputFormatOCI) {
+		res.Labels[k] = v
+	}
 	return res, nil
 }
 
@@ -100,7 +111,7 @@ func (b *Buildah) commonPodSpec(buildJob crd.BuildJob) corev1.PodSpec {
 			{
 				Name: "buildah-storage-volume",
 				VolumeSource: corev1.VolumeSource{
-					// not persistent until buildah supports preserving cache
+					// replaced with the PersistentVolumeClaim when Storage.ClaimName is set
 					EmptyDir: &corev1.EmptyDirVolumeSource{},
 				},
 			},
@@ -117,8 +128,12 @@ func (b *Buildah) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJ
 		return nil, fmt.Errorf("unsupported Spec.Language: %v", buildJob.Spec.Language)
 	}
 	podSpec := b.commonPodSpec(buildJob)
-	if buildJob.Spec.Registry.Push && buildJob.Spec.Registry.SecretRef.Name != "" {
-		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", buildJob.Spec.Registry.SecretRef); err != nil {
+	secretRef, err := registryutil.SecretRef(buildJob.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if secretRef.Name != "" {
+		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", secretRef); err != nil {
 			return nil, err
 		}
 	}
@@ -131,6 +146,26 @@ func (b *Buildah) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJ
 		return nil, err
 	}
 	podSpec.Containers[0].Command = []string{dbpPath}
+	cacheEnv, err := cacheEnv(buildJob.Spec.Cache)
+	if err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, cacheEnv...)
+	outputPath, err := outpututil.Inject(injector, buildJob.Spec, pluginapi.OutputFormatOCI)
+	if err != nil {
+		return nil, err
+	}
+	if outputPath != "" {
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{
+			Name:  "DBP_OUTPUT_FILE",
+			Value: outputPath,
+		})
+	}
+	platformsEnv, err := platformsEnv(buildJob.Spec.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, platformsEnv...)
 	ctxInjector := cbipluginhelper.ContextInjector{
 		Injector: injector,
 	}
@@ -141,7 +176,57 @@ func (b *Buildah) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJ
 	podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, []string{
 		ctxPath,
 	}...)
+	if err := b.Storage.Inject(injector, "buildah-storage-volume", "/var/lib/containers/storage", buildJob.Spec.Registry.Target); err != nil {
+		return nil, err
+	}
 	return &corev1.PodTemplateSpec{
 		Spec: podSpec,
 	}, nil
 }
+
+// cacheEnv returns the env vars for docker-build-push.sh for importing and exporting the cache.
+// buildah pushes the layers of all the instructions (`--cache-to`), which corresponds to BuildCacheModeMax.
+func cacheEnv(cache crd.BuildCache) ([]corev1.EnvVar, error) {
+	if cache.Ref == "" {
+		return nil, nil
+	}
+	if err := cacheutil.CheckMode(cache, "buildah", crd.BuildCacheModeMax); err != nil {
+		return nil, err
+	}
+	repo, err := cacheRepository(cache.Ref)
+	if err != nil {
+		return nil, err
+	}
+	return cacheutil.DBPEnv(repo), nil
+}
+
+// cacheRepository strips the tag from ref, as `buildah bud --cache-from --cache-to`
+// takes the repository, and pushes the layers with the tags derived from the instructions.
+func cacheRepository(ref string) (string, error) {
+	if strings.Contains(ref, "@") {
+		return "", fmt.Errorf("invalid cache ref %q: digest is not supported", ref)
+	}
+	repo := ref
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	if repo == "" {
+		return "", fmt.Errorf("invalid cache ref %q", ref)
+	}
+	return repo, nil
+}
+
+// platformsEnv returns the env vars for docker-build-push.sh for building the manifest list.
+// The platforms other than the one of the node require binfmt_misc (qemu-user-static) on the node.
+func platformsEnv(platforms []string) ([]corev1.EnvVar, error) {
+	joined, err := platformutil.Join(platforms)
+	if err != nil || joined == "" {
+		return nil, err
+	}
+	return []corev1.EnvVar{
+		{
+			Name:  "DBP_PLATFORMS",
+			Value: joined,
+		},
+	}, nil
+}
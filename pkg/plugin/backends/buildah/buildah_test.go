@@ -0,0 +1,227 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildah
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/internal/backendtest"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/storageutil"
+)
+
+func TestCreatePodTemplateSpecCache(t *testing.T) {
+	testCases := []struct {
+		cache       crd.BuildCache
+		expected    string
+		expectedErr bool
+	}{
+		{
+			cache:    crd.BuildCache{},
+			expected: "",
+		},
+		{
+			// the tag is stripped
+			cache:    crd.BuildCache{Ref: "example.com/foo:buildcache"},
+			expected: "example.com/foo",
+		},
+		{
+			cache:    crd.BuildCache{Ref: "example.com:5000/foo/cache", Mode: crd.BuildCacheModeMax},
+			expected: "example.com:5000/foo/cache",
+		},
+		{
+			cache:       crd.BuildCache{Ref: "example.com/foo@sha256:deadbeef"},
+			expectedErr: true,
+		},
+		{
+			cache:       crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: crd.BuildCacheModeMin},
+			expectedErr: true,
+		},
+	}
+	b := &Buildah{
+		Image:  "buildah",
+		Helper: cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Cache:    tc.cache,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			if actual := backendtest.EnvValue(podTemplateSpec.Spec.Containers[0].Env, "DBP_CACHE_REF"); tc.expected != actual {
+				t.Fatalf("%d: expected DBP_CACHE_REF=%q, got %q", i, tc.expected, actual)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecPlatforms(t *testing.T) {
+	testCases := []struct {
+		platforms   []string
+		storage     storageutil.Storage
+		expected    string
+		expectedErr bool
+	}{
+		{
+			platforms: nil,
+			expected:  "",
+		},
+		{
+			platforms: []string{"linux/amd64", "linux/arm64"},
+			expected:  "linux/amd64,linux/arm64",
+		},
+		{
+			// the manifest list in the persistent storage is removed by docker-build-push.sh before building
+			platforms: []string{"linux/amd64", "linux/arm64"},
+			storage:   storageutil.Storage{ClaimName: "buildah-storage"},
+			expected:  "linux/amd64,linux/arm64",
+		},
+		{
+			// validated by platformutil
+			platforms:   []string{"linux/amd64 linux/arm64"},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		b := &Buildah{
+			Image:   "buildah",
+			Helper:  cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+			Storage: tc.storage,
+		}
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry:  crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language:  crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:   crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Platforms: tc.platforms,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			if actual := backendtest.EnvValue(podTemplateSpec.Spec.Containers[0].Env, "DBP_PLATFORMS"); tc.expected != actual {
+				t.Fatalf("%d: expected DBP_PLATFORMS=%q, got %q", i, tc.expected, actual)
+			}
+			if tc.storage.ClaimName != "" {
+				// the builds sharing the manifest list are serialized
+				command := strings.Join(podTemplateSpec.Spec.Containers[0].Command, " ")
+				if !strings.Contains(command, " lock --file /var/lib/containers/storage/cbi.lock -- ") {
+					t.Fatalf("%d: unexpected command: %q", i, command)
+				}
+				claim := podTemplateSpec.Spec.Volumes[0].PersistentVolumeClaim
+				if claim == nil || claim.ClaimName != tc.storage.ClaimName {
+					t.Fatalf("%d: unexpected volume: %+v", i, podTemplateSpec.Spec.Volumes[0])
+				}
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecOutput(t *testing.T) {
+	testCases := []struct {
+		registry     crd.Registry
+		output       crd.Output
+		expectedFile string
+		expectedErr  bool
+	}{
+		{
+			registry:     crd.Registry{Target: "example.com/foo:latest", Push: true},
+			output:       crd.Output{},
+			expectedFile: "",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest"},
+			output: crd.Output{
+				Kind:       crd.OutputKindOCITarball,
+				OCITarball: crd.OCITarballOutput{ClaimName: "images", Path: "foo/foo.tar"},
+			},
+			expectedFile: "/cbi-output/foo/foo.tar",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest"},
+			output: crd.Output{
+				Kind:       crd.OutputKindHTTPUpload,
+				HTTPUpload: crd.HTTPUploadOutput{URL: "https://example.com/foo.tar"},
+			},
+			expectedFile: "/cbi-output/image.tar",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+			output: crd.Output{
+				Kind:       crd.OutputKindOCITarball,
+				OCITarball: crd.OCITarballOutput{ClaimName: "images", Path: "foo/foo.tar"},
+			},
+			expectedErr: true,
+		},
+	}
+	b := &Buildah{
+		Image:  "buildah",
+		Helper: cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: tc.registry,
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Output:   tc.output,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			container := podTemplateSpec.Spec.Containers[0]
+			command := strings.Join(container.Command, " ")
+			if tc.expectedFile == "" {
+				if strings.Contains(command, " output ") {
+					t.Fatalf("%d: unexpected command: %q", i, command)
+				}
+				continue
+			}
+			if !strings.Contains(command, " output --file "+tc.expectedFile+" ") || !strings.Contains(command, " -- /cbi-file-") {
+				t.Fatalf("%d: unexpected command: %q", i, command)
+			}
+			if actual := backendtest.EnvValue(container.Env, "DBP_OUTPUT_FILE"); tc.expectedFile != actual {
+				t.Fatalf("%d: expected DBP_OUTPUT_FILE=%q, got %q", i, tc.expectedFile, actual)
+			}
+		}
+	}
+}
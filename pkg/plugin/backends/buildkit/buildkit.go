@@ -19,14 +19,18 @@ package buildkit
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 
 	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/buildkitsession"
 	pluginapi "github.com/containerbuilding/cbi/pkg/plugin/api"
 	"github.com/containerbuilding/cbi/pkg/plugin/base"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/outpututil"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/platformutil"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/registryutil"
 )
 
@@ -48,17 +52,27 @@ func (b *BuildKit) Info(ctx context.Context, req *pluginapi.InfoRequest) (*plugi
 	for k, v := range cbipluginhelper.Labels {
 		res.Labels[k] = v
 	}
+	res.Labels[pluginapi.LContext(crd.ContextKindBuildKitSession)] = ""
+	res.Labels[pluginapi.LNamedContexts] = ""
+	res.Labels[pluginapi.LBuildSecrets] = ""
+	res.Labels[pluginapi.LSSH] = ""
+	res.Labels[pluginapi.LBuildCache] = ""
+	res.Labels[pluginapi.LMultiPlatform] = ""
+	for k, v := range outpututil.Labels(pluginapi.OutputFormatOCI) {
+		res.Labels[k] = v
+	}
 	return res, nil
 }
 
-func (b *BuildKit) commonPodSpec(buildJob crd.BuildJob) corev1.PodSpec {
+// commonPodSpec returns the pod spec that runs buildctl against buildkitd at addr.
+func (b *BuildKit) commonPodSpec(buildJob crd.BuildJob, addr string) corev1.PodSpec {
 	podSpec := corev1.PodSpec{
 		RestartPolicy: corev1.RestartPolicyNever,
 		Containers: []corev1.Container{
 			{
 				Name:  "buildctl-job",
 				Image: b.BuildctlImage,
-				Command: []string{"buildctl", "--addr", b.BuildkitdAddr,
+				Command: []string{"buildctl", "--addr", addr,
 					"build",
 					"--frontend=dockerfile.v0",
 					"--no-progress", "--trace", "/dev/stdout",
@@ -83,9 +97,34 @@ func (b *BuildKit) CreatePodTemplateSpec(ctx context.Context, buildJob crd.Build
 	default:
 		return nil, fmt.Errorf("unsupported Spec.Language: %v", buildJob.Spec.Language)
 	}
-	podSpec := b.commonPodSpec(buildJob)
-	if buildJob.Spec.Registry.Push && buildJob.Spec.Registry.SecretRef.Name != "" {
-		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", buildJob.Spec.Registry.SecretRef); err != nil {
+	// For BuildKitSession context, buildctl runs behind the session proxy
+	// (`cbipluginhelper buildkit-session`), so that BuildKit can pull the context
+	// from the client attached to the pod.
+	session := strings.EqualFold(string(buildJob.Spec.Context.Kind), string(crd.ContextKindBuildKitSession))
+	addr := b.BuildkitdAddr
+	if session {
+		addr = "unix://" + buildkitsession.SocketPath
+	}
+	podSpec := b.commonPodSpec(buildJob, addr)
+	if err := injectBuildSecrets(&podSpec, buildJob.Spec.BuildSecrets); err != nil {
+		return nil, err
+	}
+	cacheArgs, err := cacheArgs(buildJob.Spec.Cache)
+	if err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, cacheArgs...)
+	platformArgs, err := platformArgs(buildJob.Spec.Platforms)
+	if err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, platformArgs...)
+	secretRef, err := registryutil.SecretRef(buildJob.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if secretRef.Name != "" {
+		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", secretRef); err != nil {
 			return nil, err
 		}
 	}
@@ -93,19 +132,225 @@ func (b *BuildKit) CreatePodTemplateSpec(ctx context.Context, buildJob crd.Build
 		Helper:        b.Helper,
 		TargetPodSpec: &podSpec,
 	}
+	outputPath, err := outpututil.Inject(injector, buildJob.Spec, pluginapi.OutputFormatOCI)
+	if err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, ociExporterArgs(outputPath, buildJob.Spec.Registry.Target)...)
 	ctxInjector := cbipluginhelper.ContextInjector{
 		Injector: injector,
 	}
-	// TODO: allow BuildKit-native git access (with ssh key)
-	ctxPath, err := ctxInjector.Inject(buildJob.Spec.Context)
-	if err != nil {
-		return nil, err
+	ctxPath := buildkitsession.SocketDir
+	if !session {
+		// TODO: allow BuildKit-native git access (with ssh key)
+		if ctxPath, err = ctxInjector.Inject(buildJob.Spec.Context); err != nil {
+			return nil, err
+		}
 	}
 	podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, []string{
 		"--local", "context=" + ctxPath,
 		"--local", "dockerfile=" + ctxPath,
 	}...)
+	namedArgs, err := injectNamedContexts(ctxInjector, buildJob.Spec.Contexts)
+	if err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, namedArgs...)
+	ssh := buildJob.Spec.SSH.SecretRef.Name != ""
+	if ssh {
+		podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, sshArgs(buildJob.Spec.SSH)...)
+	}
+	if session || ssh {
+		helperPath, err := injector.InjectFile("/cbipluginhelper")
+		if err != nil {
+			return nil, err
+		}
+		if session {
+			container := &podSpec.Containers[0]
+			container.Command = append([]string{helperPath, "buildkit-session",
+				"--buildkitd-addr", b.BuildkitdAddr,
+				"--port", strconv.Itoa(buildkitsession.DefaultPort),
+				"--shared-key", buildJob.Spec.Context.BuildKitSession.SharedKey,
+				"--"}, container.Command...)
+			container.Ports = append(container.Ports, corev1.ContainerPort{
+				Name:          "session",
+				ContainerPort: buildkitsession.DefaultPort,
+			})
+		}
+		if ssh {
+			// wraps buildkit-session as well, and buildctl inherits $SSH_AUTH_SOCK
+			injectSSHAgent(&podSpec, helperPath, buildJob.Spec.SSH)
+		}
+	}
 	return &corev1.PodTemplateSpec{
 		Spec: podSpec,
 	}, nil
 }
+
+// injectNamedContexts injects the named contexts and returns the buildctl args
+// for passing them to the Dockerfile frontend as `local:NAME`.
+// The named contexts require the Dockerfile frontend 1.4 or later.
+func injectNamedContexts(ctxInjector cbipluginhelper.ContextInjector, contexts []crd.NamedContext) ([]string, error) {
+	for i, nc := range contexts {
+		// reserved for the main context
+		if nc.Name == "context" || nc.Name == "dockerfile" {
+			return nil, fmt.Errorf("contexts[%d]: name %q is reserved", i, nc.Name)
+		}
+	}
+	paths, err := ctxInjector.InjectNamed(contexts)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	for i, nc := range contexts {
+		args = append(args,
+			"--local", nc.Name+"="+paths[i],
+			"--frontend-opt", "context:"+nc.Name+"=local:"+nc.Name,
+		)
+	}
+	return args, nil
+}
+
+// cacheArgs returns the buildctl args for importing and exporting the cache.
+func cacheArgs(cache crd.BuildCache) ([]string, error) {
+	if cache.Ref == "" {
+		return nil, nil
+	}
+	args := []string{"--export-cache", cache.Ref}
+	switch m := cache.Mode; {
+	case m == "", strings.EqualFold(string(m), string(crd.BuildCacheModeMin)):
+		// NOP (default)
+	case strings.EqualFold(string(m), string(crd.BuildCacheModeMax)):
+		args = append(args, "--export-cache-opt", "mode=max")
+	default:
+		return nil, fmt.Errorf("unsupported cache mode: %q", m)
+	}
+	args = append(args, "--import-cache", cache.Ref)
+	return args, nil
+}
+
+// ociExporterArgs returns the buildctl args for exporting the OCI tarball to path.
+// ociExporterArgs returns nil when path is empty.
+func ociExporterArgs(path, name string) []string {
+	if path == "" {
+		return nil
+	}
+	args := []string{"--exporter=oci", "--exporter-opt", "output=" + path}
+	if name != "" {
+		args = append(args, "--exporter-opt", "name="+name)
+	}
+	return args
+}
+
+// platformArgs returns the buildctl args for building the image for the platforms.
+// The image exporter pushes the manifest list when multiple platforms are specified.
+func platformArgs(platforms []string) ([]string, error) {
+	joined, err := platformutil.Join(platforms)
+	if err != nil || joined == "" {
+		return nil, err
+	}
+	return []string{"--frontend-opt", "platform=" + joined}, nil
+}
+
+// injectBuildSecrets mounts the build secrets to the buildctl container, and
+// appends `--secret id=ID,src=PATH` to the command.
+// buildctl sends the secrets to buildkitd only while the build steps are running.
+func injectBuildSecrets(podSpec *corev1.PodSpec, secrets []crd.BuildSecret) error {
+	const volMountPathPrefix = "/cbi-buildsecrets"
+	seen := make(map[string]struct{})
+	container := &podSpec.Containers[0]
+	for i, sec := range secrets {
+		if sec.ID == "" || strings.ContainsAny(sec.ID, ",=\"") {
+			return fmt.Errorf("buildSecrets[%d]: invalid id %q", i, sec.ID)
+		}
+		if _, ok := seen[sec.ID]; ok {
+			return fmt.Errorf("buildSecrets[%d]: duplicated id %q", i, sec.ID)
+		}
+		seen[sec.ID] = struct{}{}
+		if sec.SecretRef.Name == "" {
+			return fmt.Errorf("buildSecrets[%d]: secretRef is required", i)
+		}
+		key := sec.Key
+		if key == "" {
+			key = sec.ID
+		}
+		volName := "cbi-buildsecret-" + strconv.Itoa(i)
+		volMountPath := volMountPathPrefix + "/" + strconv.Itoa(i)
+		defaultMode := int32(0400)
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: sec.SecretRef.Name,
+					Items: []corev1.KeyToPath{
+						{
+							Key:  key,
+							Path: "secret",
+						},
+					},
+					DefaultMode: &defaultMode,
+				},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: volMountPath,
+			ReadOnly:  true,
+		})
+		container.Command = append(container.Command,
+			"--secret", "id="+sec.ID+",src="+volMountPath+"/secret")
+	}
+	return nil
+}
+
+const (
+	sshAgentVolName           = "cbi-sshagent"
+	sshAgentVolMountPath      = "/cbi-sshagent"
+	sshAgentSocket            = sshAgentVolMountPath + "/agent.sock"
+	sshAgentKeysVolName       = "cbi-sshagentkeys"
+	sshAgentKeysVolMountPath  = "/cbi-sshagentkeys"
+	defaultSSHAgentIdentifier = "default"
+)
+
+// sshArgs returns the buildctl args for forwarding the agent, i.e. `--ssh default=$SSH_AUTH_SOCK`.
+func sshArgs(spec crd.SSH) []string {
+	id := spec.ID
+	if id == "" {
+		id = defaultSSHAgentIdentifier
+	}
+	return []string{"--ssh", id + "=" + sshAgentSocket}
+}
+
+// injectSSHAgent wraps the command of the first container with `cbipluginhelper ssh-agent`,
+// serving the private keys in the secret.
+// The keys are mounted only on the first container, and never sent to buildkitd.
+func injectSSHAgent(podSpec *corev1.PodSpec, helperPath string, spec crd.SSH) {
+	defaultMode := int32(0400)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: sshAgentVolName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}, corev1.Volume{
+		Name: sshAgentKeysVolName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  spec.SecretRef.Name,
+				DefaultMode: &defaultMode,
+			},
+		},
+	})
+	container := &podSpec.Containers[0]
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      sshAgentVolName,
+		MountPath: sshAgentVolMountPath,
+	}, corev1.VolumeMount{
+		Name:      sshAgentKeysVolName,
+		MountPath: sshAgentKeysVolMountPath,
+		ReadOnly:  true,
+	})
+	container.Command = append([]string{helperPath, "ssh-agent",
+		"--keys-dir", sshAgentKeysVolMountPath,
+		"--socket", sshAgentSocket,
+		"--"}, container.Command...)
+}
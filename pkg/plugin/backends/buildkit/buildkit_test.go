@@ -0,0 +1,629 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildkit
+
+import (
+	"context"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+)
+
+func TestCreatePodTemplateSpecCache(t *testing.T) {
+	testCases := []struct {
+		cache       crd.BuildCache
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			cache:    crd.BuildCache{},
+			expected: nil,
+		},
+		{
+			cache: crd.BuildCache{Ref: "example.com/foo:buildcache"},
+			expected: []string{
+				"--export-cache", "example.com/foo:buildcache",
+				"--import-cache", "example.com/foo:buildcache",
+			},
+		},
+		{
+			cache: crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: crd.BuildCacheModeMin},
+			expected: []string{
+				"--export-cache", "example.com/foo:buildcache",
+				"--import-cache", "example.com/foo:buildcache",
+			},
+		},
+		{
+			cache: crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: "MAX"},
+			expected: []string{
+				"--export-cache", "example.com/foo:buildcache",
+				"--export-cache-opt", "mode=max",
+				"--import-cache", "example.com/foo:buildcache",
+			},
+		},
+		{
+			cache:       crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: "foo"},
+			expectedErr: true,
+		},
+	}
+	b := &BuildKit{
+		BuildctlImage: "buildctl",
+		BuildkitdAddr: "tcp://buildkitd:1234",
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Cache:    tc.cache,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			command := podTemplateSpec.Spec.Containers[0].Command
+			joined := strings.Join(command, " ")
+			if tc.expected == nil {
+				if strings.Contains(joined, "-cache") {
+					t.Fatalf("%d: unexpected cache args: %v", i, command)
+				}
+			} else if !strings.Contains(joined, " "+strings.Join(tc.expected, " ")+" ") {
+				t.Fatalf("%d: expected %v in %v", i, tc.expected, command)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecPlatforms(t *testing.T) {
+	testCases := []struct {
+		platforms   []string
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			platforms: nil,
+			expected:  nil,
+		},
+		{
+			platforms: []string{"linux/amd64"},
+			expected:  []string{"--frontend-opt", "platform=linux/amd64"},
+		},
+		{
+			platforms: []string{"linux/amd64", "linux/arm64"},
+			expected:  []string{"--frontend-opt", "platform=linux/amd64,linux/arm64"},
+		},
+		{
+			// validated by platformutil
+			platforms:   []string{"linux/amd64,linux/arm64"},
+			expectedErr: true,
+		},
+	}
+	b := &BuildKit{
+		BuildctlImage: "buildctl",
+		BuildkitdAddr: "tcp://buildkitd:1234",
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry:  crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language:  crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:   crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Platforms: tc.platforms,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			command := podTemplateSpec.Spec.Containers[0].Command
+			joined := strings.Join(command, " ")
+			if tc.expected == nil {
+				if strings.Contains(joined, "platform=") {
+					t.Fatalf("%d: unexpected platform args: %v", i, command)
+				}
+			} else if !strings.Contains(joined, " "+strings.Join(tc.expected, " ")+" ") {
+				t.Fatalf("%d: expected %v in %v", i, tc.expected, command)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecOutput(t *testing.T) {
+	testCases := []struct {
+		registry     crd.Registry
+		output       crd.Output
+		expectedFile string
+		expectedErr  bool
+	}{
+		{
+			registry:     crd.Registry{Target: "example.com/foo:latest", Push: true},
+			output:       crd.Output{},
+			expectedFile: "",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest"},
+			output: crd.Output{
+				Kind:       crd.OutputKindOCITarball,
+				OCITarball: crd.OCITarballOutput{ClaimName: "images", Path: "foo/foo.tar"},
+			},
+			expectedFile: "/cbi-output/foo/foo.tar",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest"},
+			output: crd.Output{
+				Kind:       crd.OutputKindHTTPUpload,
+				HTTPUpload: crd.HTTPUploadOutput{URL: "https://example.com/foo.tar"},
+			},
+			expectedFile: "/cbi-output/image.tar",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+			output: crd.Output{
+				Kind:       crd.OutputKindOCITarball,
+				OCITarball: crd.OCITarballOutput{ClaimName: "images", Path: "foo/foo.tar"},
+			},
+			expectedErr: true,
+		},
+	}
+	b := &BuildKit{
+		BuildctlImage: "buildctl",
+		BuildkitdAddr: "tcp://buildkitd:1234",
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: tc.registry,
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Output:   tc.output,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			container := podTemplateSpec.Spec.Containers[0]
+			command := strings.Join(container.Command, " ")
+			if tc.expectedFile == "" {
+				if strings.Contains(command, " output ") {
+					t.Fatalf("%d: unexpected command: %q", i, command)
+				}
+				continue
+			}
+			if !strings.Contains(command, " output --file "+tc.expectedFile+" ") {
+				t.Fatalf("%d: unexpected command: %q", i, command)
+			}
+			if !strings.Contains(command, " --exporter=oci --exporter-opt output="+tc.expectedFile+" --exporter-opt name=example.com/foo:latest ") ||
+				strings.Contains(command, "--exporter=image") {
+				t.Fatalf("%d: unexpected command: %q", i, command)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecSession(t *testing.T) {
+	testCases := []struct {
+		context          crd.Context
+		expectedPrefix   string
+		expectedBuildctl string
+		expectedLocal    string
+	}{
+		{
+			context:          crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+			expectedPrefix:   "",
+			expectedBuildctl: "buildctl --addr tcp://buildkitd:1234 build",
+			expectedLocal:    "--local context=/cbi-",
+		},
+		{
+			context: crd.Context{
+				Kind:            crd.ContextKindBuildKitSession,
+				BuildKitSession: crd.BuildKitSession{SharedKey: "deadbeef"},
+			},
+			expectedPrefix:   " buildkit-session --buildkitd-addr tcp://buildkitd:1234 --port 12120 --shared-key deadbeef -- ",
+			expectedBuildctl: "buildctl --addr unix:///run/cbi-buildkit-session/buildkitd.sock build",
+			expectedLocal:    "--local context=/run/cbi-buildkit-session --local dockerfile=/run/cbi-buildkit-session",
+		},
+	}
+	b := &BuildKit{
+		BuildctlImage: "buildctl",
+		BuildkitdAddr: "tcp://buildkitd:1234",
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry:  crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language:  crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:   tc.context,
+				Cache:     crd.BuildCache{Ref: "example.com/foo:buildcache"},
+				Platforms: []string{"linux/amd64", "linux/arm64"},
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		joined := strings.Join(podTemplateSpec.Spec.Containers[0].Command, " ")
+		if tc.expectedPrefix == "" {
+			if !strings.HasPrefix(joined, tc.expectedBuildctl+" ") {
+				t.Fatalf("%d: unexpected command: %q", i, joined)
+			}
+		} else if !strings.Contains(joined, tc.expectedPrefix+tc.expectedBuildctl+" ") {
+			t.Fatalf("%d: unexpected command: %q", i, joined)
+		}
+		// the build args are same regardless of the context kind
+		for _, s := range []string{
+			"--export-cache example.com/foo:buildcache",
+			"--frontend-opt platform=linux/amd64,linux/arm64",
+			"--exporter=image --exporter-opt name=example.com/foo:latest --exporter-opt push=true",
+			tc.expectedLocal,
+		} {
+			if !strings.Contains(joined, s) {
+				t.Fatalf("%d: expected %q in %q", i, s, joined)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecBuildSecrets(t *testing.T) {
+	type expectedSecret struct {
+		secretName string
+		key        string
+		arg        string
+	}
+	testCases := []struct {
+		secrets     []crd.BuildSecret
+		expected    []expectedSecret
+		expectedErr bool
+	}{
+		{
+			secrets: []crd.BuildSecret{
+				{ID: "npmrc", SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			},
+			expected: []expectedSecret{
+				// the key defaults to the id
+				{secretName: "foo", key: "npmrc", arg: "--secret id=npmrc,src=/cbi-buildsecrets/0/secret"},
+			},
+		},
+		{
+			secrets: []crd.BuildSecret{
+				{ID: "npmrc", SecretRef: corev1.LocalObjectReference{Name: "foo"}, Key: ".npmrc"},
+				{ID: "token", SecretRef: corev1.LocalObjectReference{Name: "bar"}},
+			},
+			expected: []expectedSecret{
+				{secretName: "foo", key: ".npmrc", arg: "--secret id=npmrc,src=/cbi-buildsecrets/0/secret"},
+				{secretName: "bar", key: "token", arg: "--secret id=token,src=/cbi-buildsecrets/1/secret"},
+			},
+		},
+		{
+			secrets: []crd.BuildSecret{
+				{ID: "", SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			},
+			expectedErr: true,
+		},
+		{
+			secrets: []crd.BuildSecret{
+				{ID: "npmrc", SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+				{ID: "npmrc", SecretRef: corev1.LocalObjectReference{Name: "bar"}},
+			},
+			expectedErr: true,
+		},
+		{
+			// would be parsed as another option of --secret
+			secrets: []crd.BuildSecret{
+				{ID: "npmrc,src=/etc/passwd", SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			},
+			expectedErr: true,
+		},
+		{
+			secrets: []crd.BuildSecret{
+				{ID: "npmrc"},
+			},
+			expectedErr: true,
+		},
+	}
+	b := &BuildKit{
+		BuildctlImage: "buildctl",
+		BuildkitdAddr: "tcp://buildkitd:1234",
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry:     crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language:     crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:      crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				BuildSecrets: tc.secrets,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err != nil {
+			continue
+		}
+		if tc.expectedErr {
+			t.Fatalf("%d: error is expected", i)
+		}
+		podSpec := podTemplateSpec.Spec
+		container := podSpec.Containers[0]
+		joined := strings.Join(container.Command, " ")
+		if strings.Count(joined, "--secret ") != len(tc.expected) {
+			t.Fatalf("%d: unexpected secret args: %q", i, joined)
+		}
+		defaultMode := int32(0400)
+		for j, e := range tc.expected {
+			if !strings.Contains(joined, " "+e.arg) {
+				t.Fatalf("%d: expected %q in %q", i, e.arg, joined)
+			}
+			volName := "cbi-buildsecret-" + strconv.Itoa(j)
+			expectedVol := corev1.Volume{
+				Name: volName,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName:  e.secretName,
+						Items:       []corev1.KeyToPath{{Key: e.key, Path: "secret"}},
+						DefaultMode: &defaultMode,
+					},
+				},
+			}
+			if vol := findVolume(podSpec.Volumes, volName); !reflect.DeepEqual(vol, &expectedVol) {
+				t.Fatalf("%d: expected volume %+v, got %+v", i, expectedVol, vol)
+			}
+			expectedMount := corev1.VolumeMount{
+				Name:      volName,
+				MountPath: "/cbi-buildsecrets/" + strconv.Itoa(j),
+				ReadOnly:  true,
+			}
+			if mount := findVolumeMount(container.VolumeMounts, volName); !reflect.DeepEqual(mount, &expectedMount) {
+				t.Fatalf("%d: expected volume mount %+v, got %+v", i, expectedMount, mount)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecNamedContexts(t *testing.T) {
+	git := crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/bar.git"}}
+	testCases := []struct {
+		contexts    []crd.NamedContext
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			contexts: nil,
+			expected: nil,
+		},
+		{
+			contexts: []crd.NamedContext{{Name: "bar", Context: git}, {Name: "baz", Context: git}},
+			expected: []string{"bar", "baz"},
+		},
+		{
+			// reserved for the main context
+			contexts:    []crd.NamedContext{{Name: "context", Context: git}},
+			expectedErr: true,
+		},
+		{
+			contexts:    []crd.NamedContext{{Name: "dockerfile", Context: git}},
+			expectedErr: true,
+		},
+	}
+	b := &BuildKit{
+		BuildctlImage: "buildctl",
+		BuildkitdAddr: "tcp://buildkitd:1234",
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Contexts: tc.contexts,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err != nil {
+			continue
+		}
+		if tc.expectedErr {
+			t.Fatalf("%d: error is expected", i)
+		}
+		container := podTemplateSpec.Spec.Containers[0]
+		joined := strings.Join(container.Command, " ")
+		if !regexp.MustCompile(` --local context=/\S+ --local dockerfile=/\S+`).MatchString(joined) {
+			t.Fatalf("%d: the main context is missing in %q", i, joined)
+		}
+		if strings.Count(joined, "--frontend-opt context:") != len(tc.expected) {
+			t.Fatalf("%d: unexpected named context args: %q", i, joined)
+		}
+		for _, name := range tc.expected {
+			re := regexp.MustCompile(` --local ` + name + `=(/\S+) --frontend-opt context:` + name + `=local:` + name + `( |$)`)
+			m := re.FindStringSubmatch(joined)
+			if m == nil {
+				t.Fatalf("%d: expected %q to match %s", i, joined, re)
+			}
+			mounted := false
+			for _, vm := range container.VolumeMounts {
+				if strings.HasPrefix(m[1], vm.MountPath+"/") {
+					mounted = true
+				}
+			}
+			if !mounted {
+				t.Fatalf("%d: %s is not mounted: %+v", i, m[1], container.VolumeMounts)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecSSH(t *testing.T) {
+	git := crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}}
+	session := crd.Context{
+		Kind:            crd.ContextKindBuildKitSession,
+		BuildKitSession: crd.BuildKitSession{SharedKey: "deadbeef"},
+	}
+	const agent = " ssh-agent --keys-dir /cbi-sshagentkeys --socket /cbi-sshagent/agent.sock -- "
+	testCases := []struct {
+		context crd.Context
+		ssh     crd.SSH
+		// expectedWrappers are the commands before buildctl, in the order
+		expectedWrappers []string
+		expectedArg      string
+	}{
+		{
+			context:          git,
+			expectedWrappers: nil,
+			expectedArg:      "",
+		},
+		{
+			context:          git,
+			ssh:              crd.SSH{SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			expectedWrappers: []string{agent},
+			expectedArg:      "--ssh default=/cbi-sshagent/agent.sock",
+		},
+		{
+			context:          git,
+			ssh:              crd.SSH{SecretRef: corev1.LocalObjectReference{Name: "foo"}, ID: "github"},
+			expectedWrappers: []string{agent},
+			expectedArg:      "--ssh github=/cbi-sshagent/agent.sock",
+		},
+		{
+			// the agent wraps the session proxy
+			context:          session,
+			ssh:              crd.SSH{SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			expectedWrappers: []string{agent, " buildkit-session --buildkitd-addr tcp://buildkitd:1234 "},
+			expectedArg:      "--ssh default=/cbi-sshagent/agent.sock",
+		},
+	}
+	b := &BuildKit{
+		BuildctlImage: "buildctl",
+		BuildkitdAddr: "tcp://buildkitd:1234",
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  tc.context,
+				SSH:      tc.ssh,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		podSpec := podTemplateSpec.Spec
+		container := podSpec.Containers[0]
+		joined := strings.Join(container.Command, " ")
+		if tc.expectedArg == "" {
+			if strings.Contains(joined, "ssh-agent") || strings.Contains(joined, "--ssh ") {
+				t.Fatalf("%d: unexpected ssh args: %q", i, joined)
+			}
+			if findVolume(podSpec.Volumes, "cbi-sshagentkeys") != nil {
+				t.Fatalf("%d: unexpected volume for the keys", i)
+			}
+			continue
+		}
+		if !strings.HasSuffix(joined, " "+tc.expectedArg) && !strings.Contains(joined, " "+tc.expectedArg+" ") {
+			t.Fatalf("%d: expected %q in %q", i, tc.expectedArg, joined)
+		}
+		// the wrappers precede buildctl in the order, and the helper is the entrypoint
+		if !strings.HasSuffix(container.Command[0], "/cbipluginhelper") {
+			t.Fatalf("%d: unexpected entrypoint: %q", i, joined)
+		}
+		pos := 0
+		for _, w := range append(tc.expectedWrappers, "buildctl --addr ") {
+			idx := strings.Index(joined[pos:], w)
+			if idx < 0 {
+				t.Fatalf("%d: expected %q after %q in %q", i, w, joined[:pos], joined)
+			}
+			pos += idx + len(w)
+		}
+		defaultMode := int32(0400)
+		expectedKeysVol := corev1.Volume{
+			Name: "cbi-sshagentkeys",
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  tc.ssh.SecretRef.Name,
+					DefaultMode: &defaultMode,
+				},
+			},
+		}
+		if vol := findVolume(podSpec.Volumes, "cbi-sshagentkeys"); !reflect.DeepEqual(vol, &expectedKeysVol) {
+			t.Fatalf("%d: expected volume %+v, got %+v", i, expectedKeysVol, vol)
+		}
+		if vol := findVolume(podSpec.Volumes, "cbi-sshagent"); vol == nil || vol.EmptyDir == nil {
+			t.Fatalf("%d: expected emptyDir for the socket, got %+v", i, vol)
+		}
+		expectedKeysMount := corev1.VolumeMount{Name: "cbi-sshagentkeys", MountPath: "/cbi-sshagentkeys", ReadOnly: true}
+		if mount := findVolumeMount(container.VolumeMounts, "cbi-sshagentkeys"); !reflect.DeepEqual(mount, &expectedKeysMount) {
+			t.Fatalf("%d: expected volume mount %+v, got %+v", i, expectedKeysMount, mount)
+		}
+		// the keys are never mounted on the init containers
+		for _, c := range podSpec.InitContainers {
+			if findVolumeMount(c.VolumeMounts, "cbi-sshagentkeys") != nil {
+				t.Fatalf("%d: the keys are mounted on the init container %q", i, c.Name)
+			}
+		}
+	}
+}
+
+func findVolume(vols []corev1.Volume, name string) *corev1.Volume {
+	for i := range vols {
+		if vols[i].Name == name {
+			return &vols[i]
+		}
+	}
+	return nil
+}
+
+func findVolumeMount(mounts []corev1.VolumeMount, name string) *corev1.VolumeMount {
+	for i := range mounts {
+		if mounts[i].Name == name {
+			return &mounts[i]
+		}
+	}
+	return nil
+}
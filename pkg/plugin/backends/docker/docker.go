@@ -26,7 +26,9 @@ import (
 	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
 	pluginapi "github.com/containerbuilding/cbi/pkg/plugin/api"
 	"github.com/containerbuilding/cbi/pkg/plugin/base"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cacheutil"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/outpututil"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/registryutil"
 )
 
@@ -42,11 +44,15 @@ func (b *Docker) Info(ctx context.Context, req *pluginapi.InfoRequest) (*plugina
 		Labels: map[string]string{
 			pluginapi.LPluginName:                           "docker",
 			pluginapi.LLanguage(crd.LanguageKindDockerfile): "",
+			pluginapi.LBuildCache:                           "",
 		},
 	}
 	for k, v := range cbipluginhelper.Labels {
 		res.Labels[k] = v
 	}
+	for k, v := range outpututil.Labels(pluginapi.OutputFormatDocker) {
+		res.Labels[k] = v
+	}
 	return res, nil
 }
 
@@ -116,8 +122,12 @@ func (b *Docker) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJo
 		return nil, fmt.Errorf("unsupported Spec.Language: %v", buildJob.Spec.Language)
 	}
 	podSpec := b.commonPodSpec(buildJob)
-	if buildJob.Spec.Registry.Push && buildJob.Spec.Registry.SecretRef.Name != "" {
-		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", buildJob.Spec.Registry.SecretRef); err != nil {
+	secretRef, err := registryutil.SecretRef(buildJob.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if secretRef.Name != "" {
+		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", secretRef); err != nil {
 			return nil, err
 		}
 	}
@@ -130,6 +140,22 @@ func (b *Docker) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJo
 		return nil, err
 	}
 	podSpec.Containers[0].Command = []string{dbpPath}
+	// docker exports the cache by pushing the resulting image to the cache ref,
+	// which corresponds to BuildCacheModeMin.
+	if err := cacheutil.CheckMode(buildJob.Spec.Cache, "docker", crd.BuildCacheModeMin); err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, cacheutil.DBPEnv(buildJob.Spec.Cache.Ref)...)
+	outputPath, err := outpututil.Inject(injector, buildJob.Spec, pluginapi.OutputFormatDocker)
+	if err != nil {
+		return nil, err
+	}
+	if outputPath != "" {
+		podSpec.Containers[0].Env = append(podSpec.Containers[0].Env, corev1.EnvVar{
+			Name:  "DBP_OUTPUT_FILE",
+			Value: outputPath,
+		})
+	}
 	ctxInjector := cbipluginhelper.ContextInjector{
 		Injector: injector,
 	}
@@ -0,0 +1,224 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package factory provides the flag definitions and the constructors for the
+// built-in backends, so that they can be shared between the single-backend
+// `cbi-*` commands and the multiplexed `cbi-plugins` command.
+package factory
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/acb"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/buildah"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/buildkit"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/docker"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/gcb"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/img"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/kaniko"
+	"github.com/containerbuilding/cbi/pkg/plugin/backends/s2i"
+	"github.com/containerbuilding/cbi/pkg/plugin/base"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/storageutil"
+)
+
+// CreateBackendFunc creates a backend.
+// CreateBackendFunc MUST NOT be called before parsing the flags.
+type CreateBackendFunc func() (base.Backend, error)
+
+// Factory registers the backend-specific flags to fs and returns CreateBackendFunc.
+// The name of each flag is prefixed with prefix. (e.g. "docker." for "-docker.docker-image")
+type Factory func(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc
+
+// Factories is the set of the built-in backend factories, keyed by the plugin name.
+var Factories = map[string]Factory{
+	"acb":      ACB,
+	"buildah":  Buildah,
+	"buildkit": BuildKit,
+	"docker":   Docker,
+	"gcb":      GCB,
+	"img":      Img,
+	"kaniko":   Kaniko,
+	"s2i":      S2I,
+}
+
+// Names returns the sorted names of Factories.
+func Names() []string {
+	var names []string
+	for k := range Factories {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HelperFlags registers the `-helper-image` flag (with prefix) and returns the helper.
+// The returned helper is filled when fs is parsed.
+func HelperFlags(fs *flag.FlagSet, prefix string) *cbipluginhelper.Helper {
+	helper := &cbipluginhelper.Helper{
+		HomeDir: "/root",
+	}
+	fs.StringVar(&helper.Image, prefix+"helper-image", "", "cbipluginhelper image")
+	return helper
+}
+
+// storageFlags registers the flags for persisting the local image store at mountPath.
+// The returned storage is filled when fs is parsed.
+func storageFlags(fs *flag.FlagSet, prefix, mountPath string) *storageutil.Storage {
+	storage := &storageutil.Storage{}
+	fs.StringVar(&storage.ClaimName, prefix+"storage-claim", "", fmt.Sprintf("PersistentVolumeClaim mounted on %s, shared across the builds (default: not persisted)", mountPath))
+	fs.BoolVar(&storage.PerRepository, prefix+"storage-per-repository", false, "use a sub directory of the storage claim per the repository of the target image")
+	return storage
+}
+
+// checkRequired checks that the helper image and the flags are non-empty.
+// nameValuePairs are the pairs of the unprefixed flag name and the flag value.
+func checkRequired(prefix string, helper *cbipluginhelper.Helper, nameValuePairs ...string) error {
+	if helper.Image == "" {
+		return fmt.Errorf("no helper-image provided")
+	}
+	for i := 0; i+1 < len(nameValuePairs); i += 2 {
+		if nameValuePairs[i+1] == "" {
+			return fmt.Errorf("no %s%s provided", prefix, nameValuePairs[i])
+		}
+	}
+	return nil
+}
+
+func ACB(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var image string
+	fs.StringVar(&image, prefix+"az-image", "", "az image")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper, "az-image", image); err != nil {
+			return nil, err
+		}
+		return &acb.ACB{
+			Helper: *helper,
+			Image:  image,
+		}, nil
+	}
+}
+
+func Buildah(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var image string
+	fs.StringVar(&image, prefix+"buildah-image", "", "image with /docker-build-push.sh, used for running buildah job")
+	storage := storageFlags(fs, prefix, "/var/lib/containers/storage")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper, "buildah-image", image); err != nil {
+			return nil, err
+		}
+		return &buildah.Buildah{
+			Helper:  *helper,
+			Image:   image,
+			Storage: *storage,
+		}, nil
+	}
+}
+
+func BuildKit(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var (
+		buildctlImage string
+		buildkitdAddr string
+	)
+	fs.StringVar(&buildctlImage, prefix+"buildctl-image", "", "image used for running buildctl job")
+	fs.StringVar(&buildkitdAddr, prefix+"buildkitd-addr", "", "buildkitd address (e.g. tcp://service:1234)")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper,
+			"buildctl-image", buildctlImage,
+			"buildkitd-addr", buildkitdAddr); err != nil {
+			return nil, err
+		}
+		return &buildkit.BuildKit{
+			Helper:        *helper,
+			BuildctlImage: buildctlImage,
+			BuildkitdAddr: buildkitdAddr,
+		}, nil
+	}
+}
+
+func Docker(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var image string
+	fs.StringVar(&image, prefix+"docker-image", "", "image with /docker-build-push.sh, used for running docker job")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper, "docker-image", image); err != nil {
+			return nil, err
+		}
+		return &docker.Docker{
+			Helper: *helper,
+			Image:  image,
+		}, nil
+	}
+}
+
+func GCB(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var image string
+	fs.StringVar(&image, prefix+"gcloud-image", "", "gcloud image")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper, "gcloud-image", image); err != nil {
+			return nil, err
+		}
+		return &gcb.GCB{
+			Helper: *helper,
+			Image:  image,
+		}, nil
+	}
+}
+
+func Img(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var image string
+	fs.StringVar(&image, prefix+"img-image", "", "image with /docker-build-push.sh, used for running img job")
+	storage := storageFlags(fs, prefix, "/root/.local/share/img")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper, "img-image", image); err != nil {
+			return nil, err
+		}
+		return &img.Img{
+			Helper:  *helper,
+			Image:   image,
+			Storage: *storage,
+		}, nil
+	}
+}
+
+func Kaniko(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var image string
+	fs.StringVar(&image, prefix+"kaniko-image", "", "kaniko image")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper, "kaniko-image", image); err != nil {
+			return nil, err
+		}
+		return &kaniko.Kaniko{
+			Helper: *helper,
+			Image:  image,
+		}, nil
+	}
+}
+
+func S2I(fs *flag.FlagSet, prefix string, helper *cbipluginhelper.Helper) CreateBackendFunc {
+	var image string
+	fs.StringVar(&image, prefix+"s2i-image", "", "s2i image")
+	return func() (base.Backend, error) {
+		if err := checkRequired(prefix, helper, "s2i-image", image); err != nil {
+			return nil, err
+		}
+		return &s2i.S2I{
+			Helper: *helper,
+			Image:  image,
+		}, nil
+	}
+}
@@ -28,11 +28,14 @@ import (
 	"github.com/containerbuilding/cbi/pkg/plugin/base"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/registryutil"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/storageutil"
 )
 
 type Img struct {
 	Image  string
 	Helper cbipluginhelper.Helper
+	// Storage is mounted on /root/.local/share/img.
+	Storage storageutil.Storage
 }
 
 var _ base.Backend = &Img{}
@@ -101,8 +104,12 @@ func (b *Img) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJob)
 		return nil, fmt.Errorf("unsupported Spec.Language: %v", buildJob.Spec.Language)
 	}
 	podSpec := b.commonPodSpec(buildJob)
-	if buildJob.Spec.Registry.Push && buildJob.Spec.Registry.SecretRef.Name != "" {
-		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", buildJob.Spec.Registry.SecretRef); err != nil {
+	secretRef, err := registryutil.SecretRef(buildJob.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if secretRef.Name != "" {
+		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", secretRef); err != nil {
 			return nil, err
 		}
 	}
@@ -125,6 +132,9 @@ func (b *Img) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJob)
 	podSpec.Containers[0].Command = append(podSpec.Containers[0].Command, []string{
 		ctxPath,
 	}...)
+	if err := b.Storage.Inject(injector, "img-state-volume", "/root/.local/share/img", buildJob.Spec.Registry.Target); err != nil {
+		return nil, err
+	}
 	return &corev1.PodTemplateSpec{
 		Spec: podSpec,
 	}, nil
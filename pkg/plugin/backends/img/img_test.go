@@ -0,0 +1,73 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package img
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	pluginapi "github.com/containerbuilding/cbi/pkg/plugin/api"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+)
+
+func TestCreatePodTemplateSpec(t *testing.T) {
+	b := &Img{
+		Image:  "img",
+		Helper: cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	bj := crd.BuildJob{
+		Spec: crd.BuildJobSpec{
+			Registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+			Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+			Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+		},
+	}
+	podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := podTemplateSpec.Spec.Containers[0]
+	if len(c.Command) != 2 || !strings.HasSuffix(c.Command[0], "/docker-build-push.sh") {
+		t.Fatalf("expected docker-build-push.sh with the context path, got %v", c.Command)
+	}
+	expectedEnv := []corev1.EnvVar{
+		{Name: "DBP_DOCKER_BINARY", Value: "img"},
+		{Name: "DBP_IMAGE_NAME", Value: "example.com/foo:latest"},
+		{Name: "DBP_DIALECT", Value: "docker"},
+		{Name: "DBP_PUSH", Value: "1"},
+	}
+	if !reflect.DeepEqual(expectedEnv, c.Env) {
+		t.Fatalf("expected %v, got %v", expectedEnv, c.Env)
+	}
+}
+
+// TestInfo tests that img does not claim the build cache, as "img build" has no --cache-from flag.
+func TestInfo(t *testing.T) {
+	b := &Img{}
+	res, err := b.Info(context.Background(), &pluginapi.InfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := res.Labels[pluginapi.LBuildCache]; ok {
+		t.Fatalf("unexpected label %q", pluginapi.LBuildCache)
+	}
+}
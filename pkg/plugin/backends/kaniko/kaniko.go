@@ -26,7 +26,9 @@ import (
 	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
 	pluginapi "github.com/containerbuilding/cbi/pkg/plugin/api"
 	"github.com/containerbuilding/cbi/pkg/plugin/base"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cacheutil"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/outpututil"
 	"github.com/containerbuilding/cbi/pkg/plugin/base/registryutil"
 )
 
@@ -42,11 +44,15 @@ func (b *Kaniko) Info(ctx context.Context, req *pluginapi.InfoRequest) (*plugina
 		Labels: map[string]string{
 			pluginapi.LPluginName:                           "kaniko",
 			pluginapi.LLanguage(crd.LanguageKindDockerfile): "",
+			pluginapi.LBuildCache:                           "",
 		},
 	}
 	for k, v := range cbipluginhelper.Labels {
 		res.Labels[k] = v
 	}
+	for k, v := range outpututil.Labels(pluginapi.OutputFormatDocker) {
+		res.Labels[k] = v
+	}
 	return res, nil
 }
 
@@ -71,8 +77,12 @@ func (b *Kaniko) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJo
 		return nil, fmt.Errorf("unsupported Spec.Language: %v", buildJob.Spec.Language)
 	}
 	podSpec := b.commonPodSpec(buildJob)
-	if buildJob.Spec.Registry.Push && buildJob.Spec.Registry.SecretRef.Name != "" {
-		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", buildJob.Spec.Registry.SecretRef); err != nil {
+	secretRef, err := registryutil.SecretRef(buildJob.Spec)
+	if err != nil {
+		return nil, err
+	}
+	if secretRef.Name != "" {
+		if err := registryutil.InjectRegistrySecret(&podSpec, 0, "/root", secretRef); err != nil {
 			return nil, err
 		}
 	}
@@ -80,6 +90,14 @@ func (b *Kaniko) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJo
 		Helper:        b.Helper,
 		TargetPodSpec: &podSpec,
 	}
+	if k := buildJob.Spec.Output.Kind; k != "" && !strings.EqualFold(string(k), string(crd.OutputKindRegistry)) {
+		// the entrypoint of the kaniko image, wrapped with `cbipluginhelper output`
+		podSpec.Containers[0].Command = []string{"/kaniko/executor"}
+	}
+	outputPath, err := outpututil.Inject(injector, buildJob.Spec, pluginapi.OutputFormatDocker)
+	if err != nil {
+		return nil, err
+	}
 	ctxInjector := cbipluginhelper.ContextInjector{
 		Injector: injector,
 	}
@@ -93,10 +111,31 @@ func (b *Kaniko) CreatePodTemplateSpec(ctx context.Context, buildJob crd.BuildJo
 		"--context=" + ctxPath,
 		"--destination=" + buildJob.Spec.Registry.Target,
 	}...)
-	if !buildJob.Spec.Registry.Push {
+	switch {
+	case outputPath != "":
+		// kaniko writes the tarball in the `docker save` format
+		podSpec.Containers[0].Args = append(podSpec.Containers[0].Args, "--tarPath="+outputPath)
+	case !buildJob.Spec.Registry.Push:
 		podSpec.Containers[0].Args = append(podSpec.Containers[0].Args, "--tarPath=/dev/null")
 	}
+	cacheArgs, err := cacheArgs(buildJob.Spec.Cache)
+	if err != nil {
+		return nil, err
+	}
+	podSpec.Containers[0].Args = append(podSpec.Containers[0].Args, cacheArgs...)
 	return &corev1.PodTemplateSpec{
 		Spec: podSpec,
 	}, nil
 }
+
+// cacheArgs returns the kaniko args for importing and exporting the cache.
+// kaniko caches the layers of all the RUN instructions, which corresponds to BuildCacheModeMax.
+func cacheArgs(cache crd.BuildCache) ([]string, error) {
+	if cache.Ref == "" {
+		return nil, nil
+	}
+	if err := cacheutil.CheckMode(cache, "kaniko", crd.BuildCacheModeMax); err != nil {
+		return nil, err
+	}
+	return []string{"--cache=true", "--cache-repo=" + cache.Ref}, nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kaniko
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+)
+
+func TestCreatePodTemplateSpecCache(t *testing.T) {
+	testCases := []struct {
+		cache       crd.BuildCache
+		expected    []string
+		expectedErr bool
+	}{
+		{
+			cache:    crd.BuildCache{},
+			expected: nil,
+		},
+		{
+			cache:    crd.BuildCache{Ref: "example.com/foo/cache"},
+			expected: []string{"--cache=true", "--cache-repo=example.com/foo/cache"},
+		},
+		{
+			// kaniko always caches the layers of all the RUN instructions
+			cache:       crd.BuildCache{Ref: "example.com/foo/cache", Mode: crd.BuildCacheModeMin},
+			expectedErr: true,
+		},
+	}
+	b := &Kaniko{
+		Image:  "kaniko",
+		Helper: cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Cache:    tc.cache,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			args := podTemplateSpec.Spec.Containers[0].Args
+			joined := strings.Join(args, " ")
+			if tc.expected == nil {
+				if strings.Contains(joined, "--cache") {
+					t.Fatalf("%d: unexpected cache args: %v", i, args)
+				}
+			} else if !strings.Contains(joined, strings.Join(tc.expected, " ")) {
+				t.Fatalf("%d: expected %v in %v", i, tc.expected, args)
+			}
+		}
+	}
+}
+
+func TestCreatePodTemplateSpecOutput(t *testing.T) {
+	testCases := []struct {
+		registry     crd.Registry
+		output       crd.Output
+		expectedFile string
+		expectedErr  bool
+	}{
+		{
+			registry:     crd.Registry{Target: "example.com/foo:latest", Push: true},
+			output:       crd.Output{},
+			expectedFile: "",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest"},
+			output: crd.Output{
+				Kind:       crd.OutputKindOCITarball,
+				OCITarball: crd.OCITarballOutput{ClaimName: "images", Path: "foo/foo.tar"},
+			},
+			// `docker save` archive is not an OCI tarball
+			expectedErr: true,
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest"},
+			output: crd.Output{
+				Kind:          crd.OutputKindDockerTarball,
+				DockerTarball: crd.DockerTarballOutput{ClaimName: "images", Path: "foo/foo.tar"},
+			},
+			expectedFile: "/cbi-output/foo/foo.tar",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest"},
+			output: crd.Output{
+				Kind:       crd.OutputKindHTTPUpload,
+				HTTPUpload: crd.HTTPUploadOutput{URL: "https://example.com/foo.tar"},
+			},
+			expectedFile: "/cbi-output/image.tar",
+		},
+		{
+			registry: crd.Registry{Target: "example.com/foo:latest", Push: true},
+			output: crd.Output{
+				Kind:       crd.OutputKindOCITarball,
+				OCITarball: crd.OCITarballOutput{ClaimName: "images", Path: "foo/foo.tar"},
+			},
+			expectedErr: true,
+		},
+	}
+	b := &Kaniko{
+		Image:  "kaniko",
+		Helper: cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+	}
+	for i, tc := range testCases {
+		bj := crd.BuildJob{
+			Spec: crd.BuildJobSpec{
+				Registry: tc.registry,
+				Language: crd.Language{Kind: crd.LanguageKindDockerfile},
+				Context:  crd.Context{Kind: crd.ContextKindGit, Git: crd.Git{URL: "https://example.com/foo.git"}},
+				Output:   tc.output,
+			},
+		}
+		podTemplateSpec, err := b.CreatePodTemplateSpec(context.Background(), bj)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			container := podTemplateSpec.Spec.Containers[0]
+			command := strings.Join(container.Command, " ")
+			if tc.expectedFile == "" {
+				if strings.Contains(command, " output ") {
+					t.Fatalf("%d: unexpected command: %q", i, command)
+				}
+				continue
+			}
+			if !strings.Contains(command, " output --file "+tc.expectedFile+" ") || !strings.HasSuffix(command, " -- /kaniko/executor") {
+				t.Fatalf("%d: unexpected command: %q", i, command)
+			}
+			args := strings.Join(container.Args, " ")
+			if !strings.Contains(args, "--tarPath="+tc.expectedFile) || strings.Contains(args, "/dev/null") {
+				t.Fatalf("%d: unexpected args: %q", i, args)
+			}
+		}
+	}
+}
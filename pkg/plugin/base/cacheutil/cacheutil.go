@@ -0,0 +1,53 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cacheutil provides the helpers for translating BuildJob.Spec.Cache.
+package cacheutil
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+// CheckMode returns an error when cache is enabled with a mode other than mode,
+// the only mode supported by the backend. The empty mode is accepted as mode.
+func CheckMode(cache crd.BuildCache, backend string, mode crd.BuildCacheMode) error {
+	if cache.Ref == "" {
+		return nil
+	}
+	if m := cache.Mode; m != "" && !strings.EqualFold(string(m), string(mode)) {
+		return fmt.Errorf("unsupported cache mode: %q (%s supports only %q)", m, backend, mode)
+	}
+	return nil
+}
+
+// DBPEnv returns the env vars for docker-build-push.sh for importing the cache from ref
+// and exporting the cache to ref. Nil is returned when ref is empty.
+func DBPEnv(ref string) []corev1.EnvVar {
+	if ref == "" {
+		return nil
+	}
+	return []corev1.EnvVar{
+		{
+			Name:  "DBP_CACHE_REF",
+			Value: ref,
+		},
+	}
+}
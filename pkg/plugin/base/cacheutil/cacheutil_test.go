@@ -0,0 +1,63 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cacheutil
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+func TestCheckMode(t *testing.T) {
+	testCases := []struct {
+		cache       crd.BuildCache
+		mode        crd.BuildCacheMode
+		expectedErr bool
+	}{
+		{cache: crd.BuildCache{}, mode: crd.BuildCacheModeMin},
+		// the mode is ignored when the cache is disabled
+		{cache: crd.BuildCache{Mode: crd.BuildCacheModeMax}, mode: crd.BuildCacheModeMin},
+		{cache: crd.BuildCache{Ref: "example.com/foo:buildcache"}, mode: crd.BuildCacheModeMin},
+		{cache: crd.BuildCache{Ref: "example.com/foo:buildcache"}, mode: crd.BuildCacheModeMax},
+		{cache: crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: crd.BuildCacheModeMin}, mode: crd.BuildCacheModeMin},
+		{cache: crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: "MAX"}, mode: crd.BuildCacheModeMax},
+		{cache: crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: crd.BuildCacheModeMax}, mode: crd.BuildCacheModeMin, expectedErr: true},
+		{cache: crd.BuildCache{Ref: "example.com/foo:buildcache", Mode: "foo"}, mode: crd.BuildCacheModeMax, expectedErr: true},
+	}
+	for i, tc := range testCases {
+		err := CheckMode(tc.cache, "foo", tc.mode)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil && tc.expectedErr {
+			t.Fatalf("%d: error is expected", i)
+		}
+	}
+}
+
+func TestDBPEnv(t *testing.T) {
+	if env := DBPEnv(""); env != nil {
+		t.Fatalf("expected nil, got %v", env)
+	}
+	expected := []corev1.EnvVar{{Name: "DBP_CACHE_REF", Value: "example.com/foo:buildcache"}}
+	if env := DBPEnv("example.com/foo:buildcache"); !reflect.DeepEqual(expected, env) {
+		t.Fatalf("expected %v, got %v", expected, env)
+	}
+}
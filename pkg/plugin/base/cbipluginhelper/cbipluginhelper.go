@@ -18,9 +18,12 @@ package cbipluginhelper
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/cyphar/filepath-securejoin"
@@ -92,36 +95,174 @@ func (ci *Injector) InjectFile(srcPath string) (string, error) {
 // ContextInjector injects build contexts using `cbipluginhelper` image.
 type ContextInjector struct {
 	Injector
+	// name is the name of the named context, used for suffixing the volumes and the init containers.
+	// Empty for the main context.
+	name string
+}
+
+func (ci *ContextInjector) suffixed(s string) string {
+	if ci.name == "" {
+		return s
+	}
+	return s + "-" + ci.name
+}
+
+// namedContextNameRegexp limits the length so that the suffixed volume names
+// and container names do not exceed 63 characters.
+var namedContextNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]{0,30}[a-z0-9])?$`)
+
+// InjectNamed injects the named contexts to podSpec and returns the context
+// paths in the same order as contexts.
+func (ci *ContextInjector) InjectNamed(contexts []crd.NamedContext) ([]string, error) {
+	var paths []string
+	seen := make(map[string]struct{})
+	for i, nc := range contexts {
+		if !namedContextNameRegexp.MatchString(nc.Name) {
+			return nil, fmt.Errorf("contexts[%d]: invalid name %q: must consist of lower case alphanumeric characters or '-', up to 32 characters", i, nc.Name)
+		}
+		if _, ok := seen[nc.Name]; ok {
+			return nil, fmt.Errorf("contexts[%d]: duplicated name %q", i, nc.Name)
+		}
+		seen[nc.Name] = struct{}{}
+		switch k := strings.ToLower(string(nc.Context.Kind)); k {
+		case strings.ToLower(string(crd.ContextKindLocal)), strings.ToLower(string(crd.ContextKindBuildKitSession)):
+			// only a single directory can be uploaded or streamed from the client
+			return nil, fmt.Errorf("contexts[%d]: %s context cannot be used as a named context", i, nc.Context.Kind)
+		}
+		named := *ci
+		named.name = nc.Name
+		p, err := named.Inject(nc.Context)
+		if err != nil {
+			return nil, fmt.Errorf("contexts[%d]: %v", i, err)
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
 }
 
 // Inject injects a context to podSpec and returns the context path
 func (ci *ContextInjector) Inject(bjContext crd.Context) (string, error) {
+	var (
+		contextPath string
+		err         error
+	)
 	switch k := strings.ToLower(string(bjContext.Kind)); k {
 	case strings.ToLower(string(crd.ContextKindConfigMap)):
-		return ci.injectConfigMap(bjContext.ConfigMapRef)
+		contextPath, err = ci.injectConfigMap(bjContext.ConfigMapRef)
 	case strings.ToLower(string(crd.ContextKindGit)):
-		return ci.injectGit(bjContext.Git)
+		contextPath, err = ci.injectGit(bjContext.Git, bjContext.Cache)
 	case strings.ToLower(string(crd.ContextKindHTTP)):
-		return ci.injectHTTP(bjContext.HTTP)
+		contextPath, err = ci.injectHTTP(bjContext.HTTP, bjContext.Cache)
 	case strings.ToLower(string(crd.ContextKindRclone)):
-		return ci.injectRclone(bjContext.Rclone)
+		contextPath, err = ci.injectRclone(bjContext.Rclone)
+	case strings.ToLower(string(crd.ContextKindLocal)):
+		contextPath, err = ci.injectLocal(bjContext.Local)
+	case strings.ToLower(string(crd.ContextKindOCI)):
+		contextPath, err = ci.injectOCI(bjContext.OCI, bjContext.Cache)
+	case strings.ToLower(string(crd.ContextKindS3)):
+		contextPath, err = ci.injectS3(bjContext.S3)
 	default:
 		return "", fmt.Errorf("unsupported Spec.Context: %v", k)
 	}
+	if err != nil {
+		return "", err
+	}
+	if err := ci.injectPrepare(contextPath, bjContext.Limits); err != nil {
+		return "", err
+	}
+	return contextPath, nil
+}
+
+// injectPrepare appends the init container that applies .dockerignore to the
+// context populated by the last init container, and checks the limits.
+func (ci *ContextInjector) injectPrepare(contextPath string, limits crd.ContextLimits) error {
+	if limits.MaxBytes < 0 || limits.MaxFiles < 0 {
+		return fmt.Errorf("context limits must not be negative")
+	}
+	n := len(ci.TargetPodSpec.InitContainers)
+	if n == 0 {
+		return fmt.Errorf("no init container populates %s", contextPath)
+	}
+	var vol *corev1.VolumeMount
+	for _, m := range ci.TargetPodSpec.InitContainers[n-1].VolumeMounts {
+		if strings.HasPrefix(contextPath, m.MountPath+"/") {
+			m := m
+			vol = &m
+			break
+		}
+	}
+	if vol == nil {
+		return fmt.Errorf("no volume is mounted on %s", contextPath)
+	}
+	// e.g. "cbi-gitcontext-prepare", "cbi-gitcontext-prepare-assets"
+	volName := vol.Name
+	if ci.name != "" {
+		volName = strings.TrimSuffix(volName, "-"+ci.name)
+	}
+	name := ci.suffixed(volName + "-prepare")
+	args := []string{"prepare-context"}
+	if limits.MaxBytes > 0 {
+		args = append(args, "--max-bytes", strconv.FormatInt(limits.MaxBytes, 10))
+	}
+	if limits.MaxFiles > 0 {
+		args = append(args, "--max-files", strconv.Itoa(limits.MaxFiles))
+	}
+	ci.TargetPodSpec.InitContainers = append(ci.TargetPodSpec.InitContainers, corev1.Container{
+		Name:         name,
+		Image:        ci.Helper.Image,
+		Args:         append(args, contextPath),
+		VolumeMounts: []corev1.VolumeMount{*vol},
+	})
+	return nil
+}
+
+// injectContextCache mounts the context cache claim to the init container, and
+// returns the args for the populate-* command.
+// The claim is not mounted to the build container.
+func (ci *ContextInjector) injectContextCache(cache crd.ContextCache, initContainer *corev1.Container) []string {
+	const (
+		volName      = "cbi-contextcache"
+		volMountPath = "/cbi-contextcache"
+	)
+	if cache.ClaimName == "" {
+		return nil
+	}
+	found := false
+	for _, v := range ci.TargetPodSpec.Volumes {
+		if v.Name == volName {
+			found = true
+			break
+		}
+	}
+	if !found {
+		ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
+			Name: volName,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: cache.ClaimName,
+				},
+			},
+		})
+	}
+	initContainer.VolumeMounts = append(initContainer.VolumeMounts, corev1.VolumeMount{
+		Name:      volName,
+		MountPath: volMountPath,
+	})
+	return []string{"--cache-dir", volMountPath}
 }
 
 // injectConfigMap injects a config map to podSpec and returns the context path
 func (ci *ContextInjector) injectConfigMap(configMapRef corev1.LocalObjectReference) (string, error) {
-	const (
+	var (
 		// cmVol is a configmap volume (with symlinks)
-		cmVolName      = "cbi-cmcontext-tmp"
-		cmVolMountPath = "/cbi-cmcontext-tmp"
+		cmVolName      = ci.suffixed("cbi-cmcontext-tmp")
+		cmVolMountPath = ci.suffixed("/cbi-cmcontext-tmp")
 		// vol is an emptyDir volume (without symlinks)
-		volName           = "cbi-cmcontext"
-		volMountPath      = "/cbi-cmcontext"
+		volName           = ci.suffixed("cbi-cmcontext")
+		volMountPath      = ci.suffixed("/cbi-cmcontext")
 		volContextSubpath = "context"
 		// initContainer is used for converting cmVol to vol so as to eliminate symlinks
-		initContainerName = "cbi-cmcontext-init"
+		initContainerName = ci.suffixed("cbi-cmcontext-init")
 	)
 	idx := ci.TargetContainerIdx
 	contextPath, _ := securejoin.SecureJoin(volMountPath, volContextSubpath)
@@ -166,14 +307,14 @@ func (ci *ContextInjector) injectConfigMap(configMapRef corev1.LocalObjectRefere
 }
 
 // injectGit injects a git repo to podSpec and returns the context path
-func (ci *ContextInjector) injectGit(spec crd.Git) (string, error) {
-	const (
+func (ci *ContextInjector) injectGit(spec crd.Git, cache crd.ContextCache) (string, error) {
+	var (
 		// vol is an emptyDir volume
-		volName           = "cbi-gitcontext"
-		volMountPath      = "/cbi-gitcontext"
+		volName           = ci.suffixed("cbi-gitcontext")
+		volMountPath      = ci.suffixed("/cbi-gitcontext")
 		volContextSubpath = "context"
 		// initContainer is used for converting cmVol to vol so as to eliminate symlinks
-		initContainerName = "cbi-gitcontext-init"
+		initContainerName = ci.suffixed("cbi-gitcontext-init")
 	)
 	idx := ci.TargetContainerIdx
 
@@ -191,10 +332,54 @@ func (ci *ContextInjector) injectGit(spec crd.Git) (string, error) {
 	)
 
 	contextPath, _ := securejoin.SecureJoin(volMountPath, volContextSubpath)
+	args := []string{"populate-git", "--revision", spec.Revision}
+	if spec.Depth > 0 {
+		args = append(args, "--depth", strconv.Itoa(spec.Depth))
+	}
+	if spec.Submodules {
+		args = append(args, "--submodules")
+	}
+	for _, p := range spec.SparsePaths {
+		args = append(args, "--sparse-path", p)
+	}
+	if spec.LFS {
+		args = append(args, "--lfs")
+	}
+	var (
+		credentialsVolName      = ci.suffixed("cbi-gitcredentials")
+		credentialsVolMountPath = ci.suffixed("/cbi-gitcredentials")
+	)
+	credentialsSecretName := spec.BasicAuthSecretRef.Name
+	if spec.TokenSecretRef.Name != "" {
+		if credentialsSecretName != "" {
+			return "", fmt.Errorf("basicAuthSecretRef and tokenSecretRef are mutually exclusive")
+		}
+		credentialsSecretName = spec.TokenSecretRef.Name
+	}
+	if credentialsSecretName != "" {
+		args = append(args, "--credentials-dir", credentialsVolMountPath)
+	}
+	var (
+		verifyKeysVolName      = ci.suffixed("cbi-gitverifykeys")
+		verifyKeysVolMountPath = ci.suffixed("/cbi-gitverifykeys")
+	)
+	verifyKeysVol, err := gitVerifyKeysVolume(verifyKeysVolName, spec.Verify)
+	if err != nil {
+		return "", err
+	}
+	if verifyKeysVol != nil {
+		args = append(args, "--verify-keys-dir", verifyKeysVolMountPath,
+			"--verify-format", strings.ToLower(string(spec.Verify.Format)))
+		if spec.Verify.Tag {
+			if spec.Revision == "" {
+				return "", fmt.Errorf("verify.tag requires revision")
+			}
+			args = append(args, "--verify-tag")
+		}
+	}
 	initContainer := corev1.Container{
 		Name:  initContainerName,
 		Image: ci.Helper.Image,
-		Args:  []string{"populate-git", "--revision", spec.Revision, spec.URL, contextPath},
 		VolumeMounts: []corev1.VolumeMount{
 			{
 				Name:      volName,
@@ -202,6 +387,8 @@ func (ci *ContextInjector) injectGit(spec crd.Git) (string, error) {
 			},
 		},
 	}
+	args = append(args, ci.injectContextCache(cache, &initContainer)...)
+	initContainer.Args = append(args, spec.URL, contextPath)
 	if spec.SubPath != "" {
 		var err error
 		contextPath, err = securejoin.SecureJoin(contextPath, spec.SubPath)
@@ -210,7 +397,7 @@ func (ci *ContextInjector) injectGit(spec crd.Git) (string, error) {
 		}
 	}
 	if secretName := spec.SSHSecretRef.Name; secretName != "" {
-		const sshVolName = "cbi-gitsshsecret"
+		sshVolName := ci.suffixed("cbi-gitsshsecret")
 		sshVolMountPath, err := securejoin.SecureJoin(ci.Helper.HomeDir, ".ssh")
 		if err != nil {
 			return "", err
@@ -231,19 +418,170 @@ func (ci *ContextInjector) injectGit(spec crd.Git) (string, error) {
 			MountPath: sshVolMountPath,
 		})
 	}
+	if credentialsSecretName != "" {
+		// the secret is read by the credential helper of populate-git,
+		// and never written to the repo
+		defaultMode := int32(0400)
+		ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
+			Name: credentialsVolName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  credentialsSecretName,
+					DefaultMode: &defaultMode,
+				},
+			},
+		})
+		initContainer.VolumeMounts = append(initContainer.VolumeMounts, corev1.VolumeMount{
+			Name:      credentialsVolName,
+			MountPath: credentialsVolMountPath,
+			ReadOnly:  true,
+		})
+	}
+	if verifyKeysVol != nil {
+		ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, *verifyKeysVol)
+		initContainer.VolumeMounts = append(initContainer.VolumeMounts, corev1.VolumeMount{
+			Name:      verifyKeysVolName,
+			MountPath: verifyKeysVolMountPath,
+			ReadOnly:  true,
+		})
+	}
 	ci.TargetPodSpec.InitContainers = append(ci.TargetPodSpec.InitContainers, initContainer)
 	return contextPath, nil
 }
 
+// gitVerifyKeysVolume returns the volume of the trusted keys, or nil if the verification is disabled.
+func gitVerifyKeysVolume(volName string, verify crd.GitVerify) (*corev1.Volume, error) {
+	switch strings.ToLower(string(verify.Format)) {
+	case "", "gpg", "ssh":
+	default:
+		return nil, fmt.Errorf("unknown verify.format: %q", verify.Format)
+	}
+	vol := &corev1.Volume{Name: volName}
+	switch {
+	case verify.SecretRef.Name != "" && verify.ConfigMapRef.Name != "":
+		return nil, fmt.Errorf("verify.secretRef and verify.configMapRef are mutually exclusive")
+	case verify.SecretRef.Name != "":
+		vol.VolumeSource.Secret = &corev1.SecretVolumeSource{
+			SecretName: verify.SecretRef.Name,
+		}
+	case verify.ConfigMapRef.Name != "":
+		vol.VolumeSource.ConfigMap = &corev1.ConfigMapVolumeSource{
+			LocalObjectReference: verify.ConfigMapRef,
+		}
+	default:
+		return nil, nil
+	}
+	return vol, nil
+}
+
 // injectHTTP injects a tar archive on HTTP site to podSpec and returns the context path
-func (ci *ContextInjector) injectHTTP(spec crd.HTTP) (string, error) {
-	const (
+func (ci *ContextInjector) injectHTTP(spec crd.HTTP, cache crd.ContextCache) (string, error) {
+	var (
+		// vol is an emptyDir volume
+		volName           = ci.suffixed("cbi-httpcontext")
+		volMountPath      = ci.suffixed("/cbi-httpcontext")
+		volContextSubpath = "context"
+		initContainerName = ci.suffixed("cbi-httpcontext-init")
+	)
+	idx := ci.TargetContainerIdx
+
+	ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
+		Name: volName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	ci.TargetPodSpec.Containers[idx].VolumeMounts = append(ci.TargetPodSpec.Containers[idx].VolumeMounts,
+		corev1.VolumeMount{
+			Name:      volName,
+			MountPath: volMountPath,
+		},
+	)
+
+	contextPath, _ := securejoin.SecureJoin(volMountPath, volContextSubpath)
+	args := []string{"populate-http"}
+	if spec.SHA256 != "" {
+		if _, err := hex.DecodeString(spec.SHA256); err != nil || len(spec.SHA256) != sha256.Size*2 {
+			return "", fmt.Errorf("invalid sha256: %q", spec.SHA256)
+		}
+		args = append(args, "--digest", "sha256:"+strings.ToLower(spec.SHA256))
+	}
+	if spec.MediaType != "" {
+		args = append(args, "--media-type", spec.MediaType)
+	}
+	if spec.InsecureSkipVerify {
+		args = append(args, "--insecure-skip-verify")
+	}
+	initContainer := corev1.Container{
+		Name:  initContainerName,
+		Image: ci.Helper.Image,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      volName,
+				MountPath: volMountPath,
+			},
+		},
+	}
+	// secrets are mounted only to the init container
+	secrets := []struct {
+		ref       corev1.LocalObjectReference
+		volName   string
+		mountPath string
+		flag      string
+		flagValue string
+	}{
+		{spec.CASecretRef, ci.suffixed("cbi-httpca"), "/cbi-httpca", "--ca-cert", "/cbi-httpca/ca.crt"},
+		{spec.HeadersSecretRef, ci.suffixed("cbi-httpheaders"), "/cbi-httpheaders", "--headers-dir", "/cbi-httpheaders"},
+	}
+	for _, sec := range secrets {
+		if sec.ref.Name == "" {
+			continue
+		}
+		defaultMode := int32(0400)
+		ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
+			Name: sec.volName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName:  sec.ref.Name,
+					DefaultMode: &defaultMode,
+				},
+			},
+		})
+		initContainer.VolumeMounts = append(initContainer.VolumeMounts, corev1.VolumeMount{
+			Name:      sec.volName,
+			MountPath: sec.mountPath,
+			ReadOnly:  true,
+		})
+		args = append(args, sec.flag, sec.flagValue)
+	}
+	// the archive cannot be looked up in the cache without the digest
+	if spec.SHA256 != "" {
+		args = append(args, ci.injectContextCache(cache, &initContainer)...)
+	}
+	initContainer.Args = append(args, spec.URL, contextPath)
+	ci.TargetPodSpec.InitContainers = append(ci.TargetPodSpec.InitContainers, initContainer)
+	if spec.SubPath != "" {
+		var err error
+		contextPath, err = securejoin.SecureJoin(contextPath, spec.SubPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	return contextPath, nil
+}
+
+// injectLocal injects a tar archive on the context server to podSpec and returns the context path
+func (ci *ContextInjector) injectLocal(spec crd.Local) (string, error) {
+	var (
 		// vol is an emptyDir volume
-		volName           = "cbi-httpcontext"
-		volMountPath      = "/cbi-httpcontext"
+		volName           = ci.suffixed("cbi-localcontext")
+		volMountPath      = ci.suffixed("/cbi-localcontext")
 		volContextSubpath = "context"
-		initContainerName = "cbi-httpcontext-init"
+		initContainerName = ci.suffixed("cbi-localcontext-init")
 	)
+	if spec.URL == "" {
+		return "", fmt.Errorf("Local context requires the URL to be set by the controller")
+	}
 	idx := ci.TargetContainerIdx
 
 	ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
@@ -263,7 +601,7 @@ func (ci *ContextInjector) injectHTTP(spec crd.HTTP) (string, error) {
 	initContainer := corev1.Container{
 		Name:  initContainerName,
 		Image: ci.Helper.Image,
-		Args:  []string{"populate-http", spec.URL, contextPath},
+		Args:  []string{"populate-http", "--digest", spec.Digest, spec.URL, contextPath},
 		VolumeMounts: []corev1.VolumeMount{
 			{
 				Name:      volName,
@@ -282,15 +620,184 @@ func (ci *ContextInjector) injectHTTP(spec crd.HTTP) (string, error) {
 	return contextPath, nil
 }
 
+// injectOCI injects the layers of an OCI image to podSpec and returns the context path
+func (ci *ContextInjector) injectOCI(spec crd.OCI, cache crd.ContextCache) (string, error) {
+	var (
+		// vol is an emptyDir volume
+		volName            = ci.suffixed("cbi-ocicontext")
+		volMountPath       = ci.suffixed("/cbi-ocicontext")
+		volContextSubpath  = "context"
+		secretVolName      = ci.suffixed("cbi-ocisecret")
+		secretVolMountPath = ci.suffixed("/cbi-ocisecret")
+		initContainerName  = ci.suffixed("cbi-ocicontext-init")
+	)
+	if spec.Ref == "" {
+		return "", fmt.Errorf("OCI context requires the ref")
+	}
+	idx := ci.TargetContainerIdx
+
+	ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
+		Name: volName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	ci.TargetPodSpec.Containers[idx].VolumeMounts = append(ci.TargetPodSpec.Containers[idx].VolumeMounts,
+		corev1.VolumeMount{
+			Name:      volName,
+			MountPath: volMountPath,
+		},
+	)
+
+	contextPath, _ := securejoin.SecureJoin(volMountPath, volContextSubpath)
+	args := []string{"populate-oci"}
+	if spec.Digest != "" {
+		args = append(args, "--digest", spec.Digest)
+	}
+	initContainer := corev1.Container{
+		Name:  initContainerName,
+		Image: ci.Helper.Image,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      volName,
+				MountPath: volMountPath,
+			},
+		},
+	}
+	if secretName := spec.SecretRef.Name; secretName != "" {
+		ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
+			Name: secretVolName,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: secretName,
+					Items: []corev1.KeyToPath{
+						{
+							Key:  ".dockerconfigjson",
+							Path: "config.json",
+						},
+					},
+				},
+			},
+		})
+		initContainer.VolumeMounts = append(initContainer.VolumeMounts, corev1.VolumeMount{
+			Name:      secretVolName,
+			MountPath: secretVolMountPath,
+			ReadOnly:  true,
+		})
+		args = append(args, "--docker-config", secretVolMountPath+"/config.json")
+	}
+	args = append(args, ci.injectContextCache(cache, &initContainer)...)
+	initContainer.Args = append(args, spec.Ref, contextPath)
+	ci.TargetPodSpec.InitContainers = append(ci.TargetPodSpec.InitContainers, initContainer)
+	if spec.SubPath != "" {
+		var err error
+		contextPath, err = securejoin.SecureJoin(contextPath, spec.SubPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	return contextPath, nil
+}
+
+// injectS3 injects an archive or objects on S3-compatible storage to podSpec and returns the context path
+func (ci *ContextInjector) injectS3(spec crd.S3) (string, error) {
+	var (
+		// vol is an emptyDir volume
+		volName           = ci.suffixed("cbi-s3context")
+		volMountPath      = ci.suffixed("/cbi-s3context")
+		volContextSubpath = "context"
+		initContainerName = ci.suffixed("cbi-s3context-init")
+	)
+	if spec.Bucket == "" {
+		return "", fmt.Errorf("S3 context requires the bucket")
+	}
+	if (spec.Key == "") == (spec.Prefix == "") {
+		return "", fmt.Errorf("S3 context requires either the key or the prefix")
+	}
+	idx := ci.TargetContainerIdx
+
+	ci.TargetPodSpec.Volumes = append(ci.TargetPodSpec.Volumes, corev1.Volume{
+		Name: volName,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	})
+	ci.TargetPodSpec.Containers[idx].VolumeMounts = append(ci.TargetPodSpec.Containers[idx].VolumeMounts,
+		corev1.VolumeMount{
+			Name:      volName,
+			MountPath: volMountPath,
+		},
+	)
+
+	contextPath, _ := securejoin.SecureJoin(volMountPath, volContextSubpath)
+	args := []string{"populate-s3", "--bucket", spec.Bucket}
+	if spec.Endpoint != "" {
+		args = append(args, "--endpoint", spec.Endpoint)
+	}
+	if spec.Region != "" {
+		args = append(args, "--region", spec.Region)
+	}
+	if spec.Key != "" {
+		args = append(args, "--key", spec.Key)
+	} else {
+		args = append(args, "--prefix", spec.Prefix)
+	}
+	if spec.PathStyle {
+		args = append(args, "--path-style")
+	}
+	initContainer := corev1.Container{
+		Name:  initContainerName,
+		Image: ci.Helper.Image,
+		Args:  append(args, contextPath),
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      volName,
+				MountPath: volMountPath,
+			},
+		},
+	}
+	if secretName := spec.SecretRef.Name; secretName != "" {
+		optional := true
+		for _, e := range []struct {
+			name, key string
+			optional  *bool
+		}{
+			{"AWS_ACCESS_KEY_ID", "accessKeyID", nil},
+			{"AWS_SECRET_ACCESS_KEY", "secretAccessKey", nil},
+			{"AWS_SESSION_TOKEN", "sessionToken", &optional},
+		} {
+			initContainer.Env = append(initContainer.Env, corev1.EnvVar{
+				Name: e.name,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: spec.SecretRef,
+						Key:                  e.key,
+						Optional:             e.optional,
+					},
+				},
+			})
+		}
+	}
+	ci.TargetPodSpec.InitContainers = append(ci.TargetPodSpec.InitContainers, initContainer)
+	if spec.SubPath != "" {
+		var err error
+		contextPath, err = securejoin.SecureJoin(contextPath, spec.SubPath)
+		if err != nil {
+			return "", err
+		}
+	}
+	return contextPath, nil
+}
+
 // injectRclone injects rclone to podSpec and returns the context path
 func (ci *ContextInjector) injectRclone(spec crd.Rclone) (string, error) {
-	const (
+	var (
 		// vol is an emptyDir volume
-		volName           = "cbi-rclonecontext"
-		volMountPath      = "/cbi-rclonecontext"
+		volName           = ci.suffixed("cbi-rclonecontext")
+		volMountPath      = ci.suffixed("/cbi-rclonecontext")
 		volContextSubpath = "context"
-		secretVolName     = "cbi-rclonesecret"
-		initContainerName = "cbi-rclonecontext-init"
+		secretVolName     = ci.suffixed("cbi-rclonesecret")
+		initContainerName = ci.suffixed("cbi-rclonecontext-init")
 	)
 	idx := ci.TargetContainerIdx
 
@@ -338,7 +845,7 @@ func (ci *ContextInjector) injectRclone(spec crd.Rclone) (string, error) {
 		},
 	}
 	if sshSecretName := spec.SSHSecretRef.Name; sshSecretName != "" {
-		const sshVolName = "cbi-rclonesshsecret"
+		sshVolName := ci.suffixed("cbi-rclonesshsecret")
 		sshVolMountPath, err := securejoin.SecureJoin(ci.Helper.HomeDir, ".ssh")
 		if err != nil {
 			return "", err
@@ -367,4 +874,7 @@ var Labels = map[string]string{
 	pluginapi.LContext(crd.ContextKindGit):       "",
 	pluginapi.LContext(crd.ContextKindHTTP):      "",
 	pluginapi.LContext(crd.ContextKindRclone):    "",
+	pluginapi.LContext(crd.ContextKindLocal):     "",
+	pluginapi.LContext(crd.ContextKindOCI):       "",
+	pluginapi.LContext(crd.ContextKindS3):        "",
 }
@@ -0,0 +1,178 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package outpututil provides the outputs of the image other than the registry.
+package outpututil
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cyphar/filepath-securejoin"
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	pluginapi "github.com/containerbuilding/cbi/pkg/plugin/api"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+)
+
+const (
+	volName      = "cbi-output"
+	volMountPath = "/cbi-output"
+	// uploadFileName is written to the emptyDir volume before being uploaded.
+	uploadFileName = "image.tar"
+)
+
+// Labels returns the plugin labels for the outputs of the builder that writes the tarball
+// in format (pluginapi.OutputFormatOCI or pluginapi.OutputFormatDocker).
+func Labels(format string) map[string]string {
+	labels := map[string]string{
+		pluginapi.LOutputFormat:                     format,
+		pluginapi.LOutput(crd.OutputKindHTTPUpload): "",
+	}
+	switch format {
+	case pluginapi.OutputFormatOCI:
+		labels[pluginapi.LOutput(crd.OutputKindOCITarball)] = ""
+	case pluginapi.OutputFormatDocker:
+		labels[pluginapi.LOutput(crd.OutputKindDockerTarball)] = ""
+	}
+	return labels
+}
+
+// Inject mounts the output volume on the target container, wraps the command of the container
+// with `cbipluginhelper output`, and returns the path where the builder needs to write the tarball.
+// format is the format of the tarball written by the builder, see Labels.
+// The builder args can be appended to the command after calling Inject.
+// Inject returns an empty path without modifying the pod spec for OutputKindRegistry.
+func Inject(injector cbipluginhelper.Injector, spec crd.BuildJobSpec, format string) (string, error) {
+	output := spec.Output
+	if output.Kind == "" || strings.EqualFold(string(output.Kind), string(crd.OutputKindRegistry)) {
+		return "", nil
+	}
+	if spec.Registry.Push {
+		return "", fmt.Errorf("Spec.Registry.Push needs to be false for Spec.Output.Kind %q", output.Kind)
+	}
+	podSpec := injector.TargetPodSpec
+	container := &podSpec.Containers[injector.TargetContainerIdx]
+	if len(container.Command) == 0 {
+		return "", fmt.Errorf("container %q has no command", container.Name)
+	}
+	vol := corev1.Volume{
+		Name: volName,
+	}
+	var (
+		file  string
+		flags []string
+	)
+	switch k := strings.ToLower(string(output.Kind)); k {
+	case strings.ToLower(string(crd.OutputKindOCITarball)):
+		if format != pluginapi.OutputFormatOCI {
+			return "", fmt.Errorf("Spec.Output.Kind %q is not supported for the tarball format %q", output.Kind, format)
+		}
+		var err error
+		file, vol.VolumeSource, err = claimFile("OCITarball", output.OCITarball.ClaimName, output.OCITarball.Path)
+		if err != nil {
+			return "", err
+		}
+	case strings.ToLower(string(crd.OutputKindDockerTarball)):
+		if format != pluginapi.OutputFormatDocker {
+			return "", fmt.Errorf("Spec.Output.Kind %q is not supported for the tarball format %q", output.Kind, format)
+		}
+		var err error
+		file, vol.VolumeSource, err = claimFile("DockerTarball", output.DockerTarball.ClaimName, output.DockerTarball.Path)
+		if err != nil {
+			return "", err
+		}
+	case strings.ToLower(string(crd.OutputKindHTTPUpload)):
+		o := output.HTTPUpload
+		if !strings.HasPrefix(o.URL, "http://") && !strings.HasPrefix(o.URL, "https://") {
+			return "", fmt.Errorf("Spec.Output.HTTPUpload.URL needs to be http:// or https://")
+		}
+		file = volMountPath + "/" + uploadFileName
+		vol.VolumeSource = corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		}
+		flags = append(flags, "--upload-url", o.URL)
+		if o.InsecureSkipVerify {
+			flags = append(flags, "--insecure-skip-verify")
+		}
+		secrets := []struct {
+			ref       corev1.LocalObjectReference
+			volName   string
+			mountPath string
+			flag      string
+			flagValue string
+		}{
+			{o.CASecretRef, "cbi-outputca", "/cbi-outputca", "--ca-cert", "/cbi-outputca/ca.crt"},
+			{o.HeadersSecretRef, "cbi-outputheaders", "/cbi-outputheaders", "--headers-dir", "/cbi-outputheaders"},
+		}
+		for _, sec := range secrets {
+			if sec.ref.Name == "" {
+				continue
+			}
+			defaultMode := int32(0400)
+			podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+				Name: sec.volName,
+				VolumeSource: corev1.VolumeSource{
+					Secret: &corev1.SecretVolumeSource{
+						SecretName:  sec.ref.Name,
+						DefaultMode: &defaultMode,
+					},
+				},
+			})
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      sec.volName,
+				MountPath: sec.mountPath,
+				ReadOnly:  true,
+			})
+			flags = append(flags, sec.flag, sec.flagValue)
+		}
+	default:
+		return "", fmt.Errorf("unsupported Spec.Output.Kind: %q", output.Kind)
+	}
+	podSpec.Volumes = append(podSpec.Volumes, vol)
+	container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+		Name:      volName,
+		MountPath: volMountPath,
+	})
+	helperPath, err := injector.InjectFile("/cbipluginhelper")
+	if err != nil {
+		return "", err
+	}
+	wrapper := append([]string{helperPath, "output", "--file", file}, flags...)
+	container.Command = append(append(wrapper, "--"), container.Command...)
+	return file, nil
+}
+
+// claimFile returns the path of the tarball within the claim volume.
+// field is the name of the field in Spec.Output, used in the errors.
+func claimFile(field, claimName, p string) (string, corev1.VolumeSource, error) {
+	if claimName == "" {
+		return "", corev1.VolumeSource{}, fmt.Errorf("Spec.Output.%s.ClaimName is required", field)
+	}
+	file, err := securejoin.SecureJoin(volMountPath, p)
+	if err != nil {
+		return "", corev1.VolumeSource{}, err
+	}
+	if file == volMountPath || strings.HasSuffix(p, "/") {
+		return "", corev1.VolumeSource{}, fmt.Errorf("invalid Spec.Output.%s.Path: %q", field, p)
+	}
+	return file, corev1.VolumeSource{
+		PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+			ClaimName: claimName,
+		},
+	}, nil
+}
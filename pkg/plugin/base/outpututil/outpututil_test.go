@@ -0,0 +1,241 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package outpututil
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	pluginapi "github.com/containerbuilding/cbi/pkg/plugin/api"
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+)
+
+func TestInject(t *testing.T) {
+	testCases := []struct {
+		spec            crd.BuildJobSpec
+		format          string
+		expectedFile    string
+		expectedWrapper string
+		expectedErr     bool
+	}{
+		{
+			spec:         crd.BuildJobSpec{},
+			expectedFile: "",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Push: true},
+				Output:   crd.Output{Kind: crd.OutputKindRegistry},
+			},
+			expectedFile: "",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:       crd.OutputKindOCITarball,
+					OCITarball: crd.OCITarballOutput{ClaimName: "foo", Path: "images/foo.tar"},
+				},
+			},
+			expectedFile:    "/cbi-output/images/foo.tar",
+			expectedWrapper: "output --file /cbi-output/images/foo.tar --",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:       crd.OutputKindOCITarball,
+					OCITarball: crd.OCITarballOutput{ClaimName: "foo", Path: "images/foo.tar"},
+				},
+			},
+			// `docker save` archive is not an OCI tarball
+			format:      pluginapi.OutputFormatDocker,
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:          crd.OutputKindDockerTarball,
+					DockerTarball: crd.DockerTarballOutput{ClaimName: "foo", Path: "images/foo.tar"},
+				},
+			},
+			format:          pluginapi.OutputFormatDocker,
+			expectedFile:    "/cbi-output/images/foo.tar",
+			expectedWrapper: "output --file /cbi-output/images/foo.tar --",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:          crd.OutputKindDockerTarball,
+					DockerTarball: crd.DockerTarballOutput{ClaimName: "foo", Path: "images/foo.tar"},
+				},
+			},
+			// OCI tarball is not a `docker save` archive
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:          crd.OutputKindDockerTarball,
+					DockerTarball: crd.DockerTarballOutput{ClaimName: "foo", Path: "images/"},
+				},
+			},
+			format:      pluginapi.OutputFormatDocker,
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:       "ocitarball",
+					OCITarball: crd.OCITarballOutput{ClaimName: "foo", Path: "../../foo.tar"},
+				},
+			},
+			// securejoin confines the path within the volume
+			expectedFile:    "/cbi-output/foo.tar",
+			expectedWrapper: "output --file /cbi-output/foo.tar --",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Push: true},
+				Output: crd.Output{
+					Kind:       crd.OutputKindOCITarball,
+					OCITarball: crd.OCITarballOutput{ClaimName: "foo", Path: "images/foo.tar"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:       crd.OutputKindOCITarball,
+					OCITarball: crd.OCITarballOutput{Path: "images/foo.tar"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:       crd.OutputKindOCITarball,
+					OCITarball: crd.OCITarballOutput{ClaimName: "foo", Path: "images/"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind: crd.OutputKindHTTPUpload,
+					HTTPUpload: crd.HTTPUploadOutput{
+						URL:              "https://example.com/foo.tar",
+						HeadersSecretRef: corev1.LocalObjectReference{Name: "headers"},
+					},
+				},
+			},
+			expectedFile:    "/cbi-output/image.tar",
+			expectedWrapper: "output --file /cbi-output/image.tar --upload-url https://example.com/foo.tar --headers-dir /cbi-outputheaders --",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:       crd.OutputKindHTTPUpload,
+					HTTPUpload: crd.HTTPUploadOutput{URL: "https://example.com/foo.tar"},
+				},
+			},
+			format:          pluginapi.OutputFormatDocker,
+			expectedFile:    "/cbi-output/image.tar",
+			expectedWrapper: "output --file /cbi-output/image.tar --upload-url https://example.com/foo.tar --",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{
+					Kind:       crd.OutputKindHTTPUpload,
+					HTTPUpload: crd.HTTPUploadOutput{URL: "ftp://example.com/foo.tar"},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{Kind: "foo"},
+			},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		podSpec := corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name:    "foo",
+					Command: []string{"builder", "build"},
+				},
+			},
+		}
+		injector := cbipluginhelper.Injector{
+			Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+			TargetPodSpec: &podSpec,
+		}
+		format := tc.format
+		if format == "" {
+			format = pluginapi.OutputFormatOCI
+		}
+		file, err := Inject(injector, tc.spec, format)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			if tc.expectedFile != file {
+				t.Fatalf("%d: expected %q, got %q", i, tc.expectedFile, file)
+			}
+			command := strings.Join(podSpec.Containers[0].Command, " ")
+			if tc.expectedWrapper == "" {
+				if command != "builder build" {
+					t.Fatalf("%d: unexpected command: %q", i, command)
+				}
+			} else if !strings.HasSuffix(command, " "+tc.expectedWrapper+" builder build") {
+				t.Fatalf("%d: unexpected command: %q", i, command)
+			}
+		}
+	}
+}
+
+func TestLabels(t *testing.T) {
+	ociTarball := pluginapi.LOutput(crd.OutputKindOCITarball)
+	dockerTarball := pluginapi.LOutput(crd.OutputKindDockerTarball)
+	httpUpload := pluginapi.LOutput(crd.OutputKindHTTPUpload)
+	oci := Labels(pluginapi.OutputFormatOCI)
+	if _, ok := oci[ociTarball]; !ok {
+		t.Fatalf("expected %q, got %v", ociTarball, oci)
+	}
+	if _, ok := oci[dockerTarball]; ok {
+		t.Fatalf("unexpected %q: %v", dockerTarball, oci)
+	}
+	docker := Labels(pluginapi.OutputFormatDocker)
+	if _, ok := docker[ociTarball]; ok {
+		t.Fatalf("unexpected %q: %v", ociTarball, docker)
+	}
+	if _, ok := docker[dockerTarball]; !ok {
+		t.Fatalf("expected %q, got %v", dockerTarball, docker)
+	}
+	if _, ok := docker[httpUpload]; !ok || docker[pluginapi.LOutputFormat] != pluginapi.OutputFormatDocker {
+		t.Fatalf("unexpected labels: %v", docker)
+	}
+}
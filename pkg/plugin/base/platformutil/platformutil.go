@@ -0,0 +1,40 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package platformutil provides the validation of the platforms of the multi-platform builds.
+package platformutil
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Join validates platforms (e.g. "linux/amd64") and returns the comma-separated list
+// for the backends (e.g. `buildctl --frontend-opt platform=` and `buildah bud --platform`).
+// Empty string is returned when platforms is empty.
+func Join(platforms []string) (string, error) {
+	seen := make(map[string]struct{})
+	for i, p := range platforms {
+		if p == "" || strings.ContainsAny(p, ", ") {
+			return "", fmt.Errorf("platforms[%d]: invalid platform %q", i, p)
+		}
+		if _, ok := seen[p]; ok {
+			return "", fmt.Errorf("platforms[%d]: duplicated platform %q", i, p)
+		}
+		seen[p] = struct{}{}
+	}
+	return strings.Join(platforms, ","), nil
+}
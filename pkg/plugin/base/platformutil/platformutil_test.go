@@ -0,0 +1,51 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package platformutil
+
+import (
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	testCases := []struct {
+		platforms   []string
+		expected    string
+		expectedErr bool
+	}{
+		{platforms: nil, expected: ""},
+		{platforms: []string{"linux/amd64"}, expected: "linux/amd64"},
+		{platforms: []string{"linux/amd64", "linux/arm/v7"}, expected: "linux/amd64,linux/arm/v7"},
+		{platforms: []string{""}, expectedErr: true},
+		{platforms: []string{"linux/amd64,linux/arm64"}, expectedErr: true},
+		{platforms: []string{"linux/amd64 linux/arm64"}, expectedErr: true},
+		{platforms: []string{"linux/arm64", "linux/arm64"}, expectedErr: true},
+	}
+	for i, tc := range testCases {
+		actual, err := Join(tc.platforms)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			}
+			if tc.expected != actual {
+				t.Fatalf("%d: expected %q, got %q", i, tc.expected, actual)
+			}
+		}
+	}
+}
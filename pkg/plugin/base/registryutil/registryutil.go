@@ -17,10 +17,38 @@ limitations under the License.
 package registryutil
 
 import (
+	"fmt"
+
 	"github.com/cyphar/filepath-securejoin"
 	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
 )
 
+// SecretRef returns the .dockerconfigjson secret to be injected for pushing the image
+// and for pushing and pulling the cache.
+// Name of the returned reference is empty when no secret needs to be injected.
+func SecretRef(spec crd.BuildJobSpec) (corev1.LocalObjectReference, error) {
+	var ref corev1.LocalObjectReference
+	if spec.Registry.Push {
+		ref = spec.Registry.SecretRef
+	}
+	if spec.Cache.Ref != "" {
+		cacheRef := spec.Cache.SecretRef
+		if cacheRef.Name == "" {
+			cacheRef = spec.Registry.SecretRef
+		}
+		if ref.Name != "" && cacheRef.Name != "" && ref.Name != cacheRef.Name {
+			return ref, fmt.Errorf("cache.secretRef (%q) needs to be same as registry.secretRef (%q)",
+				cacheRef.Name, ref.Name)
+		}
+		if cacheRef.Name != "" {
+			ref = cacheRef
+		}
+	}
+	return ref, nil
+}
+
 // InjectRegistrySecret injects .dockerconfigjson secret to ~/.docker/config.json
 func InjectRegistrySecret(podSpec *corev1.PodSpec, containerIdx int, homeDir string, secretRef corev1.LocalObjectReference) error {
 	volMountPath, err := securejoin.SecureJoin(homeDir, ".docker")
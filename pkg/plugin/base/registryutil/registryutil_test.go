@@ -0,0 +1,94 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package registryutil
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+)
+
+func TestSecretRef(t *testing.T) {
+	testCases := []struct {
+		spec        crd.BuildJobSpec
+		expected    string
+		expectedErr bool
+	}{
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			},
+			expected: "",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Push: true, SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			},
+			expected: "foo",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+				Cache:    crd.BuildCache{Ref: "example.com/foo:buildcache"},
+			},
+			expected: "foo",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+				Cache:    crd.BuildCache{Ref: "example.com/foo:buildcache", SecretRef: corev1.LocalObjectReference{Name: "bar"}},
+			},
+			expected: "bar",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Push: true},
+				Cache:    crd.BuildCache{Ref: "example.com/foo:buildcache", SecretRef: corev1.LocalObjectReference{Name: "bar"}},
+			},
+			expected: "bar",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Push: true, SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+				Cache:    crd.BuildCache{Ref: "example.com/foo:buildcache", SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+			},
+			expected: "foo",
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Registry: crd.Registry{Push: true, SecretRef: corev1.LocalObjectReference{Name: "foo"}},
+				Cache:    crd.BuildCache{Ref: "example.com/foo:buildcache", SecretRef: corev1.LocalObjectReference{Name: "bar"}},
+			},
+			expectedErr: true,
+		},
+	}
+	for i, tc := range testCases {
+		ref, err := SecretRef(tc.spec)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%d: error is expected", i)
+			} else if tc.expected != ref.Name {
+				t.Fatalf("%d: expected %q, got %q", i, tc.expected, ref.Name)
+			}
+		}
+	}
+}
@@ -0,0 +1,74 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/containerbuilding/cbi/pkg/plugin"
+	api "github.com/containerbuilding/cbi/pkg/plugin/api"
+)
+
+// Mux demultiplexes the requests to Services using the plugin.MetadataPluginName gRPC metadata.
+type Mux struct {
+	// Services is keyed by the plugin name.
+	Services map[string]*Service
+}
+
+var _ api.PluginServer = &Mux{}
+
+func (m *Mux) service(ctx context.Context) (*Service, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	names := md[plugin.MetadataPluginName]
+	if len(names) == 0 {
+		// for compatibility with clients unaware of the metadata
+		if len(m.Services) == 1 {
+			for _, s := range m.Services {
+				return s, nil
+			}
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "gRPC metadata %q is required", plugin.MetadataPluginName)
+	}
+	if len(names) > 1 {
+		return nil, status.Errorf(codes.InvalidArgument, "gRPC metadata %q must not be specified multiple times", plugin.MetadataPluginName)
+	}
+	s, ok := m.Services[names[0]]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown plugin: %q", names[0])
+	}
+	return s, nil
+}
+
+func (m *Mux) Info(ctx context.Context, req *api.InfoRequest) (*api.InfoResponse, error) {
+	s, err := m.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Info(ctx, req)
+}
+
+func (m *Mux) Spec(ctx context.Context, req *api.SpecRequest) (*api.SpecResponse, error) {
+	s, err := m.service(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.Spec(ctx, req)
+}
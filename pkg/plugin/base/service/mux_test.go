@@ -0,0 +1,111 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	"github.com/containerbuilding/cbi/pkg/plugin"
+	api "github.com/containerbuilding/cbi/pkg/plugin/api"
+)
+
+type dummyBackend struct {
+	name string
+}
+
+func (b *dummyBackend) Info(ctx context.Context, req *api.InfoRequest) (*api.InfoResponse, error) {
+	return &api.InfoResponse{
+		Labels: map[string]string{
+			api.LPluginName: b.name,
+		},
+	}, nil
+}
+
+func (b *dummyBackend) CreatePodTemplateSpec(ctx context.Context, bj crd.BuildJob) (*corev1.PodTemplateSpec, error) {
+	return &corev1.PodTemplateSpec{}, nil
+}
+
+func TestMux(t *testing.T) {
+	mux := &Mux{
+		Services: map[string]*Service{
+			"foo": {Backend: &dummyBackend{name: "foo"}},
+			"bar": {Backend: &dummyBackend{name: "bar"}},
+		},
+	}
+	testCases := []struct {
+		md          metadata.MD
+		expected    string
+		expectedErr bool
+	}{
+		{
+			md:       metadata.Pairs(plugin.MetadataPluginName, "foo"),
+			expected: "foo",
+		},
+		{
+			md:       metadata.Pairs(plugin.MetadataPluginName, "bar"),
+			expected: "bar",
+		},
+		{
+			md:          metadata.Pairs(plugin.MetadataPluginName, "baz"),
+			expectedErr: true,
+		},
+		{
+			md:          metadata.Pairs(plugin.MetadataPluginName, "foo", plugin.MetadataPluginName, "bar"),
+			expectedErr: true,
+		},
+		{
+			// ambiguous
+			md:          metadata.MD{},
+			expectedErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		ctx := metadata.NewIncomingContext(context.Background(), tc.md)
+		res, err := mux.Info(ctx, &api.InfoRequest{})
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%v: %v", tc.md, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%v: error is expected", tc.md)
+			} else if actual := res.Labels[api.LPluginName]; actual != tc.expected {
+				t.Fatalf("%v: expected %q, got %q", tc.md, tc.expected, actual)
+			}
+		}
+	}
+}
+
+func TestMuxSingle(t *testing.T) {
+	mux := &Mux{
+		Services: map[string]*Service{
+			"foo": {Backend: &dummyBackend{name: "foo"}},
+		},
+	}
+	// the metadata can be omitted when only a single service is registered
+	res, err := mux.Info(context.Background(), &api.InfoRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actual := res.Labels[api.LPluginName]; actual != "foo" {
+		t.Fatalf("expected %q, got %q", "foo", actual)
+	}
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net"
+	"strings"
 
 	"github.com/golang/glog"
 	"google.golang.org/grpc"
@@ -34,7 +35,9 @@ type Service struct {
 	Backend base.Backend
 }
 
-func ServeTCP(s *Service, port int) error {
+// ServeTCP serves s on the TCP port.
+// s is typically *Service or *Mux.
+func ServeTCP(s api.PluginServer, port int) error {
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return err
@@ -54,6 +57,9 @@ func (s *Service) Spec(ctx context.Context, req *api.SpecRequest) (*api.SpecResp
 	if err := json.Unmarshal(req.BuildJobJson, &buildJob); err != nil {
 		return nil, err
 	}
+	if err := s.checkFeatures(ctx, buildJob); err != nil {
+		return nil, err
+	}
 	sp, err := s.Backend.CreatePodTemplateSpec(ctx, buildJob)
 	if err != nil {
 		return nil, err
@@ -67,3 +73,40 @@ func (s *Service) Spec(ctx context.Context, req *api.SpecRequest) (*api.SpecResp
 	}
 	return res, nil
 }
+
+// checkFeatures rejects buildJob when it uses the features that are not
+// present in the labels of the backend.
+func (s *Service) checkFeatures(ctx context.Context, buildJob crd.BuildJob) error {
+	outputKind := buildJob.Spec.Output.Kind
+	features := []struct {
+		label string
+		used  bool
+		desc  string
+	}{
+		{api.LNamedContexts, len(buildJob.Spec.Contexts) > 0, "named contexts (Spec.Contexts)"},
+		{api.LBuildSecrets, len(buildJob.Spec.BuildSecrets) > 0, "build secrets (Spec.BuildSecrets)"},
+		{api.LSSH, buildJob.Spec.SSH.SecretRef.Name != "", "SSH forwarding (Spec.SSH)"},
+		{api.LBuildCache, buildJob.Spec.Cache.Ref != "", "remote build cache (Spec.Cache)"},
+		{api.LMultiPlatform, len(buildJob.Spec.Platforms) > 0, "multi-platform builds (Spec.Platforms)"},
+		{api.LOutput(outputKind),
+			outputKind != "" && !strings.EqualFold(string(outputKind), string(crd.OutputKindRegistry)),
+			fmt.Sprintf("output kind %q (Spec.Output.Kind)", outputKind)},
+	}
+	var info *api.InfoResponse
+	for _, f := range features {
+		if !f.used {
+			continue
+		}
+		if info == nil {
+			var err error
+			info, err = s.Backend.Info(ctx, &api.InfoRequest{})
+			if err != nil {
+				return err
+			}
+		}
+		if _, ok := info.Labels[f.label]; !ok {
+			return fmt.Errorf("plugin %q does not support %s", info.Labels[api.LPluginName], f.desc)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	crd "github.com/containerbuilding/cbi/pkg/apis/cbi/v1alpha1"
+	api "github.com/containerbuilding/cbi/pkg/plugin/api"
+)
+
+type featuresBackend struct {
+	dummyBackend
+	features []string
+}
+
+func (b *featuresBackend) Info(ctx context.Context, req *api.InfoRequest) (*api.InfoResponse, error) {
+	res, err := b.dummyBackend.Info(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range b.features {
+		res.Labels[f] = ""
+	}
+	return res, nil
+}
+
+func TestSpecFeatures(t *testing.T) {
+	testCases := []struct {
+		spec        crd.BuildJobSpec
+		features    []string
+		expectedErr bool
+	}{
+		{
+			spec: crd.BuildJobSpec{},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Contexts: []crd.NamedContext{
+					{Name: "assets", Context: crd.Context{Kind: crd.ContextKindGit}},
+				},
+			},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Contexts: []crd.NamedContext{
+					{Name: "assets", Context: crd.Context{Kind: crd.ContextKindGit}},
+				},
+			},
+			features: []string{api.LNamedContexts},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				BuildSecrets: []crd.BuildSecret{{ID: "npmrc"}},
+			},
+			features:    []string{api.LNamedContexts},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				BuildSecrets: []crd.BuildSecret{{ID: "npmrc"}},
+			},
+			features: []string{api.LBuildSecrets},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				SSH: crd.SSH{SecretRef: corev1.LocalObjectReference{Name: "ssh"}},
+			},
+			features:    []string{api.LBuildSecrets},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				SSH: crd.SSH{SecretRef: corev1.LocalObjectReference{Name: "ssh"}},
+			},
+			features: []string{api.LSSH},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Cache: crd.BuildCache{Ref: "example.com/foo:buildcache"},
+			},
+			features:    []string{api.LSSH},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Cache: crd.BuildCache{Ref: "example.com/foo:buildcache"},
+			},
+			features: []string{api.LBuildCache},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Platforms: []string{"linux/amd64", "linux/arm64"},
+			},
+			features:    []string{api.LBuildCache},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Platforms: []string{"linux/amd64", "linux/arm64"},
+			},
+			features: []string{api.LMultiPlatform},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{Kind: crd.OutputKindRegistry},
+			},
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{Kind: crd.OutputKindOCITarball},
+			},
+			features:    []string{api.LOutput(crd.OutputKindHTTPUpload)},
+			expectedErr: true,
+		},
+		{
+			spec: crd.BuildJobSpec{
+				Output: crd.Output{Kind: "ocitarball"},
+			},
+			features: []string{api.LOutput(crd.OutputKindOCITarball)},
+		},
+	}
+	for i, tc := range testCases {
+		bjJSON, err := json.Marshal(crd.BuildJob{Spec: tc.spec})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Service{Backend: &featuresBackend{dummyBackend: dummyBackend{name: "foo"}, features: tc.features}}
+		_, err = s.Spec(context.Background(), &api.SpecRequest{BuildJobJson: bjJSON})
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if err == nil && tc.expectedErr {
+			t.Fatalf("%d: error is expected", i)
+		}
+	}
+}
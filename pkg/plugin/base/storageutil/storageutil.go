@@ -0,0 +1,125 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package storageutil provides the persistent storage for the local image store of the backends.
+package storageutil
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+)
+
+// Storage is the PersistentVolumeClaim mounted on the local image store (e.g. /var/lib/containers/storage),
+// so that the base images and the layers are preserved across the builds.
+type Storage struct {
+	// ClaimName is the PersistentVolumeClaim in the namespace of the BuildJob.
+	// The store is not persisted when ClaimName is empty.
+	// The volume needs to support flock(2), as the builds sharing the store are serialized with the lock.
+	ClaimName string
+	// PerRepository uses the sub directory for the repository of Registry.Target,
+	// e.g. "example.com/foo/bar" for "example.com/foo/bar:latest".
+	// The builds for different repositories do not block each other, but share no layer.
+	PerRepository bool
+}
+
+// lockFileName is created under the mount path.
+const lockFileName = "cbi.lock"
+
+// Inject mounts the volume on mountPath of the target container, and wraps the command of the
+// container with `cbipluginhelper lock`.
+// The volume named volName is replaced when it already exists in the pod spec.
+// Inject MUST be called after the command is fully composed.
+// Inject does nothing when ClaimName is empty.
+func (s Storage) Inject(injector cbipluginhelper.Injector, volName, mountPath, target string) error {
+	if s.ClaimName == "" {
+		return nil
+	}
+	var subPath string
+	if s.PerRepository {
+		var err error
+		subPath, err = repository(target)
+		if err != nil {
+			return err
+		}
+	}
+	podSpec := injector.TargetPodSpec
+	container := &podSpec.Containers[injector.TargetContainerIdx]
+	if len(container.Command) == 0 {
+		return fmt.Errorf("container %q has no command", container.Name)
+	}
+	vol := corev1.Volume{
+		Name: volName,
+		VolumeSource: corev1.VolumeSource{
+			PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: s.ClaimName,
+			},
+		},
+	}
+	replaced := false
+	for i := range podSpec.Volumes {
+		if podSpec.Volumes[i].Name == volName {
+			podSpec.Volumes[i] = vol
+			replaced = true
+		}
+	}
+	if !replaced {
+		podSpec.Volumes = append(podSpec.Volumes, vol)
+	}
+	mounted := false
+	for i := range container.VolumeMounts {
+		if container.VolumeMounts[i].Name == volName {
+			container.VolumeMounts[i].MountPath = mountPath
+			container.VolumeMounts[i].SubPath = subPath
+			mounted = true
+		}
+	}
+	if !mounted {
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      volName,
+			MountPath: mountPath,
+			SubPath:   subPath,
+		})
+	}
+	helperPath, err := injector.InjectFile("/cbipluginhelper")
+	if err != nil {
+		return err
+	}
+	container.Command = append([]string{helperPath, "lock",
+		"--file", path.Join(mountPath, lockFileName),
+		"--"}, container.Command...)
+	return nil
+}
+
+// repository returns the repository of the image reference, without the tag and the digest.
+func repository(target string) (string, error) {
+	repo := target
+	if i := strings.Index(repo, "@"); i >= 0 {
+		repo = repo[:i]
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	if repo == "" || repo == "." || repo == ".." || strings.HasPrefix(repo, "../") ||
+		path.IsAbs(repo) || path.Clean(repo) != repo {
+		return "", fmt.Errorf("invalid target for the storage sub directory: %q", target)
+	}
+	return repo, nil
+}
@@ -0,0 +1,103 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storageutil
+
+import (
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/containerbuilding/cbi/pkg/plugin/base/cbipluginhelper"
+)
+
+func TestRepository(t *testing.T) {
+	testCases := []struct {
+		target      string
+		expected    string
+		expectedErr bool
+	}{
+		{target: "foo", expected: "foo"},
+		{target: "foo:latest", expected: "foo"},
+		{target: "example.com:5000/foo/bar", expected: "example.com:5000/foo/bar"},
+		{target: "example.com:5000/foo/bar:latest", expected: "example.com:5000/foo/bar"},
+		{target: "example.com/foo@sha256:deadbeef", expected: "example.com/foo"},
+		{target: "", expectedErr: true},
+		{target: "/foo", expectedErr: true},
+		{target: "../foo", expectedErr: true},
+		{target: "example.com/../../foo", expectedErr: true},
+		{target: "..:latest", expectedErr: true},
+	}
+	for _, tc := range testCases {
+		repo, err := repository(tc.target)
+		if err != nil && !tc.expectedErr {
+			t.Fatalf("%q: %v", tc.target, err)
+		}
+		if err == nil {
+			if tc.expectedErr {
+				t.Fatalf("%q: error is expected", tc.target)
+			} else if tc.expected != repo {
+				t.Fatalf("%q: expected %q, got %q", tc.target, tc.expected, repo)
+			}
+		}
+	}
+}
+
+func TestInject(t *testing.T) {
+	podSpec := corev1.PodSpec{
+		Containers: []corev1.Container{
+			{
+				Name:    "foo",
+				Command: []string{"/docker-build-push.sh", "/context"},
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "storage", MountPath: "/var/lib/containers/storage"},
+				},
+			},
+		},
+		Volumes: []corev1.Volume{
+			{Name: "storage", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		},
+	}
+	injector := cbipluginhelper.Injector{
+		Helper:        cbipluginhelper.Helper{Image: "helper", HomeDir: "/root"},
+		TargetPodSpec: &podSpec,
+	}
+	s := Storage{ClaimName: "foo-claim", PerRepository: true}
+	if err := s.Inject(injector, "storage", "/var/lib/containers/storage", "example.com/foo:latest"); err != nil {
+		t.Fatal(err)
+	}
+	// the storage volume and the helper volume
+	if len(podSpec.Volumes) != 2 {
+		t.Fatalf("expected 2 volumes, got %+v", podSpec.Volumes)
+	}
+	if pvc := podSpec.Volumes[0].PersistentVolumeClaim; pvc == nil || pvc.ClaimName != "foo-claim" || podSpec.Volumes[0].EmptyDir != nil {
+		t.Fatalf("unexpected volume: %+v", podSpec.Volumes[0])
+	}
+	if m := podSpec.Containers[0].VolumeMounts[0]; m.SubPath != "example.com/foo" {
+		t.Fatalf("unexpected volume mount: %+v", m)
+	}
+	command := strings.Join(podSpec.Containers[0].Command, " ")
+	if !strings.HasSuffix(command, " lock --file /var/lib/containers/storage/cbi.lock -- /docker-build-push.sh /context") {
+		t.Fatalf("unexpected command: %q", command)
+	}
+	if err := (Storage{}).Inject(injector, "bar", "/bar", "example.com/foo:latest"); err != nil {
+		t.Fatal(err)
+	}
+	if len(podSpec.Volumes) != 2 {
+		t.Fatalf("expected no volume to be injected, got %+v", podSpec.Volumes)
+	}
+}
@@ -2,3 +2,8 @@ package plugin
 
 // DefaultPort for CBI Plugin gRPC API
 const DefaultPort = 12111
+
+// MetadataPluginName is the gRPC metadata key for specifying the plugin name.
+// Required when multiple plugins are served on a single port. (e.g. `cbi-plugins`)
+// The value is the name of the plugin, that corresponds to the `plugin.name` label.
+const MetadataPluginName = "cbi-plugin-name"
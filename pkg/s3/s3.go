@@ -0,0 +1,256 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3 provides a minimal client for S3-compatible object storages
+// such as Amazon S3 and MinIO.
+//
+// Only the requests signed with AWS Signature Version 4 are supported,
+// so as to avoid depending on the huge AWS SDK.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultRegion is used when Config.Region is empty.
+const DefaultRegion = "us-east-1"
+
+// Config is the configuration of Client.
+type Config struct {
+	// Endpoint is the URL of the storage, e.g. "https://s3.amazonaws.com" or "http://minio:9000".
+	Endpoint string
+	// Region defaults to DefaultRegion.
+	Region string
+	// VirtualHostedStyle sends the requests to "BUCKET.ENDPOINT/KEY" rather than "ENDPOINT/BUCKET/KEY".
+	// Amazon S3 supports both, while MinIO requires the path-style requests by default.
+	VirtualHostedStyle bool
+	Credentials
+}
+
+// Credentials is the AWS-style credentials.
+// Anonymous requests are sent when AccessKeyID is empty.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is optional.
+	SessionToken string
+}
+
+// CredentialsFromEnv returns the credentials from $AWS_ACCESS_KEY_ID,
+// $AWS_SECRET_ACCESS_KEY, and $AWS_SESSION_TOKEN.
+func CredentialsFromEnv() Credentials {
+	return Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+}
+
+// Client is the S3 client.
+type Client struct {
+	endpoint           *url.URL
+	region             string
+	virtualHostedStyle bool
+	creds              Credentials
+	HTTPClient         *http.Client
+}
+
+// New creates a client.
+func New(cfg Config) (*Client, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid endpoint %q: scheme must be http or https", cfg.Endpoint)
+	}
+	region := cfg.Region
+	if region == "" {
+		region = DefaultRegion
+	}
+	return &Client{
+		endpoint:           u,
+		region:             region,
+		virtualHostedStyle: cfg.VirtualHostedStyle,
+		creds:              cfg.Credentials,
+		HTTPClient:         http.DefaultClient,
+	}, nil
+}
+
+// Error is the error returned from the storage.
+type Error struct {
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("s3: %d %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// IsNotFound returns true if err is NoSuchKey or NoSuchBucket.
+func IsNotFound(err error) bool {
+	e, ok := err.(*Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// PutObject puts the object.
+func (c *Client) PutObject(ctx context.Context, bucket, key string, body []byte, contentType string) error {
+	req, err := c.newRequest(ctx, "PUT", bucket, key, nil, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	return nil
+}
+
+// GetObject gets the object. The caller needs to close the reader.
+func (c *Client) GetObject(ctx context.Context, bucket, key string) (io.ReadCloser, error) {
+	req, err := c.newRequest(ctx, "GET", bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// Object is an entry of ListObjects.
+type Object struct {
+	Key  string `xml:"Key"`
+	Size int64  `xml:"Size"`
+	ETag string `xml:"ETag"`
+}
+
+// ListObjects lists all the objects with the prefix, using ListObjectsV2.
+func (c *Client) ListObjects(ctx context.Context, bucket, prefix string) ([]Object, error) {
+	var (
+		objects []Object
+		token   string
+	)
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		req, err := c.newRequest(ctx, "GET", bucket, "", query, nil)
+		if err != nil {
+			return nil, err
+		}
+		res, err := c.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Contents              []Object `xml:"Contents"`
+			IsTruncated           bool     `xml:"IsTruncated"`
+			NextContinuationToken string   `xml:"NextContinuationToken"`
+		}
+		err = xml.NewDecoder(res.Body).Decode(&result)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, result.Contents...)
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		if result.NextContinuationToken == "" {
+			return nil, fmt.Errorf("s3: truncated result without continuation token")
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// ObjectURL returns the URL of the object.
+func (c *Client) ObjectURL(bucket, key string) string {
+	u := c.objectURL(bucket, key)
+	return u.String()
+}
+
+// objectURL returns the URL of the object, or the bucket when key is empty.
+func (c *Client) objectURL(bucket, key string) url.URL {
+	u := *c.endpoint
+	p := strings.TrimSuffix(u.Path, "/")
+	if c.virtualHostedStyle {
+		u.Host = bucket + "." + u.Host
+	} else {
+		p += "/" + bucket
+	}
+	if key != "" || c.virtualHostedStyle {
+		p += "/" + key
+	}
+	u.Path = p
+	return u
+}
+
+func (c *Client) newRequest(ctx context.Context, method, bucket, key string, query url.Values, body []byte) (*http.Request, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("s3: empty bucket")
+	}
+	u := c.objectURL(bucket, key)
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	h := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(h[:]))
+	return req, nil
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.creds.AccessKeyID != "" {
+		Sign(req, c.creds, c.region, time.Now())
+	}
+	res, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode/100 == 2 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	e := &Error{StatusCode: res.StatusCode}
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, 64*1024))
+	if xml.Unmarshal(b, e) != nil || e.Code == "" {
+		e.Code = http.StatusText(res.StatusCode)
+	}
+	return nil, e
+}
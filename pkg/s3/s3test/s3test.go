@@ -0,0 +1,175 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3test provides an in-memory S3-compatible server for testing,
+// as a stand-in for MinIO.
+package s3test
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/containerbuilding/cbi/pkg/s3"
+)
+
+// Server is the in-memory S3-compatible server.
+// Only the path-style PutObject, GetObject, and ListObjectsV2 are supported.
+type Server struct {
+	*httptest.Server
+	// Credentials is used for verifying the requests.
+	// Anonymous requests are accepted when AccessKeyID is empty.
+	Credentials s3.Credentials
+	// MaxKeys is the page size of ListObjectsV2. Defaults to 1000.
+	MaxKeys int
+
+	mu      sync.Mutex
+	buckets map[string]map[string][]byte
+}
+
+// NewServer starts the server with the buckets.
+// The caller needs to call Close.
+func NewServer(creds s3.Credentials, buckets ...string) *Server {
+	s := &Server{
+		Credentials: creds,
+		buckets:     make(map[string]map[string][]byte),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string][]byte)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Object returns the content of the object.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[bucket][key]
+	return b, ok
+}
+
+// PutObject puts the object without sending a request.
+func (s *Server) PutObject(bucket, key string, content []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string][]byte)
+	}
+	s.buckets[bucket][key] = content
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}{Code: code, Message: message})
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.Credentials.AccessKeyID != "" {
+		if err := s3.Verify(r, s.Credentials); err != nil {
+			writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", err.Error())
+			return
+		}
+	}
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	s.mu.Lock()
+	objects, ok := s.buckets[bucket]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if len(parts) != 2 || parts[1] == "" {
+		if r.Method == "GET" && r.URL.Query().Get("list-type") == "2" {
+			s.listObjects(w, r, objects)
+			return
+		}
+		writeError(w, http.StatusNotImplemented, "NotImplemented", "bucket operations other than ListObjectsV2 are not implemented")
+		return
+	}
+	key := parts[1]
+	switch r.Method {
+	case "PUT":
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		s.mu.Lock()
+		objects[key] = b
+		s.mu.Unlock()
+	case "GET":
+		s.mu.Lock()
+		b, ok := objects[key]
+		s.mu.Unlock()
+		if !ok {
+			writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+			return
+		}
+		w.Write(b)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, objects map[string][]byte) {
+	q := r.URL.Query()
+	prefix, token := q.Get("prefix"), q.Get("continuation-token")
+	maxKeys := s.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = 1000
+	}
+	s.mu.Lock()
+	var keys []string
+	for k := range objects {
+		// the continuation token is the last key of the previous page
+		if strings.HasPrefix(k, prefix) && k > token {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	type content struct {
+		Key  string `xml:"Key"`
+		Size int    `xml:"Size"`
+	}
+	result := struct {
+		XMLName               xml.Name  `xml:"ListBucketResult"`
+		Contents              []content `xml:"Contents"`
+		IsTruncated           bool      `xml:"IsTruncated"`
+		NextContinuationToken string    `xml:"NextContinuationToken,omitempty"`
+	}{}
+	for i, k := range keys {
+		if i == maxKeys {
+			result.IsTruncated = true
+			result.NextContinuationToken = keys[i-1]
+			break
+		}
+		result.Contents = append(result.Contents, content{Key: k, Size: len(objects[k])})
+	}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(result)
+}
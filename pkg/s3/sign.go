@@ -0,0 +1,200 @@
+/*
+Copyright The CBI Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signAlgorithm       = "AWS4-HMAC-SHA256"
+	amzDateFormat       = "20060102T150405Z"
+	unsignedPayload     = "UNSIGNED-PAYLOAD"
+	headerAmzDate       = "X-Amz-Date"
+	headerAmzSHA256     = "X-Amz-Content-Sha256"
+	headerAmzToken      = "X-Amz-Security-Token"
+	headerAuthorization = "Authorization"
+)
+
+// Sign signs req with AWS Signature Version 4, by setting the Authorization header.
+// The payload hash is taken from the X-Amz-Content-Sha256 header, which defaults to UNSIGNED-PAYLOAD.
+// All the headers of req are signed.
+func Sign(req *http.Request, creds Credentials, region string, t time.Time) {
+	t = t.UTC()
+	req.Header.Set(headerAmzDate, t.Format(amzDateFormat))
+	if req.Header.Get(headerAmzSHA256) == "" {
+		req.Header.Set(headerAmzSHA256, unsignedPayload)
+	}
+	if creds.SessionToken != "" {
+		req.Header.Set(headerAmzToken, creds.SessionToken)
+	}
+	req.Header.Del(headerAuthorization)
+	signedHeaders, signature := signature(req, nil, creds.SecretAccessKey, region, t)
+	scope := credentialScope(t, region)
+	req.Header.Set(headerAuthorization, fmt.Sprintf("%s Credential=%s/%s,SignedHeaders=%s,Signature=%s",
+		signAlgorithm, creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func credentialScope(t time.Time, region string) string {
+	return t.Format("20060102") + "/" + region + "/s3/aws4_request"
+}
+
+// Verify verifies the Authorization header of req signed by Sign.
+// Verify is used by the stand-in server for testing, see the s3test package.
+func Verify(req *http.Request, creds Credentials) error {
+	t, err := time.Parse(amzDateFormat, req.Header.Get(headerAmzDate))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", headerAmzDate, err)
+	}
+	auth := req.Header.Get(headerAuthorization)
+	if !strings.HasPrefix(auth, signAlgorithm+" ") {
+		return fmt.Errorf("unsupported authorization: %q", auth)
+	}
+	fields := make(map[string]string)
+	for _, f := range strings.Split(strings.TrimPrefix(auth, signAlgorithm+" "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(f), "=", 2)
+		if len(kv) == 2 {
+			fields[kv[0]] = kv[1]
+		}
+	}
+	cred := strings.SplitN(fields["Credential"], "/", 5)
+	if len(cred) != 5 || cred[0] != creds.AccessKeyID || cred[1] != t.Format("20060102") {
+		return fmt.Errorf("invalid credential: %q", fields["Credential"])
+	}
+	region := cred[2]
+	_, expected := signature(req, strings.Split(fields["SignedHeaders"], ";"), creds.SecretAccessKey, region, t)
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return fmt.Errorf("signature mismatch")
+	}
+	return nil
+}
+
+// signature returns the signed headers and the signature of req.
+// When signedHeaders is nil, all the headers are signed.
+func signature(req *http.Request, signedHeaders []string, secretAccessKey, region string, t time.Time) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for k, v := range req.Header {
+		k = strings.ToLower(k)
+		if k == "authorization" || k == "user-agent" || k == "content-length" {
+			continue
+		}
+		if signedHeaders != nil && !contains(signedHeaders, k) {
+			continue
+		}
+		var vv []string
+		for _, s := range v {
+			vv = append(vv, strings.Join(strings.Fields(s), " "))
+		}
+		headers[k] = strings.Join(vv, ",")
+	}
+	var keys []string
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var canonicalHeaders strings.Builder
+	for _, k := range keys {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req),
+		canonicalHeaders.String(),
+		strings.Join(keys, ";"),
+		req.Header.Get(headerAmzSHA256),
+	}, "\n")
+	stringToSign := strings.Join([]string{
+		signAlgorithm,
+		t.Format(amzDateFormat),
+		credentialScope(t, region),
+		hexSHA256(canonicalRequest),
+	}, "\n")
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), t.Format("20060102"))
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return strings.Join(keys, ";"), hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func contains(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalQuery(req *http.Request) string {
+	q := req.URL.Query()
+	var keys []string
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		vv := q[k]
+		sort.Strings(vv)
+		for _, v := range vv {
+			pairs = append(pairs, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode encodes s as specified in the Signature Version 4 documents.
+// "/" is encoded only when encodeSlash is true.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:])
+}